@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"round-robin-api/internal/balancer"
+	"round-robin-api/internal/grpcwire"
+	"round-robin-api/internal/logger"
+	"round-robin-api/internal/upstream"
+)
+
+// isGRPC reports whether the request is a gRPC call. gRPC needs HTTP/2
+// end to end, so calls over HTTP/1 are proxied like any other request.
+func isGRPC(r *http.Request) bool {
+	return r.ProtoMajor == 2 && grpcwire.IsGRPC(r.Header)
+}
+
+// recordGRPCOutcome feeds the status a gRPC call ended with to the
+// backend's circuit breaker: only statuses that point at the backend count
+// as failures
+func (lb *LoadBalancer) recordGRPCOutcome(backend *balancer.Backend, code grpcwire.Code) {
+	lb.metrics.RecordGRPCStatus(code)
+	if code.BackendFault() {
+		backend.Breaker.RecordFailure()
+	} else {
+		backend.Breaker.RecordSuccess()
+	}
+}
+
+// grpcCall records a gRPC call's outcome once its response body has been
+// read to the end and the grpc-status trailer has arrived
+type grpcCall struct {
+	io.ReadCloser
+	resp    *http.Response
+	ctx     context.Context
+	backend *balancer.Backend
+	lb      *LoadBalancer
+	once    sync.Once
+}
+
+func (c *grpcCall) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if err != nil {
+		c.once.Do(func() { c.finish(err) })
+	}
+	return n, err
+}
+
+func (c *grpcCall) finish(err error) {
+	if err != io.EOF {
+		c.backend.Breaker.RecordError(upstream.Classify(c.ctx, err))
+		return
+	}
+	code, ok := grpcwire.Status(c.resp.Trailer)
+	if !ok {
+		// A call that ends without a status didn't finish properly
+		code = grpcwire.Unknown
+	}
+	c.lb.recordGRPCOutcome(c.backend, code)
+}
+
+// grpcCode is the status a call is failed with when the balancer couldn't
+// get an answer from a backend
+func grpcCode(kind upstream.Kind) grpcwire.Code {
+	switch kind {
+	case upstream.Timeout, upstream.ClientDeadline:
+		return grpcwire.DeadlineExceeded
+	case upstream.ClientCancelled:
+		return grpcwire.Canceled
+	}
+	return grpcwire.Unavailable
+}
+
+// serveGRPC proxies one gRPC call to a backend of the pool. Every call is
+// balanced on its own, even when the client sends them all down one
+// connection. Request and response messages are streamed in both
+// directions and the backend's trailers passed on, so unary and streaming
+// calls alike work. Calls aren't retried or hedged, as their bodies aren't
+// buffered; a call is only bounded by its own grpc-timeout.
+func (lb *LoadBalancer) serveGRPC(w http.ResponseWriter, r *http.Request, pool *balancer.Pool, path string, contextLogger *logger.ContextLogger, requestID string) {
+	// Over HTTP/1.1 the call's trailers, and with them its status, would be
+	// lost; gRPC backends don't speak it anyway
+	if protocol := pool.Settings().Protocol; protocol == balancer.ProtocolHTTP1 {
+		contextLogger.Error("gRPC call routed to pool %s, which speaks %s; gRPC needs %s or %s", pool.Name(), protocol, balancer.ProtocolHTTP2, balancer.ProtocolH2C)
+		lb.metrics.RecordGRPCStatus(grpcwire.Unimplemented)
+		grpcwire.WriteStatus(w, grpcwire.Unimplemented, "Pool "+pool.Name()+" doesn't serve gRPC")
+		return
+	}
+
+	pinned := lb.PinnedBackend(pool, r)
+	backend := pinned
+	if backend == nil {
+		backend = lb.NextBackend(pool, r)
+		if backend == nil {
+			contextLogger.Error("No healthy backends available in pool %s", pool.Name())
+			lb.metrics.RecordGRPCStatus(grpcwire.Unavailable)
+			grpcwire.WriteStatus(w, grpcwire.Unavailable, "No healthy backends available")
+			return
+		}
+	}
+
+	// The server's timeouts are meant for ordinary requests, not for
+	// messages a streaming call sends over minutes
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+	if timeout, ok := grpcwire.ParseTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		deadline := time.AfterFunc(timeout, func() { cancel(upstream.ErrClientDeadline) })
+		defer deadline.Stop()
+	}
+
+	contextLogger.Debug("Forwarding gRPC call to backend: %s%s", backend.URL, path)
+	resp, err := lb.forwardRequest(pool, backend, r.WithContext(ctx), path, r.Body)
+	if err != nil {
+		kind := upstream.KindOf(err)
+		if kind.BackendFault() {
+			contextLogger.Error("Backend error: %v", err)
+		} else {
+			contextLogger.Warn("gRPC call failed: %v", err)
+			lb.metrics.RecordErrorKind(kind)
+		}
+		code := grpcCode(kind)
+		lb.metrics.RecordGRPCStatus(code)
+		grpcwire.WriteStatus(w, code, "Backend error: "+string(kind))
+		return
+	}
+	defer resp.Body.Close()
+
+	lb.copyResponseHeaders(w, resp, pool, backend, pinned, requestID)
+	w.WriteHeader(resp.StatusCode)
+
+	if err := streamResponse(w, resp, 0, func() {}); err != nil {
+		kind := upstream.Classify(ctx, err)
+		lb.metrics.RecordErrorKind(kind)
+		contextLogger.Warn("gRPC call to %s ended early: %s: %v", backend.URL, kind, err)
+		if kind == upstream.ClientCancelled {
+			return
+		}
+		// The headers are out; the call can still be failed in the trailers
+		code := grpcCode(kind)
+		lb.metrics.RecordGRPCStatus(code)
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(int(code)))
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", "Backend error: "+string(kind))
+		return
+	}
+
+	for key, values := range resp.Trailer {
+		w.Header()[http.TrailerPrefix+key] = values
+	}
+	contextLogger.Debug("gRPC call completed")
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"round-robin-api/internal/balancer"
+	"round-robin-api/internal/grpcwire"
+)
+
+// grpcRequest returns a gRPC call as an HTTP/2 server hands it over
+func grpcRequest(method string, message []byte) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, method, bytes.NewReader(grpcwire.Frame(message)))
+	r.ProtoMajor, r.ProtoMinor = 2, 0
+	r.Header.Set("Content-Type", "application/grpc")
+	r.Header.Set("Te", "trailers")
+	return r
+}
+
+func TestServeGRPC_HTTP1Pool(t *testing.T) {
+	backend, hits := countingBackend(t, http.StatusOK)
+	lb := newTestLB(t, balancer.PoolSettings{}, nil, backend.URL)
+
+	w := httptest.NewRecorder()
+	lb.HandleProxy(w, grpcRequest("/echo.Echo/Say", []byte("hi")))
+	if got := w.Header().Get("Grpc-Status"); got != strconv.Itoa(int(grpcwire.Unimplemented)) {
+		t.Errorf("Expected UNIMPLEMENTED for a gRPC call to an http1 pool, got %q", got)
+	}
+	if hits.Load() != 0 {
+		t.Error("Expected the call not to reach the backend")
+	}
+}
+
+// grpcBackend serves gRPC calls over h2c: /echo.Echo/Say echoes its
+// message, /echo.Echo/Fail fails with UNAVAILABLE and /echo.Echo/Hang
+// doesn't answer until the call is cancelled
+func grpcBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		message, err := grpcwire.ReadFrame(r.Body)
+		if err != nil {
+			grpcwire.WriteStatus(w, grpcwire.Internal, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		switch r.URL.Path {
+		case "/echo.Echo/Say":
+			w.Write(grpcwire.Frame(message))
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		case "/echo.Echo/Fail":
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(int(grpcwire.Unavailable)))
+		case "/echo.Echo/Hang":
+			<-r.Context().Done()
+		}
+	}))
+	backend.Config.Protocols = new(http.Protocols)
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	backend.Start()
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func grpcStatuses(lb *LoadBalancer) map[string]uint64 {
+	return lb.metrics.GetMetrics()["grpc_statuses"].(map[string]uint64)
+}
+
+func TestServeGRPC_Statuses(t *testing.T) {
+	backend := grpcBackend(t)
+	lb := newTestLB(t, balancer.PoolSettings{Protocol: balancer.ProtocolH2C}, nil, backend.URL)
+
+	w := httptest.NewRecorder()
+	lb.HandleProxy(w, grpcRequest("/echo.Echo/Say", []byte("hi")))
+	resp := w.Result()
+	message, err := grpcwire.ReadFrame(resp.Body)
+	if err != nil || string(message) != "hi" {
+		t.Errorf("Expected the message echoed, got %q, %v", message, err)
+	}
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("Expected OK in the trailers, got %q", got)
+	}
+
+	// A status from the backend is passed on in the trailers
+	w = httptest.NewRecorder()
+	lb.HandleProxy(w, grpcRequest("/echo.Echo/Fail", []byte("hi")))
+	if got := w.Result().Trailer.Get("Grpc-Status"); got != strconv.Itoa(int(grpcwire.Unavailable)) {
+		t.Errorf("Expected UNAVAILABLE in the trailers, got %q", got)
+	}
+
+	if statuses := grpcStatuses(lb); statuses["OK"] != 1 || statuses["UNAVAILABLE"] != 1 {
+		t.Errorf("Expected one OK and one UNAVAILABLE call, got %v", statuses)
+	}
+}
+
+func TestServeGRPC_BackendErrors(t *testing.T) {
+	// Calls the balancer couldn't get answered are failed with a status
+	// of its own
+	lb := newTestLB(t, balancer.PoolSettings{Protocol: balancer.ProtocolH2C}, nil, refusedURL(t))
+	w := httptest.NewRecorder()
+	lb.HandleProxy(w, grpcRequest("/echo.Echo/Say", []byte("hi")))
+	if got := w.Header().Get("Grpc-Status"); got != strconv.Itoa(int(grpcwire.Unavailable)) {
+		t.Errorf("Expected UNAVAILABLE for a refused connection, got %q", got)
+	}
+
+	lb = newTestLB(t, balancer.PoolSettings{Protocol: balancer.ProtocolH2C}, nil, grpcBackend(t).URL)
+	r := grpcRequest("/echo.Echo/Hang", []byte("hi"))
+	r.Header.Set("Grpc-Timeout", "50m")
+	start := time.Now()
+	w = httptest.NewRecorder()
+	lb.HandleProxy(w, r)
+	if got := w.Header().Get("Grpc-Status"); got != strconv.Itoa(int(grpcwire.DeadlineExceeded)) {
+		t.Errorf("Expected DEADLINE_EXCEEDED once the call's grpc-timeout ran out, got %q", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the call to end after its 50ms timeout, took %v", elapsed)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"round-robin-api/internal/admin"
+	"round-robin-api/internal/balancer"
+	"round-robin-api/internal/config"
+	"round-robin-api/internal/forward"
+	"round-robin-api/internal/logger"
+	"round-robin-api/internal/metrics"
+	"round-robin-api/internal/router"
+)
+
+type LoadBalancer struct {
+	sync.RWMutex
+	pools map[string]*balancer.Pool
+	// poolDefaults applies to every pool unless its config overrides it
+	poolDefaults balancer.PoolSettings
+	routes       *router.Table
+	sticky       *balancer.StickySessions
+	// trustedProxies may report the client's address in X-Forwarded-For
+	trustedProxies forward.TrustedProxies
+	// proxyProtocolTrusted may name the client in a PROXY protocol header;
+	// empty means every peer must send one
+	proxyProtocolTrusted forward.TrustedProxies
+	metrics              *metrics.Metrics
+	logger               *logger.Logger
+	// listeners maps each TCP and UDP listener, e.g. "udp listener :53", to
+	// the pool it relays to
+	listeners map[string]string
+	// serverTimeout is the shorter of the client read and write timeouts;
+	// the server cuts off requests that take longer, whatever their pool's
+	// total timeout
+	serverTimeout time.Duration
+
+	// tunnels counts open upgraded and relayed TCP connections; closing
+	// closeTunnels tears them down when draining runs out of time
+	tunnels          atomic.Int64
+	closeTunnels     chan struct{}
+	closeTunnelsOnce sync.Once
+}
+
+func NewLoadBalancer(routes *router.Table, poolDefaults balancer.PoolSettings, appLogger *logger.Logger) *LoadBalancer {
+	return &LoadBalancer{
+		pools:        make(map[string]*balancer.Pool),
+		poolDefaults: poolDefaults,
+		routes:       routes,
+		listeners:    make(map[string]string),
+		metrics:      metrics.NewMetrics(),
+		logger:       appLogger,
+		closeTunnels: make(chan struct{}),
+	}
+}
+
+// AddPool creates a pool from its config and registers it
+func (lb *LoadBalancer) AddPool(cfg config.PoolConfig) error {
+	lb.Lock()
+	defer lb.Unlock()
+
+	if _, exists := lb.pools[cfg.Name]; exists {
+		return fmt.Errorf("pool already exists: %s", cfg.Name)
+	}
+	pool, err := balancer.NewPool(cfg.Name, cfg.Backends, cfg.Settings(lb.poolDefaults), lb.logger)
+	if err != nil {
+		return fmt.Errorf("pool %s: %v", cfg.Name, err)
+	}
+	lb.pools[cfg.Name] = pool
+
+	settings := pool.Settings()
+	lb.logger.Info("Pool %s: %d backends, %s balancing strategy, %v timeout", cfg.Name, len(cfg.Backends), settings.Strategy, settings.Timeouts.Total)
+	lb.checkTotal(pool)
+	return nil
+}
+
+// longestTotal returns the longest total timeout of the HTTP pools
+func (lb *LoadBalancer) longestTotal() time.Duration {
+	lb.RLock()
+	defer lb.RUnlock()
+
+	var longest time.Duration
+	for _, pool := range lb.pools {
+		settings := pool.Settings()
+		if !balancer.Layer4(settings.Protocol) {
+			longest = max(longest, settings.Timeouts.Total)
+		}
+	}
+	return longest
+}
+
+// setServerTimeout records the shorter of the server's read and write
+// timeouts and warns about pools whose requests it would cut short
+func (lb *LoadBalancer) setServerTimeout(timeout time.Duration) {
+	lb.Lock()
+	defer lb.Unlock()
+
+	lb.serverTimeout = timeout
+	for _, pool := range lb.pools {
+		lb.checkTotal(pool)
+	}
+}
+
+// checkTotal warns when the server timeout is shorter than the pool's total
+// timeout. Streams, tunnels and gRPC calls lift the server's deadlines
+// themselves; buffered responses don't.
+func (lb *LoadBalancer) checkTotal(pool *balancer.Pool) {
+	settings := pool.Settings()
+	if lb.serverTimeout > 0 && !balancer.Layer4(settings.Protocol) && settings.Timeouts.Total >= lb.serverTimeout {
+		lb.logger.Warn("Pool %s: requests are cut off by the %v server timeout before their %v timeout; raise SERVER_READ_TIMEOUT and SERVER_WRITE_TIMEOUT", pool.Name(), lb.serverTimeout, settings.Timeouts.Total)
+	}
+}
+
+// RemovePool removes a pool that no route points at and stops its health checks
+func (lb *LoadBalancer) RemovePool(name string) error {
+	lb.Lock()
+	defer lb.Unlock()
+
+	if name == balancer.DefaultPool {
+		return fmt.Errorf("the %s pool cannot be removed", balancer.DefaultPool)
+	}
+	pool, ok := lb.pools[name]
+	if !ok {
+		return fmt.Errorf("unknown pool: %s", name)
+	}
+	for _, route := range lb.routes.Routes() {
+		if route.Pool == name {
+			return fmt.Errorf("pool %s is used by route %s", name, route)
+		}
+	}
+	for listener, poolName := range lb.listeners {
+		if poolName == name {
+			return fmt.Errorf("pool %s is used by %s", name, listener)
+		}
+	}
+
+	delete(lb.pools, name)
+	pool.Close()
+	lb.logger.Info("Removed pool %s", name)
+	return nil
+}
+
+// Pool returns the named pool, or nil if it doesn't exist
+func (lb *LoadBalancer) Pool(name string) *balancer.Pool {
+	lb.RLock()
+	defer lb.RUnlock()
+	return lb.pools[name]
+}
+
+// GetPool returns the named pool for the admin API
+func (lb *LoadBalancer) GetPool(name string) (admin.LoadBalancer, bool) {
+	pool := lb.Pool(name)
+	if pool == nil {
+		return nil, false
+	}
+	return pool, true
+}
+
+// GetPoolStatus returns the status of every pool, sorted by name
+func (lb *LoadBalancer) GetPoolStatus() []balancer.PoolStatus {
+	lb.RLock()
+	pools := make([]*balancer.Pool, 0, len(lb.pools))
+	for _, pool := range lb.pools {
+		pools = append(pools, pool)
+	}
+	lb.RUnlock()
+
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Name() < pools[j].Name() })
+	statuses := make([]balancer.PoolStatus, len(pools))
+	for i, pool := range pools {
+		statuses[i] = pool.Status()
+	}
+	return statuses
+}
+
+// GetRoutes returns the routing table in match order
+func (lb *LoadBalancer) GetRoutes() []router.Route {
+	return lb.routes.Routes()
+}
+
+// SetRoutes replaces the routing table; every route must point at an
+// existing HTTP pool
+func (lb *LoadBalancer) SetRoutes(routes []router.Route) error {
+	lb.Lock()
+	defer lb.Unlock()
+
+	if err := lb.checkRoutes(routes); err != nil {
+		return err
+	}
+	if err := lb.routes.SetRoutes(routes); err != nil {
+		return err
+	}
+	lb.logger.Info("Routing table updated: %d routes", len(routes))
+	return nil
+}
+
+// CheckRoutes checks that every route points at an existing pool that
+// serves HTTP
+func (lb *LoadBalancer) CheckRoutes(routes []router.Route) error {
+	lb.RLock()
+	defer lb.RUnlock()
+	return lb.checkRoutes(routes)
+}
+
+// checkRoutes is CheckRoutes with the lock held
+func (lb *LoadBalancer) checkRoutes(routes []router.Route) error {
+	for _, route := range routes {
+		pool, ok := lb.pools[route.Pool]
+		if !ok {
+			return fmt.Errorf("route %s: unknown pool %s", route, route.Pool)
+		}
+		if protocol := pool.Settings().Protocol; balancer.Layer4(protocol) {
+			return fmt.Errorf("route %s: pool %s relays %s and cannot serve HTTP routes", route, route.Pool, protocol)
+		}
+	}
+	return nil
+}
+
+// addListener returns the pool a TCP or UDP listener relays to, which must
+// use the listener's protocol, and records that the pool is in use. Must be
+// called with the lock held.
+func (lb *LoadBalancer) addListener(protocol string, cfg config.ListenerConfig) (*balancer.Pool, error) {
+	name := fmt.Sprintf("%s listener %s", protocol, cfg.Listen)
+	pool, ok := lb.pools[cfg.Pool]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown pool %s", name, cfg.Pool)
+	}
+	if pool.Settings().Protocol != protocol {
+		return nil, fmt.Errorf("%s: pool %s must use protocol %s", name, cfg.Pool, protocol)
+	}
+	lb.listeners[name] = cfg.Pool
+	return pool, nil
+}
+
+// NextBackend picks a backend from the pool for the request
+func (lb *LoadBalancer) NextBackend(pool *balancer.Pool, r *http.Request) *balancer.Backend {
+	backend := pool.NextBackend(r)
+	if backend == nil {
+		return nil
+	}
+	lb.recordPick(backend)
+	return backend
+}
+
+// recordPick records metrics for a backend chosen to serve a request
+func (lb *LoadBalancer) recordPick(backend *balancer.Backend) {
+	lb.metrics.RecordRequest(backend.URL)
+	lb.metrics.RecordCircuitState(backend.URL, backend.Breaker.GetState())
+}
+
+// PinnedBackend returns the backend named by the request's sticky session
+// cookie, as long as it is still in the pool, healthy and its circuit is
+// available. It returns nil when sticky sessions are off or the client must
+// be (re)assigned through NextBackend.
+func (lb *LoadBalancer) PinnedBackend(pool *balancer.Pool, r *http.Request) *balancer.Backend {
+	if lb.sticky == nil {
+		return nil
+	}
+	url, ok := lb.sticky.Backend(r, pool.Name())
+	if !ok {
+		return nil
+	}
+
+	pinned := pool.AvailableBackend(url)
+	if pinned == nil {
+		return nil
+	}
+	lb.recordPick(pinned)
+	return pinned
+}
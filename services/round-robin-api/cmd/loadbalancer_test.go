@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"round-robin-api/internal/balancer"
+	"round-robin-api/internal/config"
+)
+
+func TestLongestTotal(t *testing.T) {
+	settings := balancer.PoolSettings{Timeouts: balancer.Timeouts{Total: 2 * time.Second}}
+	lb := newTestLB(t, settings, nil, "http://localhost:8001")
+
+	slow := config.PoolConfig{
+		Name:     "slow",
+		Backends: []balancer.BackendConfig{{URL: "http://localhost:8002"}},
+		Timeouts: config.TimeoutsConfig{Total: config.Duration(30 * time.Second)},
+	}
+	if err := lb.AddPool(slow); err != nil {
+		t.Fatalf("Failed to add pool: %v", err)
+	}
+	defer lb.Pool("slow").Close()
+
+	// Layer 4 pools don't go through the HTTP server
+	relay := config.PoolConfig{
+		Name:     "relay",
+		Backends: []balancer.BackendConfig{{URL: "tcp://localhost:6379"}},
+		Protocol: balancer.ProtocolTCP,
+		Timeouts: config.TimeoutsConfig{Total: config.Duration(time.Minute)},
+	}
+	if err := lb.AddPool(relay); err != nil {
+		t.Fatalf("Failed to add pool: %v", err)
+	}
+	defer lb.Pool("relay").Close()
+
+	if got := lb.longestTotal(); got != 30*time.Second {
+		t.Errorf("Expected the slow pool's 30s, got %v", got)
+	}
+}
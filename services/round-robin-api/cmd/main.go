@@ -2,291 +2,262 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"crypto/rand"
+	"crypto/tls"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
-	"sync"
-	"sync/atomic"
 	"syscall"
 	"time"
 
 	"round-robin-api/internal/admin"
-	"round-robin-api/internal/circuit"
+	"round-robin-api/internal/balancer"
+	"round-robin-api/internal/certs"
+	"round-robin-api/internal/config"
+	"round-robin-api/internal/forward"
 	"round-robin-api/internal/logger"
-	"round-robin-api/internal/metrics"
+	"round-robin-api/internal/proxyproto"
+	"round-robin-api/internal/router"
 )
 
-type Backend struct {
-	URL       string
-	breaker   *circuit.CircuitBreaker
-	isHealthy bool
-}
-
-type LoadBalancer struct {
-	sync.RWMutex
-	Backends      []Backend
-	currIndex    uint64
-	healthChecker *circuit.HealthChecker
-	metrics      *metrics.Metrics
-	client       *http.Client
-	logger       *logger.Logger
-}
+// serverTimeoutMargin is how much longer than the longest pool total the
+// server's read and write timeouts are by default
+const serverTimeoutMargin = time.Second
 
-func NewLoadBalancer(urls []string) *LoadBalancer {
-	backends := make([]Backend, len(urls))
-	healthChecker := circuit.NewHealthChecker()
-	metricsCollector := metrics.NewMetrics()
+func main() {
 	appLogger := logger.New(logger.INFO)
 
-	for i, u := range urls {
-		backends[i] = Backend{
-			URL:       u,
-			breaker:   circuit.NewCircuitBreaker(),
-			isHealthy: true,
+	var fileConfig config.Config
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		loaded, err := config.Load(path)
+		if err != nil {
+			appLogger.Fatal("Invalid CONFIG_FILE: %v", err)
 		}
-		// Start health checking for this backend
-		healthChecker.StartChecking(u, time.Second*5) // Check every 5 seconds
-		appLogger.Info("Added backend: %s", u)
-	}
-
-	return &LoadBalancer{
-		Backends:      backends,
-		healthChecker: healthChecker,
-		metrics:      metricsCollector,
-		logger:       appLogger,
-		client: &http.Client{
-			Timeout: time.Second * 2, // 2 second timeout for requests
-			Transport: &http.Transport{
-				MaxIdleConns:        100,
-				MaxIdleConnsPerHost: 10,
-				IdleConnTimeout:     90 * time.Second,
-				DisableKeepAlives:   false,
-			},
-		},
+		fileConfig = *loaded
+		appLogger.Info("Loaded %d pools, %d routes and %d TCP and %d UDP listeners from %s", len(fileConfig.Pools), len(fileConfig.Routes), len(fileConfig.TCP), len(fileConfig.UDP), path)
 	}
-}
 
-// AddBackend adds a new backend to the load balancer
-func (lb *LoadBalancer) AddBackend(url string) {
-	lb.Lock()
-	defer lb.Unlock()
-
-	// Check if backend already exists
-	for _, b := range lb.Backends {
-		if b.URL == url { // URL is already normalized by admin layer
-			lb.logger.Warn("Backend already exists: %s", url)
-			return // Already exists
+	poolConfigs := fileConfig.Pools
+	if backendEnv := os.Getenv("BACKENDS"); backendEnv != "" {
+		var configs []balancer.BackendConfig
+		for _, spec := range strings.Split(backendEnv, ",") {
+			cfg, err := balancer.ParseBackendSpec(spec)
+			if err != nil {
+				appLogger.Fatal("Invalid BACKENDS entry %q: %v", spec, err)
+			}
+			configs = append(configs, cfg)
 		}
+		for _, pool := range poolConfigs {
+			if pool.Name == balancer.DefaultPool {
+				appLogger.Fatal("Pool %q is defined by both BACKENDS and CONFIG_FILE", balancer.DefaultPool)
+			}
+		}
+		poolConfigs = append([]config.PoolConfig{{Name: balancer.DefaultPool, Backends: configs}}, poolConfigs...)
 	}
 
-	backend := Backend{
-		URL:       url,
-		breaker:   circuit.NewCircuitBreaker(),
-		isHealthy: true,
+	poolDefaults := balancer.PoolSettings{
+		Strategy: os.Getenv("STRATEGY"),
+		Options: balancer.Options{
+			HashKey: os.Getenv("HASH_KEY"),
+		},
+	}
+	if _, err := balancer.New(poolDefaults.Strategy, poolDefaults.Options); err != nil {
+		appLogger.Fatal("Invalid STRATEGY: %v", err)
 	}
-	lb.Backends = append(lb.Backends, backend)
-	lb.healthChecker.StartChecking(url, time.Second*5)
-	lb.logger.Info("Added new backend: %s", url)
-}
-
-// RemoveBackend removes a backend from the load balancer
-func (lb *LoadBalancer) RemoveBackend(url string) {
-	lb.Lock()
-	defer lb.Unlock()
 
-	initialCount := len(lb.Backends)
-	newBackends := make([]Backend, 0)
-	for _, b := range lb.Backends {
-		if b.URL != url { // URL is already normalized by admin layer
-			newBackends = append(newBackends, b)
+	if factor := os.Getenv("PRIORITY_OVERPROVISIONING"); factor != "" {
+		value, err := strconv.ParseFloat(factor, 64)
+		if err != nil || value < 0 {
+			appLogger.Fatal("Invalid PRIORITY_OVERPROVISIONING: %q", factor)
 		}
+		poolDefaults.Overprovisioning = value
 	}
-	lb.Backends = newBackends
-	
-	if len(lb.Backends) < initialCount {
-		lb.logger.Info("Removed backend: %s", url)
-	} else {
-		lb.logger.Warn("Backend not found for removal: %s", url)
-	}
-}
-
-// GetBackends returns a list of backend URLs
-func (lb *LoadBalancer) GetBackends() []string {
-	lb.RLock()
-	defer lb.RUnlock()
 
-	urls := make([]string, len(lb.Backends))
-	for i, backend := range lb.Backends {
-		urls[i] = backend.URL
+	// The server's read and write timeouts also bound every buffered request,
+	// so unless SERVER_READ_TIMEOUT and SERVER_WRITE_TIMEOUT are set they are
+	// raised to fit the longest TIMEOUT_TOTAL or pool total below
+	poolDefaults.Timeouts = balancer.DefaultTimeouts()
+	durationEnv(appLogger, "TIMEOUT_CONNECT", &poolDefaults.Timeouts.Connect)
+	durationEnv(appLogger, "TIMEOUT_TLS_HANDSHAKE", &poolDefaults.Timeouts.TLSHandshake)
+	durationEnv(appLogger, "TIMEOUT_RESPONSE_HEADER", &poolDefaults.Timeouts.ResponseHeader)
+	durationEnv(appLogger, "TIMEOUT_TOTAL", &poolDefaults.Timeouts.Total)
+	durationEnv(appLogger, "TIMEOUT_IDLE", &poolDefaults.Timeouts.Idle)
+	durationEnv(appLogger, "TIMEOUT_HEALTH_CHECK", &poolDefaults.Timeouts.HealthCheck)
+	durationEnv(appLogger, "TIMEOUT_STREAM_IDLE", &poolDefaults.Timeouts.StreamIdle)
+	durationEnv(appLogger, "TIMEOUT_SESSION_IDLE", &poolDefaults.Timeouts.SessionIdle)
+
+	poolDefaults.Protocol = os.Getenv("UPSTREAM_PROTOCOL")
+	if err := balancer.ValidateProtocol(poolDefaults.Protocol); err != nil {
+		appLogger.Fatal("Invalid UPSTREAM_PROTOCOL: %v", err)
 	}
-	return urls
-}
 
-func (lb *LoadBalancer) NextBackend() *Backend {
-	lb.RLock()
-	defer lb.RUnlock()
-
-	if len(lb.Backends) == 0 {
-		return nil
-	}
-
-	// Try up to N times to find a healthy backend
-	maxAttempts := len(lb.Backends)
-	for i := 0; i < maxAttempts; i++ {
-		idx := atomic.AddUint64(&lb.currIndex, 1) % uint64(len(lb.Backends))
-		backend := &lb.Backends[idx]
-		
-		// Check if backend is healthy and circuit is available
-		if lb.healthChecker.IsHealthy(backend.URL) && backend.breaker.IsAvailable() {
-			// Record metrics
-			lb.metrics.RecordRequest(backend.URL)
-			lb.metrics.RecordCircuitState(backend.URL, backend.breaker.GetState())
-			return backend
-		}
+	poolDefaults.HealthCheck = balancer.HealthCheckSettings{
+		Type:    os.Getenv("HEALTH_CHECK_TYPE"),
+		Service: os.Getenv("HEALTH_CHECK_SERVICE"),
+		Send:    os.Getenv("HEALTH_CHECK_SEND"),
+		Expect:  os.Getenv("HEALTH_CHECK_EXPECT"),
 	}
-	return nil
-}
-
-func (lb *LoadBalancer) forwardRequest(backend *Backend, r *http.Request) (*http.Response, error) {
-	start := time.Now()
-	
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(r.Context(), time.Second*2)
-	defer cancel()
-
-	// Create new request with timeout context
-	req, err := http.NewRequestWithContext(ctx, r.Method, backend.URL+"/", r.Body)
-	if err != nil {
-		lb.metrics.RecordRequestComplete(r.Header.Get("X-Request-ID"), backend.URL, time.Since(start), false)
-		return nil, err
+	if err := balancer.ValidateHealthCheckType(poolDefaults.HealthCheck.Type); err != nil {
+		appLogger.Fatal("Invalid HEALTH_CHECK_TYPE: %v", err)
 	}
 
-	// Copy headers
-	for key, values := range r.Header {
-		req.Header[key] = values
+	poolDefaults.TLS = balancer.TLSSettings{
+		CAFile:             os.Getenv("UPSTREAM_TLS_CA_FILE"),
+		CertFile:           os.Getenv("UPSTREAM_TLS_CERT_FILE"),
+		KeyFile:            os.Getenv("UPSTREAM_TLS_KEY_FILE"),
+		ServerName:         os.Getenv("UPSTREAM_TLS_SERVER_NAME"),
+		InsecureSkipVerify: os.Getenv("UPSTREAM_TLS_INSECURE_SKIP_VERIFY") == "true",
 	}
-
-	// Add or update request ID
-	requestID := r.Header.Get("X-Request-ID")
-	if requestID == "" {
-		requestID = fmt.Sprintf("%d", time.Now().UnixNano())
-		req.Header.Set("X-Request-ID", requestID)
+	if err := poolDefaults.TLS.Validate(); err != nil {
+		appLogger.Fatal("Invalid UPSTREAM_TLS_* settings: %v", err)
 	}
-
-	// Forward the request
-	resp, err := lb.client.Do(req)
-	duration := time.Since(start)
-	
-	if err != nil {
-		backend.breaker.RecordFailure()
-		lb.metrics.RecordRequestComplete(requestID, backend.URL, duration, false)
-		return nil, err
+	if poolDefaults.TLS.InsecureSkipVerify {
+		appLogger.Warn("Backend certificates are not verified (UPSTREAM_TLS_INSECURE_SKIP_VERIFY)")
 	}
 
-	success := resp.StatusCode < 500
-	if success {
-		backend.breaker.RecordSuccess()
-	} else {
-		backend.breaker.RecordFailure()
+	poolDefaults.ProxyProtocol = os.Getenv("UPSTREAM_PROXY_PROTOCOL")
+	if err := proxyproto.ValidateVersion(poolDefaults.ProxyProtocol); err != nil {
+		appLogger.Fatal("Invalid UPSTREAM_PROXY_PROTOCOL: %v", err)
 	}
 
-	lb.metrics.RecordRequestComplete(requestID, backend.URL, duration, success)
-	return resp, nil
-}
+	if interval := os.Getenv("FLUSH_INTERVAL"); interval != "" {
+		value, err := time.ParseDuration(interval)
+		if err != nil || value < 0 {
+			appLogger.Fatal("Invalid FLUSH_INTERVAL: %q", interval)
+		}
+		poolDefaults.FlushInterval = value
+	}
 
-func main() {
-	appLogger := logger.New(logger.INFO)
-	
-	backendEnv := os.Getenv("BACKENDS")
-	if backendEnv == "" {
-		appLogger.Fatal("BACKENDS env var required")
+	poolDefaults.Retries = 1
+	if retries := os.Getenv("RETRIES"); retries != "" {
+		value, err := strconv.Atoi(retries)
+		if err != nil || value < 0 {
+			appLogger.Fatal("Invalid RETRIES: %q", retries)
+		}
+		poolDefaults.Retries = value
+	}
+	if budget := os.Getenv("RETRY_BUDGET"); budget != "" {
+		value, err := strconv.ParseFloat(budget, 64)
+		if err != nil || value <= 0 || value > 1 {
+			appLogger.Fatal("Invalid RETRY_BUDGET: %q", budget)
+		}
+		poolDefaults.RetryBudget = value
 	}
-	urls := strings.Split(backendEnv, ",")
-	appLogger.Info("Starting load balancer with %d backends", len(urls))
-	
-	lb := NewLoadBalancer(urls)
 
-	// Create admin server
-	adminServer := admin.NewAdminServer(lb.metrics, lb)
+	if percentile := os.Getenv("HEDGE_PERCENTILE"); percentile != "" {
+		value, err := strconv.ParseFloat(percentile, 64)
+		if err != nil || value < 0 || value >= 1 {
+			appLogger.Fatal("Invalid HEDGE_PERCENTILE: %q", percentile)
+		}
+		poolDefaults.Hedge.Percentile = value
+	}
+	if minDelay := os.Getenv("HEDGE_MIN_DELAY"); minDelay != "" {
+		value, err := time.ParseDuration(minDelay)
+		if err != nil || value < 0 {
+			appLogger.Fatal("Invalid HEDGE_MIN_DELAY: %q", minDelay)
+		}
+		poolDefaults.Hedge.MinDelay = value
+	}
 
-	// Main API endpoint
-	http.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
-		// Add request ID for tracing
-		requestID := r.Header.Get("X-Request-ID")
-		if requestID == "" {
-			requestID = fmt.Sprintf("req-%d", time.Now().UnixNano())
+	slowStart := &poolDefaults.SlowStart
+	if window := os.Getenv("SLOW_START_WINDOW"); window != "" {
+		var err error
+		if slowStart.Window, err = time.ParseDuration(window); err != nil || slowStart.Window < 0 {
+			appLogger.Fatal("Invalid SLOW_START_WINDOW: %q", window)
+		}
+		if v := os.Getenv("SLOW_START_MIN_WEIGHT"); v != "" {
+			if slowStart.MinWeight, err = strconv.ParseFloat(v, 64); err != nil || slowStart.MinWeight <= 0 || slowStart.MinWeight > 1 {
+				appLogger.Fatal("Invalid SLOW_START_MIN_WEIGHT: %q", v)
+			}
 		}
-		contextLogger := lb.logger.WithRequestID(requestID)
-		
-		contextLogger.Debug("Received request: %s %s", r.Method, r.URL.Path)
-
-		if r.Method != http.MethodPost {
-			contextLogger.Warn("Method not allowed: %s", r.Method)
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			w.Write([]byte(`{"error":"Method not allowed"}`))
-			return
+		if v := os.Getenv("SLOW_START_AGGRESSION"); v != "" {
+			if slowStart.Aggression, err = strconv.ParseFloat(v, 64); err != nil || slowStart.Aggression <= 0 {
+				appLogger.Fatal("Invalid SLOW_START_AGGRESSION: %q", v)
+			}
 		}
+		appLogger.Info("Slow start enabled: %v window", slowStart.Window)
+	}
 
-		// Limit request body size (1MB max)
-		r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	routes, err := router.NewTable(fileConfig.Routes)
+	if err != nil {
+		appLogger.Fatal("Invalid routes: %v", err)
+	}
+	lb := NewLoadBalancer(routes, poolDefaults, appLogger)
 
-		// Validate content type
-		contentType := r.Header.Get("Content-Type")
-		if contentType != "application/json" {
-			contextLogger.Warn("Invalid content type: %s", contentType)
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(`{"error":"Content-Type must be application/json"}`))
-			return
+	for _, poolConfig := range poolConfigs {
+		if err := lb.AddPool(poolConfig); err != nil {
+			appLogger.Fatal("Invalid pool: %v", err)
 		}
+	}
 
-		backend := lb.NextBackend()
-		if backend == nil {
-			contextLogger.Error("No healthy backends available")
-			w.WriteHeader(http.StatusServiceUnavailable)
-			w.Write([]byte(`{"error":"No healthy backends available"}`))
-			return
+	defaultPool := lb.Pool(balancer.DefaultPool)
+	if defaultPool == nil {
+		appLogger.Fatal("BACKENDS env var or a %q pool in CONFIG_FILE required", balancer.DefaultPool)
+	}
+	if err := lb.CheckRoutes(fileConfig.Routes); err != nil {
+		appLogger.Fatal("Invalid routes: %v", err)
+	}
+
+	if os.Getenv("STICKY_SESSIONS") == "true" {
+		secret := []byte(os.Getenv("STICKY_SECRET"))
+		if len(secret) == 0 {
+			secret = make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				appLogger.Fatal("Failed to generate sticky session secret: %v", err)
+			}
+			appLogger.Warn("STICKY_SECRET not set, sticky cookies won't survive a restart")
 		}
+		lb.sticky = balancer.NewStickySessions(os.Getenv("STICKY_COOKIE"), secret)
+		appLogger.Info("Sticky sessions enabled")
+	}
 
-		contextLogger.Debug("Forwarding to backend: %s", backend.URL)
-		
-		resp, err := lb.forwardRequest(backend, r)
+	if spec := os.Getenv("TRUSTED_PROXIES"); spec != "" {
+		proxies, err := forward.ParseTrustedProxies(spec)
 		if err != nil {
-			if err == context.DeadlineExceeded {
-				contextLogger.Error("Backend timeout: %s", backend.URL)
-				w.WriteHeader(http.StatusGatewayTimeout)
-				w.Write([]byte(`{"error":"Backend timeout"}`))
-				return
-			}
-			contextLogger.Error("Backend error: %s - %v", backend.URL, err)
-			w.WriteHeader(http.StatusBadGateway)
-			w.Write([]byte(`{"error":"Backend error"}`))
-			return
+			appLogger.Fatal("Invalid TRUSTED_PROXIES: %v", err)
 		}
-		defer resp.Body.Close()
-
-		// Add X-Served-By header for debugging/testing
-		w.Header().Set("X-Served-By", backend.URL)
-		w.Header().Set("X-Request-ID", requestID)
-		
-		// Copy response headers
-		for key, values := range resp.Header {
-			for _, value := range values {
-				w.Header().Add(key, value)
-			}
+		lb.trustedProxies = proxies
+		appLogger.Info("Trusting X-Forwarded-For from %d proxy ranges", len(proxies))
+	}
+
+	// A load balancer in front may pass on the client's address in a PROXY
+	// protocol header instead
+	proxyProtocol := os.Getenv("PROXY_PROTOCOL") == "true"
+	if spec := os.Getenv("PROXY_PROTOCOL_TRUSTED"); spec != "" {
+		proxies, err := forward.ParseTrustedProxies(spec)
+		if err != nil {
+			appLogger.Fatal("Invalid PROXY_PROTOCOL_TRUSTED: %v", err)
 		}
-		w.WriteHeader(resp.StatusCode)
-		io.Copy(w, resp.Body)
-		
-		contextLogger.Debug("Request completed successfully")
-	})
+		lb.proxyProtocolTrusted = proxies
+		appLogger.Info("Trusting PROXY protocol headers from %d proxy ranges", len(proxies))
+	}
+
+	// Create admin server
+	adminServer := admin.NewAdminServer(lb.metrics, defaultPool, lb)
+
+	// Main API endpoint
+	http.HandleFunc("/api", lb.HandleAPI)
+
+	// Reverse proxy for configured routes
+	http.HandleFunc("/", lb.HandleProxy)
 
-	// Admin API endpoints
-	http.HandleFunc("/admin/metrics", adminServer.HandleMetrics)
-	http.HandleFunc("/admin/health", adminServer.HandleHealth)
-	http.HandleFunc("/admin/backends", adminServer.HandleBackends)
+	// Admin API endpoints, served next to the proxy unless ADMIN_SOCKET
+	// moves them to a Unix socket of their own
+	adminMux := http.DefaultServeMux
+	adminSocket := os.Getenv("ADMIN_SOCKET")
+	if adminSocket != "" {
+		adminMux = http.NewServeMux()
+	}
+	adminMux.HandleFunc("/admin/metrics", adminServer.HandleMetrics)
+	adminMux.HandleFunc("/admin/health", adminServer.HandleHealth)
+	adminMux.HandleFunc("/admin/backends", adminServer.HandleBackends)
+	adminMux.HandleFunc("/admin/strategy", adminServer.HandleStrategy)
+	adminMux.HandleFunc("/admin/pools", adminServer.HandlePools)
+	adminMux.HandleFunc("/admin/routes", adminServer.HandleRoutes)
 
 	// Create HTTP server with timeouts
 	server := &http.Server{
@@ -295,28 +266,246 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
+	// Leave time to write the 504 for a request that used its whole total
+	if fit := lb.longestTotal() + serverTimeoutMargin; fit > server.WriteTimeout {
+		server.ReadTimeout, server.WriteTimeout = fit, fit
+	}
+	durationEnv(appLogger, "SERVER_READ_TIMEOUT", &server.ReadTimeout)
+	durationEnv(appLogger, "SERVER_WRITE_TIMEOUT", &server.WriteTimeout)
+	durationEnv(appLogger, "SERVER_IDLE_TIMEOUT", &server.IdleTimeout)
+	// Pools added through the admin API are only checked against them
+	lb.setServerTimeout(min(server.ReadTimeout, server.WriteTimeout))
+
+	// HTTP/2 is negotiated over TLS; cleartext HTTP/2 (h2c, with prior
+	// knowledge) has to be asked for
+	server.Protocols = new(http.Protocols)
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetHTTP2(true)
+	if os.Getenv("H2C") == "true" {
+		server.Protocols.SetUnencryptedHTTP2(true)
+		appLogger.Info("Cleartext HTTP/2 (h2c) enabled")
+	}
+
+	tlsConfig, watchCerts := loadTLSConfig(appLogger, lb)
+	// With TLS_ADDR, HTTPS gets its own listener next to plain HTTP;
+	// otherwise the main listener serves HTTPS
+	var tlsServer *http.Server
+	switch addr := os.Getenv("TLS_ADDR"); {
+	case addr == "":
+		server.TLSConfig = tlsConfig
+	case tlsConfig == nil:
+		appLogger.Fatal("TLS_ADDR needs TLS_CERT_FILE and TLS_KEY_FILE or TLS_CERTS")
+	default:
+		tlsServer = &http.Server{
+			Addr:         addr,
+			ReadTimeout:  server.ReadTimeout,
+			WriteTimeout: server.WriteTimeout,
+			IdleTimeout:  server.IdleTimeout,
+			Protocols:    server.Protocols,
+			TLSConfig:    tlsConfig,
+		}
+	}
+
+	// Unix sockets serve plain HTTP to processes on the same host
+	var unixServers []*http.Server
+	if path := os.Getenv("UNIX_SOCKET"); path != "" {
+		unixServers = append(unixServers, serveUnix(appLogger, "Round Robin API", path, server, http.DefaultServeMux))
+	}
+	if adminSocket != "" {
+		unixServers = append(unixServers, serveUnix(appLogger, "Admin API", adminSocket, server, adminMux))
+	}
+
+	// TCP and UDP listeners relay connections and datagrams next to the
+	// HTTP server
+	tcpConfigs, udpConfigs := fileConfig.TCP, fileConfig.UDP
+	if addr := os.Getenv("TCP_LISTEN"); addr != "" {
+		tcpConfigs = append([]config.ListenerConfig{{Listen: addr, Pool: balancer.DefaultPool, ProxyProtocol: proxyProtocol}}, tcpConfigs...)
+	}
+	if addr := os.Getenv("UDP_LISTEN"); addr != "" {
+		udpConfigs = append([]config.ListenerConfig{{Listen: addr, Pool: balancer.DefaultPool}}, udpConfigs...)
+	}
+	var listeners []io.Closer
+	for _, listenerConfig := range tcpConfigs {
+		listener, err := lb.ListenTCP(listenerConfig)
+		if err != nil {
+			appLogger.Fatal("Failed to start TCP listener: %v", err)
+		}
+		listeners = append(listeners, listener)
+	}
+	for _, listenerConfig := range udpConfigs {
+		listener, err := lb.ListenUDP(listenerConfig)
+		if err != nil {
+			appLogger.Fatal("Failed to start UDP listener: %v", err)
+		}
+		listeners = append(listeners, listener)
+	}
 
 	// Setup graceful shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
+	stopWatching := make(chan struct{})
+	if watchCerts != nil {
+		go watchCerts(stopWatching)
+	}
+
 	go func() {
 		appLogger.Info("Round Robin API listening on :8080")
-		appLogger.Info("Admin API available at /admin/*")
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if adminSocket == "" {
+			appLogger.Info("Admin API available at /admin/*")
+		}
+		listener, err := lb.listen(server.Addr, proxyProtocol)
+		switch {
+		case err != nil:
+		case server.TLSConfig != nil:
+			appLogger.Info("Serving HTTPS with HTTP/2")
+			err = server.ServeTLS(listener, "", "")
+		default:
+			err = server.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			appLogger.Fatal("Server failed to start: %v", err)
 		}
 	}()
 
+	if tlsServer != nil {
+		go func() {
+			appLogger.Info("Serving HTTPS with HTTP/2 on %s", tlsServer.Addr)
+			listener, err := lb.listen(tlsServer.Addr, proxyProtocol)
+			if err == nil {
+				err = tlsServer.ServeTLS(listener, "", "")
+			}
+			if err != nil && err != http.ErrServerClosed {
+				appLogger.Fatal("HTTPS server failed to start: %v", err)
+			}
+		}()
+	}
+
 	<-stop
 	appLogger.Info("Shutting down server...")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	close(stopWatching)
+	// UDP sessions end with their listener; TCP connections are drained
+	// below
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	if tlsServer != nil {
+		if err := tlsServer.Shutdown(ctx); err != nil {
+			appLogger.Error("HTTPS server forced to shutdown: %v", err)
+		}
+	}
+	for _, unixServer := range unixServers {
+		if err := unixServer.Shutdown(ctx); err != nil {
+			appLogger.Error("Server on %s forced to shutdown: %v", unixServer.Addr, err)
+		}
+	}
 	if err := server.Shutdown(ctx); err != nil {
 		appLogger.Error("Server forced to shutdown: %v", err)
 	} else {
 		appLogger.Info("Server gracefully stopped")
 	}
+
+	// Upgraded and relayed TCP connections aren't tracked by the server
+	if open := lb.tunnels.Load(); open > 0 {
+		appLogger.Info("Draining %d tunnels and TCP connections...", open)
+		if err := lb.DrainTunnels(ctx); err != nil {
+			appLogger.Warn("Closed tunnels and TCP connections still open at the shutdown deadline")
+		}
+	}
+}
+
+// loadTLSConfig loads the certificates named by TLS_CERT_FILE and
+// TLS_KEY_FILE and by TLS_CERTS, if any. It returns nil when TLS isn't
+// configured, and otherwise also a function that reloads the certificates
+// when their files change until its channel is closed.
+func loadTLSConfig(appLogger *logger.Logger, lb *LoadBalancer) (*tls.Config, func(stop <-chan struct{})) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if (certFile == "") != (keyFile == "") {
+		appLogger.Fatal("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	var pairs []certs.Pair
+	if certFile != "" {
+		pairs = append(pairs, certs.Pair{CertFile: certFile, KeyFile: keyFile})
+	}
+	more, err := certs.ParsePairs(os.Getenv("TLS_CERTS"))
+	if err != nil {
+		appLogger.Fatal("Invalid TLS_CERTS: %v", err)
+	}
+	pairs = append(pairs, more...)
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	store, err := certs.NewStore(pairs)
+	if err != nil {
+		appLogger.Fatal("Failed to load TLS certificates: %v", err)
+	}
+	store.OnLoad(lb.metrics.RecordCertificate)
+
+	minVersion := uint16(tls.VersionTLS12)
+	if name := os.Getenv("TLS_MIN_VERSION"); name != "" {
+		if minVersion, err = certs.ParseVersion(name); err != nil {
+			appLogger.Fatal("Invalid TLS_MIN_VERSION: %v", err)
+		}
+	}
+	cipherSuites, err := certs.ParseCipherSuites(os.Getenv("TLS_CIPHER_SUITES"))
+	if err != nil {
+		appLogger.Fatal("Invalid TLS_CIPHER_SUITES: %v", err)
+	}
+
+	reloadInterval := 10 * time.Second
+	durationEnv(appLogger, "TLS_RELOAD_INTERVAL", &reloadInterval)
+	appLogger.Info("Loaded %d TLS certificates, checking for changes every %v", len(pairs), reloadInterval)
+
+	watch := func(stop <-chan struct{}) {
+		store.Watch(reloadInterval, stop, appLogger)
+	}
+	return store.ServerConfig(minVersion, cipherSuites), watch
+}
+
+// serveUnix serves handler, named for logging, on a Unix socket at path
+// with the timeouts and protocols of template, replacing a socket left
+// behind by an earlier run. The socket file is removed when the server shuts
+// down.
+func serveUnix(appLogger *logger.Logger, name, path string, template *http.Server, handler http.Handler) *http.Server {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		appLogger.Fatal("Failed to listen on Unix socket %s: %v", path, err)
+	}
+
+	server := &http.Server{
+		Addr:         path,
+		Handler:      handler,
+		ReadTimeout:  template.ReadTimeout,
+		WriteTimeout: template.WriteTimeout,
+		IdleTimeout:  template.IdleTimeout,
+		Protocols:    template.Protocols,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			appLogger.Fatal("Server on %s failed: %v", path, err)
+		}
+	}()
+	appLogger.Info("%s listening on Unix socket %s", name, path)
+	return server
+}
+
+// durationEnv overrides target with the named environment variable, if set
+func durationEnv(appLogger *logger.Logger, name string, target *time.Duration) {
+	value := os.Getenv(name)
+	if value == "" {
+		return
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		appLogger.Fatal("Invalid %s: %q", name, value)
+	}
+	*target = d
 }
@@ -0,0 +1,621 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"round-robin-api/internal/balancer"
+	"round-robin-api/internal/forward"
+	"round-robin-api/internal/grpcwire"
+	"round-robin-api/internal/logger"
+	"round-robin-api/internal/proxyproto"
+	"round-robin-api/internal/upstream"
+)
+
+// maxBodyBytes limits the size of proxied request bodies (1MB)
+const maxBodyBytes = 1 << 20
+
+// cancelOnClose releases the upstream request's context once the response
+// body has been fully consumed, not when forwardRequest returns
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+// Write passes writes through to the body of an upgraded connection, which
+// is writable
+func (c *cancelOnClose) Write(p []byte) (int, error) {
+	w, ok := c.ReadCloser.(io.Writer)
+	if !ok {
+		return 0, errors.New("response body is not writable")
+	}
+	return w.Write(p)
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.once.Do(c.cancel)
+	return err
+}
+
+// bodyReader returns a fresh reader over a buffered request body, so each
+// attempt sends it from the start
+func bodyReader(body []byte) io.Reader {
+	if len(body) == 0 {
+		return nil
+	}
+	return bytes.NewReader(body)
+}
+
+// forwardRequest sends the request to the backend with the given path,
+// keeping the incoming method and query string. Requests that may be
+// replayed on another backend pass their buffered body through bodyReader.
+func (lb *LoadBalancer) forwardRequest(pool *balancer.Pool, backend *balancer.Backend, r *http.Request, path string, body io.Reader) (*http.Response, error) {
+	// The request stays outstanding until its response body is closed:
+	// under HTTP/2 many requests share a connection, so open streams rather
+	// than connections are what least-outstanding balances on
+	backend.Acquire()
+
+	strategy := pool.Strategy()
+	strategy.OnRequestStart(backend)
+	start := time.Now()
+
+	// The request's context carries the deadline set by serve; this one
+	// lets the attempt be released on its own
+	ctx, cancel := context.WithCancel(r.Context())
+	if pool.Settings().ProxyProtocol != "" {
+		ctx = proxyproto.WithHeader(ctx, clientHeader(r))
+	}
+
+	// Create new request with the attempt's context
+	target, err := targetURL(backend.URL, path, r.URL.RawQuery)
+	var req *http.Request
+	if err == nil {
+		req, err = http.NewRequestWithContext(ctx, r.Method, target.String(), body)
+	}
+	if err != nil {
+		cancel()
+		backend.Release()
+		strategy.OnRequestFinish(backend, time.Since(start), false)
+		lb.metrics.RecordRequestComplete(r.Header.Get("X-Request-ID"), backend.URL, time.Since(start), false)
+		return nil, upstream.NewError(nil, backend.URL, err)
+	}
+
+	// Send the URL as built; parsing its string again could decode it
+	req.URL = target
+
+	// Copy end-to-end headers and record this hop
+	req.Header = r.Header.Clone()
+	forward.RemoveHopByHop(req.Header)
+	if isUpgrade(r) {
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", r.Header.Get("Upgrade"))
+	}
+	if forward.HasToken(r.Header, "Te", "trailers") {
+		req.Header.Set("Te", "trailers")
+	}
+	lb.trustedProxies.SetHeaders(req.Header, r)
+
+	// Add or update request ID
+	requestID := r.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = fmt.Sprintf("%d", time.Now().UnixNano())
+		req.Header.Set("X-Request-ID", requestID)
+	}
+
+	// Forward the request
+	resp, err := pool.Client().Do(req)
+	duration := time.Since(start)
+
+	if err != nil {
+		cancel()
+		backend.Release()
+		upstreamErr := upstream.NewError(r.Context(), backend.URL, err)
+		backend.Breaker.RecordError(upstreamErr.Kind)
+		strategy.OnRequestFinish(backend, duration, false)
+		// The caller giving up, e.g. because a hedged copy answered first or
+		// the client's own deadline ran out, says nothing about the backend
+		if upstreamErr.Kind.BackendFault() {
+			backend.ObserveLatency(duration)
+			lb.metrics.RecordRequestComplete(requestID, backend.URL, duration, false)
+			lb.metrics.RecordErrorKind(upstreamErr.Kind)
+		}
+		return nil, upstreamErr
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: func() {
+		cancel()
+		backend.Release()
+	}}
+	backend.ObserveLatency(duration)
+
+	success := resp.StatusCode < 500
+	if grpcwire.IsGRPC(resp.Header) && success {
+		// A gRPC call fails with a 200 and a grpc-status; it is in the
+		// headers of a trailers-only response and otherwise only known
+		// once the body has been read
+		if code, ok := grpcwire.Status(resp.Header); ok {
+			success = !code.BackendFault()
+			lb.recordGRPCOutcome(backend, code)
+		} else {
+			resp.Body = &grpcCall{ReadCloser: resp.Body, resp: resp, ctx: r.Context(), backend: backend, lb: lb}
+		}
+	} else if success {
+		backend.Breaker.RecordSuccess()
+	} else {
+		backend.Breaker.RecordFailure()
+	}
+	strategy.OnRequestFinish(backend, duration, success)
+
+	lb.metrics.RecordRequestComplete(requestID, backend.URL, duration, success)
+	return resp, nil
+}
+
+// targetURL returns the URL a request for the given escaped path and query
+// is sent to on a backend. The path is joined to the backend's URL as it
+// is, so escapes such as %2F, %3F and %25 reach the backend as the client
+// sent them.
+func targetURL(backendURL, path, rawQuery string) (*url.URL, error) {
+	target, err := url.Parse(balancer.RequestURL(backendURL, ""))
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := url.PathUnescape(path)
+	if err != nil {
+		return nil, err
+	}
+	target.RawPath = target.EscapedPath() + path
+	target.Path += decoded
+	target.RawQuery = rawQuery
+	return target, nil
+}
+
+// clientTimeout returns the deadline the client asked for through the
+// X-Request-Timeout header ("1.5s" or a number of milliseconds), if it is
+// shorter than max
+func clientTimeout(r *http.Request, max time.Duration) (time.Duration, bool) {
+	value := r.Header.Get("X-Request-Timeout")
+	if value == "" {
+		return 0, false
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+	if timeout <= 0 || timeout >= max {
+		return 0, false
+	}
+	return timeout, true
+}
+
+// requestLogger makes sure the request carries an ID, so the same one is
+// logged, forwarded and returned to the client
+func (lb *LoadBalancer) requestLogger(r *http.Request) (*logger.ContextLogger, string) {
+	// Add request ID for tracing
+	requestID := r.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = fmt.Sprintf("req-%d", time.Now().UnixNano())
+		r.Header.Set("X-Request-ID", requestID)
+	}
+	return lb.logger.WithRequestID(requestID), requestID
+}
+
+// clientHeader describes the connection a request arrived on, for backends
+// that are sent PROXY protocol headers
+func clientHeader(r *http.Request) proxyproto.Header {
+	var header proxyproto.Header
+	if addr, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		header.Source = net.TCPAddrFromAddrPort(addr)
+	}
+	header.Destination, _ = r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return header
+}
+
+// readBody buffers the request body so it can be sent more than once,
+// leaving a fresh reader in its place for strategies that inspect it
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.ContentLength == 0 {
+		return nil, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	return body, nil
+}
+
+// shouldRetry reports whether a failed attempt may be retried on another
+// backend, as long as the request is still wanted. A request that never
+// reached the backend always may be. Other failures that are the backend's
+// fault, such as timeouts and resets, and 5xx responses may only be
+// retried for idempotent requests, as the backend may have acted on them.
+func shouldRetry(r *http.Request, resp *http.Response, err error, idempotent bool) bool {
+	if r.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		kind := upstream.KindOf(err)
+		return kind.NotSent() || (idempotent && kind.BackendFault())
+	}
+	return idempotent && resp.StatusCode >= 500
+}
+
+// writeUpstreamError answers the client for a request that failed
+func writeUpstreamError(w http.ResponseWriter, kind upstream.Kind) {
+	message := "Backend error"
+	switch kind {
+	case upstream.Timeout, upstream.ClientDeadline:
+		message = "Backend timeout"
+	case upstream.BodyTooLarge:
+		message = "Request body too large"
+	}
+	w.WriteHeader(kind.StatusCode())
+	fmt.Fprintf(w, `{"error":"%s","kind":"%s"}`, message, kind)
+}
+
+// hedgeResult is the outcome of one copy of a hedged request
+type hedgeResult struct {
+	backend *balancer.Backend
+	resp    *http.Response
+	err     error
+}
+
+// isIdempotent reports whether a request can safely be sent again after
+// an attempt failed
+func isIdempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.Header.Get("Idempotency-Key") != ""
+}
+
+// isSafe reports whether a request only reads, so that two copies of it
+// may run at once. Other idempotent requests may be retried but aren't
+// hedged, as two concurrent writes aren't the same as one after another.
+func isSafe(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// hedgedForward forwards the request and, if the backend hasn't answered
+// within the pool's hedge delay, sends a copy to another backend. The first
+// successful answer wins and the other copy is cancelled. It returns the
+// backend that answered and the hedge backend, if a copy was sent.
+func (lb *LoadBalancer) hedgedForward(pool *balancer.Pool, backend *balancer.Backend, r *http.Request, path string, body []byte, tried []*balancer.Backend) (*balancer.Backend, *balancer.Backend, *http.Response, error) {
+	settings := pool.Settings().Hedge
+	delay, ok := lb.metrics.LatencyPercentile(backend.URL, settings.Percentile)
+	if !ok {
+		// Not enough history to know what slow means for this backend
+		resp, err := lb.forwardRequest(pool, backend, r, path, bodyReader(body))
+		return backend, nil, resp, err
+	}
+	if delay < settings.MinDelay {
+		delay = settings.MinDelay
+	}
+
+	results := make(chan hedgeResult, 2)
+	cancels := make(map[*balancer.Backend]context.CancelFunc, 2)
+	send := func(b *balancer.Backend) {
+		ctx, cancel := context.WithCancel(r.Context())
+		cancels[b] = cancel
+		go func() {
+			resp, err := lb.forwardRequest(pool, b, r.WithContext(ctx), path, bodyReader(body))
+			results <- hedgeResult{backend: b, resp: resp, err: err}
+		}()
+	}
+	// finish hands the winning result back, releasing its context only once
+	// the response body is closed
+	finish := func(res hedgeResult) (*balancer.Backend, *http.Response, error) {
+		if res.err != nil {
+			cancels[res.backend]()
+			return res.backend, nil, res.err
+		}
+		res.resp.Body = &cancelOnClose{ReadCloser: res.resp.Body, cancel: cancels[res.backend]}
+		return res.backend, res.resp, nil
+	}
+
+	send(backend)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case res := <-results:
+		winner, resp, err := finish(res)
+		return winner, nil, resp, err
+	case <-timer.C:
+	}
+
+	hedge := pool.NextBackend(r, tried...)
+	if hedge == nil || !pool.RetryBudget().Withdraw() {
+		winner, resp, err := finish(<-results)
+		return winner, nil, resp, err
+	}
+	lb.recordPick(hedge)
+	lb.metrics.RecordHedge(hedge.URL)
+	send(hedge)
+
+	first := <-results
+	if shouldRetry(r, first.resp, first.err, true) {
+		// The first copy to answer failed; the other one may still succeed
+		if first.resp != nil {
+			first.resp.Body.Close()
+		}
+		cancels[first.backend]()
+		first = <-results
+	} else {
+		// Cancel the slower copy and discard whatever it returns
+		for b, cancel := range cancels {
+			if b != first.backend {
+				cancel()
+			}
+		}
+		go func() {
+			if late := <-results; late.resp != nil {
+				late.resp.Body.Close()
+			}
+		}()
+	}
+
+	if first.backend == hedge && first.err == nil {
+		lb.metrics.RecordHedgeWin(hedge.URL)
+	}
+	winner, resp, err := finish(first)
+	return winner, hedge, resp, err
+}
+
+// serve picks a backend from the pool, forwards the request to it under the
+// given path and copies the response back to the client. Failed attempts are
+// retried on other backends as far as the pool's retry settings and budget
+// allow, which for requests that aren't idempotent means only when they
+// never reached a backend. Hedgeable requests may also be hedged.
+func (lb *LoadBalancer) serve(w http.ResponseWriter, r *http.Request, pool *balancer.Pool, path string, idempotent, hedgeable bool, contextLogger *logger.ContextLogger, requestID string) {
+	body, err := readBody(r)
+	if err != nil {
+		kind := upstream.Classify(r.Context(), err)
+		lb.metrics.RecordErrorKind(kind)
+		if kind == upstream.BodyTooLarge {
+			contextLogger.Warn("Request body too large")
+			writeUpstreamError(w, kind)
+			return
+		}
+		contextLogger.Warn("Failed to read request body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"Failed to read request body"}`))
+		return
+	}
+
+	pinned := lb.PinnedBackend(pool, r)
+	backend := pinned
+	if backend == nil {
+		backend = lb.NextBackend(pool, r)
+		if backend == nil {
+			contextLogger.Error("No healthy backends available in pool %s", pool.Name())
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":"No healthy backends available"}`))
+			return
+		}
+	}
+
+	settings := pool.Settings()
+	// One deadline covers every attempt, hedge and reading the response,
+	// unless the response turns out to be a stream. It is a timer rather
+	// than a context deadline so it can be swapped for an idle timeout.
+	timeout, cause := settings.Timeouts.Total, context.DeadlineExceeded
+	if clientDeadline, ok := clientTimeout(r, timeout); ok {
+		timeout, cause = clientDeadline, upstream.ErrClientDeadline
+	}
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+	deadline := time.AfterFunc(timeout, func() { cancel(cause) })
+	defer deadline.Stop()
+	r = r.WithContext(ctx)
+
+	hedged := hedgeable && settings.Hedge.Enabled()
+	budget := pool.RetryBudget()
+	budget.Deposit()
+	tried := []*balancer.Backend{backend}
+
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		contextLogger.Debug("Forwarding to backend: %s%s", backend.URL, path)
+		if hedged {
+			var hedge *balancer.Backend
+			backend, hedge, resp, err = lb.hedgedForward(pool, backend, r, path, body, tried)
+			if hedge != nil {
+				contextLogger.Debug("Hedged request to %s", hedge.URL)
+				tried = append(tried, hedge)
+			}
+		} else {
+			resp, err = lb.forwardRequest(pool, backend, r, path, bodyReader(body))
+		}
+		if !shouldRetry(r, resp, err, idempotent) || attempt >= settings.Retries {
+			break
+		}
+
+		next := pool.NextBackend(r, tried...)
+		if next == nil {
+			break
+		}
+		if !budget.Withdraw() {
+			contextLogger.Warn("Retry budget exhausted for pool %s", pool.Name())
+			lb.metrics.RecordRetryDenied()
+			break
+		}
+
+		if err != nil {
+			contextLogger.Warn("Backend error: %s - %v, retrying on %s", backend.URL, err, next.URL)
+		} else {
+			contextLogger.Warn("Backend %s returned %d, retrying on %s", backend.URL, resp.StatusCode, next.URL)
+			resp.Body.Close()
+		}
+		lb.recordPick(next)
+		lb.metrics.RecordRetry(next.URL)
+		backend = next
+		tried = append(tried, next)
+	}
+
+	if err != nil {
+		kind := upstream.KindOf(err)
+		switch {
+		case kind.BackendFault():
+			contextLogger.Error("Backend error: %v", err)
+		case kind == upstream.ClientCancelled:
+			contextLogger.Warn("Client went away: %v", err)
+			lb.metrics.RecordErrorKind(kind)
+		default:
+			contextLogger.Warn("Request failed: %v", err)
+			lb.metrics.RecordErrorKind(kind)
+		}
+		writeUpstreamError(w, kind)
+		return
+	}
+	defer resp.Body.Close()
+
+	lb.copyResponseHeaders(w, resp, pool, backend, pinned, requestID)
+	w.WriteHeader(resp.StatusCode)
+
+	if !isStream(resp) {
+		io.Copy(w, resp.Body)
+		contextLogger.Debug("Request completed successfully")
+		return
+	}
+
+	// A stream may stay open indefinitely as long as data keeps flowing; a
+	// deadline the client asked for still applies
+	activity := func() {}
+	if cause != upstream.ErrClientDeadline && deadline.Stop() {
+		idleTimeout := settings.Timeouts.StreamIdle
+		idle := time.AfterFunc(idleTimeout, func() { cancel(errStreamIdle) })
+		defer idle.Stop()
+		activity = func() { idle.Reset(idleTimeout) }
+	}
+	contextLogger.Debug("Streaming response from %s", backend.URL)
+	if err := streamResponse(w, resp, settings.FlushInterval, activity); err != nil {
+		kind := upstream.Classify(ctx, err)
+		lb.metrics.RecordErrorKind(kind)
+		contextLogger.Warn("Stream from %s ended early: %s: %v", backend.URL, kind, err)
+		// Abort the response instead of ending it cleanly, so the client can
+		// tell the body was cut short
+		panic(http.ErrAbortHandler)
+	}
+	contextLogger.Debug("Stream completed")
+}
+
+// copyResponseHeaders copies the backend's end-to-end response headers and
+// adds the load balancer's own, which take precedence
+func (lb *LoadBalancer) copyResponseHeaders(w http.ResponseWriter, resp *http.Response, pool *balancer.Pool, backend, pinned *balancer.Backend, requestID string) {
+	header := resp.Header.Clone()
+	forward.RemoveHopByHop(header)
+	for key, values := range header {
+		w.Header()[key] = values
+	}
+	forward.AddVia(w.Header(), resp.ProtoMajor, resp.ProtoMinor)
+
+	// Pin (or re-pin after failover or a retry) the client to the backend
+	// that answered
+	if lb.sticky != nil && backend != pinned {
+		lb.sticky.SetCookie(w, pool.Name(), backend.URL)
+	}
+
+	// Add X-Served-By header for debugging/testing
+	w.Header().Set("X-Served-By", backend.URL)
+	w.Header().Set("X-Request-ID", requestID)
+}
+
+// HandleAPI is the original JSON echo endpoint: POST /api is forwarded to
+// the root path of a backend in the default pool
+func (lb *LoadBalancer) HandleAPI(w http.ResponseWriter, r *http.Request) {
+	contextLogger, requestID := lb.requestLogger(r)
+	r = forward.WithClientIP(r, lb.trustedProxies.ClientIP(r))
+	contextLogger.Debug("Received request: %s %s from %s", r.Method, r.URL.Path, forward.ClientIP(r))
+
+	if r.Method != http.MethodPost {
+		contextLogger.Warn("Method not allowed: %s", r.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error":"Method not allowed"}`))
+		return
+	}
+
+	// Limit request body size (1MB max)
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+
+	// Validate content type
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "application/json" {
+		contextLogger.Warn("Invalid content type: %s", contentType)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"Content-Type must be application/json"}`))
+		return
+	}
+
+	pool := lb.Pool(balancer.DefaultPool)
+	if pool == nil {
+		contextLogger.Error("No default pool configured")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":"No healthy backends available"}`))
+		return
+	}
+	// The echo endpoint has no side effects, so it is always safe to retry
+	// and hedge
+	lb.serve(w, r, pool, "/", true, true, contextLogger, requestID)
+}
+
+// HandleProxy forwards any method and path according to the routing table
+func (lb *LoadBalancer) HandleProxy(w http.ResponseWriter, r *http.Request) {
+	contextLogger, requestID := lb.requestLogger(r)
+	r = forward.WithClientIP(r, lb.trustedProxies.ClientIP(r))
+	contextLogger.Debug("Received request: %s %s from %s", r.Method, r.URL.Path, forward.ClientIP(r))
+
+	route, ok := lb.routes.Match(r)
+	if !ok {
+		contextLogger.Warn("No route for %s", r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"No route matches request"}`))
+		return
+	}
+
+	pool := lb.Pool(route.Pool)
+	if pool == nil {
+		contextLogger.Error("Route %s points at unknown pool %s", route, route.Pool)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":"No healthy backends available"}`))
+		return
+	}
+
+	// The escaped path is rewritten so that escapes such as %2F survive
+	path := route.Rewrite(r.URL.EscapedPath())
+	if isUpgrade(r) {
+		lb.tunnel(w, r, pool, path, contextLogger, requestID)
+		return
+	}
+	if isGRPC(r) {
+		lb.serveGRPC(w, r, pool, path, contextLogger, requestID)
+		return
+	}
+
+	// Limit request body size (1MB max)
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+
+	lb.serve(w, r, pool, path, isIdempotent(r), isSafe(r), contextLogger, requestID)
+}
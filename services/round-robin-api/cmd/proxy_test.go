@@ -0,0 +1,408 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"round-robin-api/internal/balancer"
+	"round-robin-api/internal/config"
+	"round-robin-api/internal/logger"
+	"round-robin-api/internal/router"
+)
+
+// newTestLB returns a load balancer with a default pool of the given
+// backends and the given routes, or one routing everything to the default
+// pool
+func newTestLB(t *testing.T, settings balancer.PoolSettings, routes []router.Route, backends ...string) *LoadBalancer {
+	t.Helper()
+	if routes == nil {
+		routes = []router.Route{{Prefix: "/", Pool: balancer.DefaultPool}}
+	}
+	table, err := router.NewTable(routes)
+	if err != nil {
+		t.Fatalf("Invalid routes: %v", err)
+	}
+	lb := NewLoadBalancer(table, settings, logger.New(logger.ERROR))
+	addTestPool(t, lb, balancer.DefaultPool, backends...)
+	return lb
+}
+
+// addTestPool adds a pool of the given backends with the load balancer's
+// default settings
+func addTestPool(t *testing.T, lb *LoadBalancer, name string, backends ...string) *balancer.Pool {
+	t.Helper()
+	cfg := config.PoolConfig{Name: name}
+	for _, url := range backends {
+		cfg.Backends = append(cfg.Backends, balancer.BackendConfig{URL: url})
+	}
+	if err := lb.AddPool(cfg); err != nil {
+		t.Fatalf("Failed to add pool: %v", err)
+	}
+	pool := lb.Pool(name)
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestHandleProxy_EncodedPath(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Path", r.URL.EscapedPath())
+		w.Header().Set("X-Query", r.URL.RawQuery)
+	}))
+	defer backend.Close()
+
+	routes := []router.Route{{Prefix: "/files", Pool: balancer.DefaultPool, StripPrefix: true}}
+	lb := newTestLB(t, balancer.PoolSettings{}, routes, backend.URL)
+
+	tests := map[string][2]string{
+		"/files/100%25":      {"/100%25", ""},
+		"/files/a%3Fb?x=1":   {"/a%3Fb", "x=1"},
+		"/files/a%2Fb":       {"/a%2Fb", ""},
+		"/files/a%23b?q=%26": {"/a%23b", "q=%26"},
+		"/files/a%20b":       {"/a%20b", ""},
+	}
+	for target, want := range tests {
+		w := httptest.NewRecorder()
+		lb.HandleProxy(w, httptest.NewRequest(http.MethodGet, "http://lb"+target, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d: %s", target, w.Code, w.Body)
+			continue
+		}
+		if got := [2]string{w.Header().Get("X-Path"), w.Header().Get("X-Query")}; got != want {
+			t.Errorf("%s: expected path %q and query %q, got %q and %q", target, want[0], want[1], got[0], got[1])
+		}
+	}
+}
+
+func TestTargetURL(t *testing.T) {
+	target, err := targetURL("http://users-1:8080/api/", "/a%2Fb", "x=1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := target.String(); got != "http://users-1:8080/api/a%2Fb?x=1" {
+		t.Errorf("Expected the escaped path under the backend's own, got %s", got)
+	}
+	if target.Path != "/api/a/b" {
+		t.Errorf("Expected the decoded path /api/a/b, got %s", target.Path)
+	}
+
+	target, err = targetURL("unix:///run/app.sock:/api", "/100%25", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if target.EscapedPath() != "/api/100%25" {
+		t.Errorf("Expected /api/100%%25 on the socket, got %s", target.EscapedPath())
+	}
+}
+
+// countingBackend answers every request with status, echoing the request
+// body, and counts the requests it gets; health checks always pass
+func countingBackend(t *testing.T, status int) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			return
+		}
+		hits.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(status)
+		w.Write(body)
+	}))
+	t.Cleanup(backend.Close)
+	return backend, &hits
+}
+
+// resettingBackend closes the connection of every request without
+// answering
+func resettingBackend(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	t.Cleanup(backend.Close)
+	return backend, &hits
+}
+
+// refusedURL returns the URL of a backend that refuses connections
+func refusedURL(t *testing.T) string {
+	t.Helper()
+	backend := httptest.NewServer(http.NotFoundHandler())
+	backend.Close()
+	return backend.URL
+}
+
+// sendTwice sends the same request twice, so that with round-robin over two
+// backends one of them starts on each backend
+func sendTwice(lb *LoadBalancer, method, body string) []*httptest.ResponseRecorder {
+	var responses []*httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		lb.HandleProxy(w, httptest.NewRequest(method, "/users", strings.NewReader(body)))
+		responses = append(responses, w)
+	}
+	return responses
+}
+
+// retryCount returns how many retries were sent to a backend
+func retryCount(lb *LoadBalancer, backend string) uint64 {
+	return lb.metrics.GetMetrics()["retry_counts"].(map[string]uint64)[backend]
+}
+
+func TestServe_RetriesOnAnotherBackend(t *testing.T) {
+	failing, _ := countingBackend(t, http.StatusBadGateway)
+	reset, _ := resettingBackend(t)
+
+	for name, bad := range map[string]string{"5xx": failing.URL, "reset": reset.URL} {
+		healthy, _ := countingBackend(t, http.StatusOK)
+		lb := newTestLB(t, balancer.PoolSettings{Retries: 1}, nil, bad, healthy.URL)
+
+		for _, w := range sendTwice(lb, http.MethodGet, "") {
+			if w.Code != http.StatusOK || w.Header().Get("X-Served-By") != healthy.URL {
+				t.Errorf("%s: expected 200 from the healthy backend, got %d from %s", name, w.Code, w.Header().Get("X-Served-By"))
+			}
+		}
+		if got := retryCount(lb, healthy.URL); got != 1 {
+			t.Errorf("%s: expected 1 retry on the healthy backend, got %d", name, got)
+		}
+	}
+}
+
+func TestServe_ReplaysBodyAfterConnectFailure(t *testing.T) {
+	healthy, hits := countingBackend(t, http.StatusOK)
+	lb := newTestLB(t, balancer.PoolSettings{Retries: 1}, nil, refusedURL(t), healthy.URL)
+
+	// A request that never reached a backend is retried whatever its method
+	for _, w := range sendTwice(lb, http.MethodPost, `{"name":"ada"}`) {
+		if w.Code != http.StatusOK || w.Body.String() != `{"name":"ada"}` {
+			t.Errorf("Expected the body echoed by the healthy backend, got %d: %s", w.Code, w.Body)
+		}
+	}
+	if hits.Load() != 2 || retryCount(lb, healthy.URL) != 1 {
+		t.Errorf("Expected 2 requests and 1 retry on the healthy backend, got %d and %d", hits.Load(), retryCount(lb, healthy.URL))
+	}
+}
+
+func TestServe_DoesNotRetryPOST(t *testing.T) {
+	failing, failingHits := countingBackend(t, http.StatusBadGateway)
+	reset, resetHits := resettingBackend(t)
+
+	for name, bad := range map[string]*httptest.Server{"5xx": failing, "reset": reset} {
+		healthy, healthyHits := countingBackend(t, http.StatusOK)
+		lb := newTestLB(t, balancer.PoolSettings{Retries: 1}, nil, bad.URL, healthy.URL)
+
+		// The backend may have acted on the request before failing
+		codes := map[int]int{}
+		for _, w := range sendTwice(lb, http.MethodPost, `{"name":"ada"}`) {
+			codes[w.Code]++
+		}
+		if codes[http.StatusOK] != 1 || codes[http.StatusBadGateway] != 1 {
+			t.Errorf("%s: expected one 200 and one 502, got %v", name, codes)
+		}
+		if healthyHits.Load() != 1 || retryCount(lb, healthy.URL) != 0 {
+			t.Errorf("%s: expected the failed POST not to be retried, got %d requests on the healthy backend", name, healthyHits.Load())
+		}
+	}
+	if failingHits.Load() != 1 || resetHits.Load() != 1 {
+		t.Errorf("Expected each failing backend to be sent one request, got %d and %d", failingHits.Load(), resetHits.Load())
+	}
+}
+
+func TestServe_RetryBudgetExhausted(t *testing.T) {
+	failing, _ := countingBackend(t, http.StatusBadGateway)
+	healthy, _ := countingBackend(t, http.StatusOK)
+	lb := newTestLB(t, balancer.PoolSettings{Retries: 1}, nil, failing.URL, healthy.URL)
+
+	budget := lb.Pool(balancer.DefaultPool).RetryBudget()
+	for budget.Withdraw() {
+	}
+
+	codes := map[int]int{}
+	for _, w := range sendTwice(lb, http.MethodGet, "") {
+		codes[w.Code]++
+	}
+	if codes[http.StatusOK] != 1 || codes[http.StatusBadGateway] != 1 {
+		t.Errorf("Expected the failure to be passed on once the budget ran out, got %v", codes)
+	}
+	if denied := lb.metrics.GetMetrics()["retries_denied"].(uint64); denied != 1 {
+		t.Errorf("Expected 1 denied retry, got %d", denied)
+	}
+	if got := retryCount(lb, healthy.URL); got != 0 {
+		t.Errorf("Expected no retries, got %d", got)
+	}
+}
+
+// slowBackend answers after delay unless the request is cancelled first,
+// which it reports on cancelled
+func slowBackend(t *testing.T, delay time.Duration) (*httptest.Server, chan struct{}) {
+	t.Helper()
+	cancelled := make(chan struct{}, 10)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+			w.Write([]byte("ok"))
+		case <-r.Context().Done():
+			cancelled <- struct{}{}
+		}
+	}))
+	t.Cleanup(backend.Close)
+	return backend, cancelled
+}
+
+// newHedgingLB returns a load balancer hedging after the backends' median
+// latency, which is set to delay
+func newHedgingLB(t *testing.T, delay time.Duration, backends ...string) *LoadBalancer {
+	t.Helper()
+	lb := newTestLB(t, balancer.PoolSettings{Hedge: balancer.HedgeSettings{Percentile: 0.5}}, nil, backends...)
+	for _, backend := range backends {
+		for i := 0; i < 20; i++ {
+			lb.metrics.RecordRequestComplete("", backend, delay, true)
+		}
+	}
+	return lb
+}
+
+// hedge sends a GET through hedgedForward, starting on the given backend
+func hedge(t *testing.T, lb *LoadBalancer, first string) (winner, hedged *balancer.Backend) {
+	t.Helper()
+	pool := lb.Pool(balancer.DefaultPool)
+	backend := pool.AvailableBackend(first)
+	winner, hedged, resp, err := lb.hedgedForward(pool, backend, httptest.NewRequest(http.MethodGet, "/users", nil), "/users", nil, []*balancer.Backend{backend})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return winner, hedged
+}
+
+func hedgeMetrics(lb *LoadBalancer, backend string) (count, wins uint64) {
+	metrics := lb.metrics.GetMetrics()
+	return metrics["hedge_counts"].(map[string]uint64)[backend], metrics["hedge_wins"].(map[string]uint64)[backend]
+}
+
+func TestHedgedForward_HedgeWins(t *testing.T) {
+	slow, slowCancelled := slowBackend(t, 5*time.Second)
+	fast, _ := slowBackend(t, 0)
+	lb := newHedgingLB(t, 20*time.Millisecond, slow.URL, fast.URL)
+
+	winner, hedged := hedge(t, lb, slow.URL)
+	if hedged == nil || hedged.URL != fast.URL || winner != hedged {
+		t.Fatalf("Expected the hedge to %s to win, got winner %v and hedge %v", fast.URL, winner, hedged)
+	}
+	if count, wins := hedgeMetrics(lb, fast.URL); count != 1 || wins != 1 {
+		t.Errorf("Expected 1 hedge and 1 hedge win, got %d and %d", count, wins)
+	}
+	select {
+	case <-slowCancelled:
+	case <-time.After(time.Second):
+		t.Error("Expected the slower original to be cancelled")
+	}
+}
+
+func TestHedgedForward_OriginalWins(t *testing.T) {
+	original, _ := slowBackend(t, 100*time.Millisecond)
+	hedgeBackend, hedgeCancelled := slowBackend(t, 5*time.Second)
+	lb := newHedgingLB(t, 20*time.Millisecond, original.URL, hedgeBackend.URL)
+
+	winner, hedged := hedge(t, lb, original.URL)
+	if hedged == nil || hedged.URL != hedgeBackend.URL || winner.URL != original.URL {
+		t.Fatalf("Expected the original to win over a hedge, got winner %v and hedge %v", winner, hedged)
+	}
+	if count, wins := hedgeMetrics(lb, hedgeBackend.URL); count != 1 || wins != 0 {
+		t.Errorf("Expected 1 hedge and no wins, got %d and %d", count, wins)
+	}
+	select {
+	case <-hedgeCancelled:
+	case <-time.After(time.Second):
+		t.Error("Expected the slower hedge to be cancelled")
+	}
+}
+
+func TestHedgedForward_NotHedgedWhenFast(t *testing.T) {
+	fast, _ := slowBackend(t, 0)
+	other, _ := slowBackend(t, 0)
+	lb := newHedgingLB(t, time.Second, fast.URL, other.URL)
+
+	if winner, hedged := hedge(t, lb, fast.URL); hedged != nil || winner.URL != fast.URL {
+		t.Errorf("Expected no hedge for a backend answering in time, got winner %v and hedge %v", winner, hedged)
+	}
+}
+
+func TestServe_HedgesOnlyReads(t *testing.T) {
+	first, _ := slowBackend(t, 200*time.Millisecond)
+	second, _ := slowBackend(t, 200*time.Millisecond)
+	lb := newHedgingLB(t, 10*time.Millisecond, first.URL, second.URL)
+
+	hedges := func() uint64 {
+		count1, _ := hedgeMetrics(lb, first.URL)
+		count2, _ := hedgeMetrics(lb, second.URL)
+		return count1 + count2
+	}
+	// Writes may be retried, but two copies must never run at once
+	for _, method := range []string{http.MethodPut, http.MethodDelete, http.MethodPatch} {
+		w := httptest.NewRecorder()
+		lb.HandleProxy(w, httptest.NewRequest(method, "/users", strings.NewReader("{}")))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", method, w.Code)
+		}
+	}
+	r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader("{}"))
+	r.Header.Set("Idempotency-Key", "42")
+	lb.HandleProxy(httptest.NewRecorder(), r)
+	if got := hedges(); got != 0 {
+		t.Errorf("Expected writes not to be hedged, got %d hedges", got)
+	}
+
+	lb.HandleProxy(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+	if got := hedges(); got != 1 {
+		t.Errorf("Expected a slow GET to be hedged, got %d hedges", got)
+	}
+}
+
+func TestHandleProxy_StickyPerPool(t *testing.T) {
+	var urls []string
+	for i := 0; i < 4; i++ {
+		backend, _ := countingBackend(t, http.StatusOK)
+		urls = append(urls, backend.URL)
+	}
+	routes := []router.Route{{Prefix: "/users", Pool: balancer.DefaultPool}, {Prefix: "/search", Pool: "search"}}
+	lb := newTestLB(t, balancer.PoolSettings{}, routes, urls[0], urls[1])
+	addTestPool(t, lb, "search", urls[2], urls[3])
+	lb.sticky = balancer.NewStickySessions("", []byte("secret"))
+
+	// A client alternating between the pools keeps its backend in each
+	jar := map[string]*http.Cookie{}
+	pinned := map[string]string{}
+	for i := 0; i < 6; i++ {
+		path := []string{"/users", "/search"}[i%2]
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for _, c := range jar {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		lb.HandleProxy(w, r)
+
+		served := w.Header().Get("X-Served-By")
+		if pinned[path] == "" {
+			pinned[path] = served
+		} else if served != pinned[path] {
+			t.Errorf("Request %d to %s: expected %s, got %s", i, path, pinned[path], served)
+		}
+		for _, c := range w.Result().Cookies() {
+			if i >= 2 {
+				t.Errorf("Request %d to %s: unexpected new cookie %s", i, path, c.Name)
+			}
+			jar[c.Name] = c
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errStreamIdle is the context cause when a streamed response has gone
+// quiet for longer than the pool's stream idle timeout
+var errStreamIdle = fmt.Errorf("stream idle: %w", context.DeadlineExceeded)
+
+// isEventStream reports whether the response carries server-sent events
+func isEventStream(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// isStream reports whether a response should be relayed as it arrives
+// rather than copied in one go: server-sent events and bodies of unknown
+// length, i.e. chunked responses
+func isStream(resp *http.Response) bool {
+	return isEventStream(resp) || resp.ContentLength == -1
+}
+
+// flushWriter flushes what is written to the client, either straight away
+// or at most once per interval
+type flushWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	rc       *http.ResponseController
+	interval time.Duration
+	pending  *time.Timer
+	stopped  bool
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, err := f.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if f.interval <= 0 {
+		return n, f.flush()
+	}
+	if f.pending == nil {
+		f.pending = time.AfterFunc(f.interval, f.delayedFlush)
+	}
+	return n, nil
+}
+
+func (f *flushWriter) delayedFlush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// The handler may have returned; the response must not be touched then
+	if f.stopped {
+		return
+	}
+	f.pending = nil
+	f.flush()
+}
+
+func (f *flushWriter) flush() error {
+	if err := f.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// stop cancels any pending flush; the writer must not be used afterwards
+func (f *flushWriter) stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopped = true
+	if f.pending != nil {
+		f.pending.Stop()
+	}
+}
+
+// streamResponse relays a response body to the client as it arrives,
+// calling activity whenever data comes in. Server-sent events are flushed
+// after every write, other streams every flushInterval.
+func streamResponse(w http.ResponseWriter, resp *http.Response, flushInterval time.Duration, activity func()) error {
+	rc := http.NewResponseController(w)
+	// The server's read and write timeouts are meant for ordinary requests;
+	// a stream is bounded by the idle timeout instead
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	if isEventStream(resp) {
+		flushInterval = 0
+	}
+	fw := &flushWriter{w: w, rc: rc, interval: flushInterval}
+	defer fw.stop()
+
+	// Send the headers right away so the client knows the stream is open
+	if err := fw.flush(); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			activity()
+			if _, werr := fw.Write(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
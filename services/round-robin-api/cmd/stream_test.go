@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"round-robin-api/internal/balancer"
+	"round-robin-api/internal/upstream"
+)
+
+// serveLB serves the load balancer's proxy on a loopback address
+func serveLB(t *testing.T, lb *LoadBalancer) *httptest.Server {
+	t.Helper()
+	front := httptest.NewServer(http.HandlerFunc(lb.HandleProxy))
+	t.Cleanup(front.Close)
+	return front
+}
+
+func TestStream_EventsFlushedRightAway(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: 1\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("data: 2\n\n"))
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	// Events ignore the flush interval
+	lb := newTestLB(t, balancer.PoolSettings{FlushInterval: time.Minute}, nil, backend.URL)
+	resp, err := http.Get(serveLB(t, lb).URL + "/events")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	lines := make(chan string)
+	go func() {
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		lines <- line
+	}()
+	select {
+	case line := <-lines:
+		if line != "data: 1\n" {
+			t.Errorf("Expected the first event, got %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the first event before the stream ended")
+	}
+}
+
+func TestStream_OutlivesTotalTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 5; i++ {
+			w.Write([]byte(strconv.Itoa(i)))
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	defer backend.Close()
+
+	// A stream is only bounded by how long it goes quiet
+	settings := balancer.PoolSettings{Timeouts: balancer.Timeouts{Total: 100 * time.Millisecond, StreamIdle: time.Second}}
+	lb := newTestLB(t, settings, nil, backend.URL)
+	resp, err := http.Get(serveLB(t, lb).URL + "/download")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || string(body) != "01234" {
+		t.Errorf("Expected the whole stream, got %q, %v", body, err)
+	}
+}
+
+func TestStream_IdleTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer backend.Close()
+
+	settings := balancer.PoolSettings{Timeouts: balancer.Timeouts{Total: time.Second, StreamIdle: 100 * time.Millisecond}}
+	lb := newTestLB(t, settings, nil, backend.URL)
+	resp, err := http.Get(serveLB(t, lb).URL + "/download")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The response is aborted rather than ended, so the client can tell
+	body, err := io.ReadAll(resp.Body)
+	if err == nil || string(body) != "first" {
+		t.Errorf("Expected the stream to be cut short after %q, got %q, %v", "first", body, err)
+	}
+	if got := lb.metrics.GetMetrics()["error_kinds"].(map[upstream.Kind]uint64)[upstream.Timeout]; got != 1 {
+		t.Errorf("Expected the idle stream to count as a timeout, got %d", got)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"round-robin-api/internal/balancer"
+	"round-robin-api/internal/config"
+	"round-robin-api/internal/proxyproto"
+	"round-robin-api/internal/upstream"
+)
+
+// ListenTCP opens a TCP listener relaying connections to the backends of
+// its pool, which must use protocol tcp. Connections are accepted until the
+// listener is closed.
+func (lb *LoadBalancer) ListenTCP(cfg config.ListenerConfig) (net.Listener, error) {
+	lb.Lock()
+	defer lb.Unlock()
+
+	pool, err := lb.addListener(balancer.ProtocolTCP, cfg)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := lb.listen(cfg.Listen, cfg.ProxyProtocol)
+	if err != nil {
+		return nil, err
+	}
+
+	go lb.serveTCP(listener, pool)
+	lb.logger.Info("Relaying TCP connections on %s to pool %s", listener.Addr(), cfg.Pool)
+	return listener, nil
+}
+
+// listen opens a TCP listener for the HTTP server or a TCP relay. With
+// proxyProtocol, connections from trusted peers start with a PROXY protocol
+// header naming the client, which then stands in for the peer.
+func (lb *LoadBalancer) listen(addr string, proxyProtocol bool) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil || !proxyProtocol {
+		return listener, err
+	}
+	lb.logger.Info("Reading PROXY protocol headers on %s", listener.Addr())
+	return proxyproto.NewListener(listener, lb.proxyProtocolTrusted, lb.logger), nil
+}
+
+// serveTCP accepts connections until the listener is closed
+func (lb *LoadBalancer) serveTCP(listener net.Listener, pool *balancer.Pool) {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			// e.g. out of file descriptors; give connections time to close
+			lb.logger.Warn("Failed to accept TCP connection on %s: %v", listener.Addr(), err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go lb.relayTCP(conn, pool)
+	}
+}
+
+// clientRequest stands in for a request when a strategy picks a backend
+// for a TCP connection or UDP client. Only the client's address is known, so
+// hashing strategies fall back to the client IP.
+func clientRequest(client net.Addr) *http.Request {
+	return &http.Request{RemoteAddr: client.String(), Header: make(http.Header)}
+}
+
+// relayTCP connects the client to a backend, retrying connect failures on
+// other backends, and pipes bytes both ways until both sides are done or the
+// balancer shuts down
+func (lb *LoadBalancer) relayTCP(client net.Conn, pool *balancer.Pool) {
+	defer client.Close()
+	// Relayed connections drain on shutdown along with tunnels
+	lb.tunnels.Add(1)
+	defer lb.tunnels.Add(-1)
+
+	// The PROXY protocol header has been logged already if it was invalid
+	if conn, ok := client.(*proxyproto.Conn); ok && conn.Err() != nil {
+		return
+	}
+	r := clientRequest(client.RemoteAddr())
+	backend := lb.NextBackend(pool, r)
+	if backend == nil {
+		lb.logger.Error("No healthy backends available in pool %s for %s", pool.Name(), r.RemoteAddr)
+		return
+	}
+
+	settings := pool.Settings()
+	budget := pool.RetryBudget()
+	budget.Deposit()
+	tried := []*balancer.Backend{backend}
+
+	var conn net.Conn
+	for attempt := 0; ; attempt++ {
+		var err error
+		if conn, err = lb.dialTCP(pool, backend, client, settings); err == nil {
+			break
+		}
+		if attempt >= settings.Retries {
+			lb.logger.Error("Connect to %s failed: %v", backend.URL, err)
+			return
+		}
+		next := pool.NextBackend(r, tried...)
+		if next == nil {
+			lb.logger.Error("Connect to %s failed: %v", backend.URL, err)
+			return
+		}
+		if !budget.Withdraw() {
+			lb.logger.Warn("Retry budget exhausted for pool %s", pool.Name())
+			lb.metrics.RecordRetryDenied()
+			return
+		}
+		lb.logger.Warn("Connect to %s failed: %v, retrying on %s", backend.URL, err, next.URL)
+		lb.recordPick(next)
+		lb.metrics.RecordRetry(next.URL)
+		backend = next
+		tried = append(tried, next)
+	}
+	defer conn.Close()
+	// The connection counts as an outstanding request for balancing until
+	// it closes
+	defer backend.Release()
+
+	lb.metrics.RecordTCPOpen(backend.URL)
+	defer lb.metrics.RecordTCPClose(backend.URL)
+	start := time.Now()
+	lb.logger.Debug("Relaying %s to %s", r.RemoteAddr, backend.URL)
+
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn, count func(n int)) {
+		io.Copy(&countingWriter{Writer: dst, count: count}, src)
+		// Pass the end of the stream on; the other direction may still
+		// have data to send
+		if conn, ok := dst.(interface{ CloseWrite() error }); ok {
+			conn.CloseWrite()
+		} else {
+			dst.Close()
+		}
+		done <- struct{}{}
+	}
+	go pipe(conn, client, func(n int) { lb.metrics.RecordTCPBytes(backend.URL, n, 0) })
+	go pipe(client, conn, func(n int) { lb.metrics.RecordTCPBytes(backend.URL, 0, n) })
+
+	for open := 2; open > 0; open-- {
+		select {
+		case <-done:
+		case <-lb.closeTunnels:
+			lb.logger.Warn("Closing TCP connection from %s to %s for shutdown", r.RemoteAddr, backend.URL)
+			return
+		}
+	}
+	lb.logger.Debug("Connection from %s to %s closed after %v", r.RemoteAddr, backend.URL, time.Since(start))
+}
+
+// dialTCP connects to a backend on behalf of a client, first telling the
+// backend who the client is if the pool sends PROXY protocol headers. The
+// outcome feeds its circuit breaker and the strategy like a forwarded
+// request's; on success the backend stays acquired until the caller releases
+// it.
+func (lb *LoadBalancer) dialTCP(pool *balancer.Pool, backend *balancer.Backend, client net.Conn, settings balancer.PoolSettings) (net.Conn, error) {
+	backend.Acquire()
+	strategy := pool.Strategy()
+	strategy.OnRequestStart(backend)
+	start := time.Now()
+
+	addr, err := balancer.BackendAddress(balancer.ProtocolTCP, backend.URL)
+	var conn net.Conn
+	if err == nil {
+		dial := proxyproto.Dialer((&net.Dialer{Timeout: settings.Timeouts.Connect}).DialContext, settings.ProxyProtocol)
+		ctx := proxyproto.WithHeader(context.Background(), proxyproto.Header{Source: client.RemoteAddr(), Destination: client.LocalAddr()})
+		conn, err = dial(ctx, "tcp", addr)
+	}
+	duration := time.Since(start)
+	backend.ObserveLatency(duration)
+
+	if err != nil {
+		backend.Release()
+		upstreamErr := upstream.NewError(nil, backend.URL, err)
+		backend.Breaker.RecordError(upstreamErr.Kind)
+		strategy.OnRequestFinish(backend, duration, false)
+		lb.metrics.RecordConnectFailure(backend.URL)
+		lb.metrics.RecordErrorKind(upstreamErr.Kind)
+		return nil, upstreamErr
+	}
+	backend.Breaker.RecordSuccess()
+	strategy.OnRequestFinish(backend, duration, true)
+	return conn, nil
+}
+
+// countingWriter reports how many bytes each write passed on
+type countingWriter struct {
+	io.Writer
+	count func(n int)
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.Writer.Write(p)
+	if n > 0 {
+		c.count(n)
+	}
+	return n, err
+}
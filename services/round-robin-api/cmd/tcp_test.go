@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"round-robin-api/internal/balancer"
+	"round-robin-api/internal/config"
+	"round-robin-api/internal/metrics"
+	"round-robin-api/internal/router"
+)
+
+// echoTCPBackend sends back whatever each connection sends it
+func echoTCPBackend(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return "tcp://" + listener.Addr().String()
+}
+
+// refusedTCPBackend returns a backend address nothing listens on
+func refusedTCPBackend(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	listener.Close()
+	return "tcp://" + listener.Addr().String()
+}
+
+func tcpStats(lb *LoadBalancer, backend string) metrics.TCPStats {
+	return lb.metrics.GetMetrics()["tcp"].(map[string]metrics.TCPStats)[backend]
+}
+
+func TestRelayTCP(t *testing.T) {
+	refused, echo := refusedTCPBackend(t), echoTCPBackend(t)
+	settings := balancer.PoolSettings{Protocol: balancer.ProtocolTCP, Retries: 1}
+	lb := newTestLB(t, settings, []router.Route{}, refused, echo)
+	listener, err := lb.ListenTCP(config.ListenerConfig{Listen: "127.0.0.1:0", Pool: balancer.DefaultPool})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	// One of the two connections starts on the refused backend and is
+	// retried on the other
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		conn.Write([]byte("hello"))
+		conn.(*net.TCPConn).CloseWrite()
+		data, err := io.ReadAll(conn)
+		conn.Close()
+		if err != nil || string(data) != "hello" {
+			t.Errorf("Connection %d: expected hello echoed, got %q, %v", i, data, err)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for tcpStats(lb, echo).Active > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stats := tcpStats(lb, echo)
+	if stats.Active != 0 || stats.Connections != 2 {
+		t.Errorf("Expected 2 closed connections, got %+v", stats)
+	}
+	if stats.BytesSent != 10 || stats.BytesReceived != 10 {
+		t.Errorf("Expected 10 bytes each way, got %d sent and %d received", stats.BytesSent, stats.BytesReceived)
+	}
+	if failures := tcpStats(lb, refused).ConnectFailures; failures != 1 {
+		t.Errorf("Expected 1 connect failure on the refused backend, got %d", failures)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"round-robin-api/internal/balancer"
+	"round-robin-api/internal/forward"
+	"round-robin-api/internal/logger"
+	"round-robin-api/internal/upstream"
+)
+
+// isUpgrade reports whether the client asks to switch protocols, e.g. to a
+// WebSocket
+func isUpgrade(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" && forward.HasToken(r.Header, "Connection", "upgrade")
+}
+
+// tunnel forwards an upgrade request to a backend and, once the backend
+// switches protocols, pipes bytes between the client and the backend until
+// either side closes or the balancer shuts down
+func (lb *LoadBalancer) tunnel(w http.ResponseWriter, r *http.Request, pool *balancer.Pool, path string, contextLogger *logger.ContextLogger, requestID string) {
+	pinned := lb.PinnedBackend(pool, r)
+	backend := pinned
+	if backend == nil {
+		backend = lb.NextBackend(pool, r)
+		if backend == nil {
+			contextLogger.Error("No healthy backends available in pool %s", pool.Name())
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":"No healthy backends available"}`))
+			return
+		}
+	}
+
+	// The handshake is bounded by the pool's total timeout; the tunnel
+	// itself stays open for as long as both sides want it
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+	handshake := time.AfterFunc(pool.Settings().Timeouts.Total, func() { cancel(context.DeadlineExceeded) })
+	resp, err := lb.forwardRequest(pool, backend, r.WithContext(ctx), path, nil)
+	if err == nil && !handshake.Stop() {
+		resp.Body.Close()
+		err = upstream.NewError(ctx, backend.URL, context.DeadlineExceeded)
+	}
+	if err != nil {
+		kind := upstream.KindOf(err)
+		if !kind.BackendFault() {
+			lb.metrics.RecordErrorKind(kind)
+		}
+		contextLogger.Error("Upgrade to %s failed: %v", backend.URL, err)
+		writeUpstreamError(w, kind)
+		return
+	}
+
+	lb.copyResponseHeaders(w, resp, pool, backend, pinned, requestID)
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// The backend turned the upgrade down; pass its answer on
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	backendConn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok || !strings.EqualFold(resp.Header.Get("Upgrade"), r.Header.Get("Upgrade")) {
+		resp.Body.Close()
+		contextLogger.Error("Backend %s switched to %q instead of %q", backend.URL, resp.Header.Get("Upgrade"), r.Header.Get("Upgrade"))
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`{"error":"Backend switched to an unexpected protocol"}`))
+		return
+	}
+	defer backendConn.Close()
+
+	clientConn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		contextLogger.Error("Cannot take over client connection: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"Connection upgrade not supported"}`))
+		return
+	}
+	defer clientConn.Close()
+	// The server's read and write deadlines were meant for the handshake
+	clientConn.SetDeadline(time.Time{})
+
+	// The connection headers are the point of a 101
+	w.Header().Set("Connection", "Upgrade")
+	w.Header().Set("Upgrade", resp.Header.Get("Upgrade"))
+	resp.Header = w.Header()
+	resp.Body = nil
+	if err := resp.Write(brw); err != nil {
+		contextLogger.Warn("Failed to send upgrade response: %v", err)
+		return
+	}
+	if err := brw.Flush(); err != nil {
+		contextLogger.Warn("Failed to send upgrade response: %v", err)
+		return
+	}
+
+	// The tunnel counts as an outstanding request for balancing until the
+	// backend connection, the handshake's response body, is closed
+	lb.tunnels.Add(1)
+	defer lb.tunnels.Add(-1)
+	lb.metrics.RecordTunnelOpen(backend.URL)
+	defer lb.metrics.RecordTunnelClose(backend.URL)
+
+	start := time.Now()
+	contextLogger.Debug("Tunnel to %s open (%s)", backend.URL, r.Header.Get("Upgrade"))
+
+	done := make(chan struct{}, 2)
+	pipe := func(dst io.Writer, src io.Reader) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	// Bytes the client sent after the handshake may already be buffered
+	go pipe(backendConn, brw)
+	go pipe(clientConn, backendConn)
+
+	select {
+	case <-done:
+	case <-lb.closeTunnels:
+		contextLogger.Warn("Closing tunnel to %s for shutdown", backend.URL)
+	}
+	contextLogger.Debug("Tunnel to %s closed after %v", backend.URL, time.Since(start))
+}
+
+// DrainTunnels waits for open tunnels to close on their own, and closes the
+// ones still open when ctx ends
+func (lb *LoadBalancer) DrainTunnels(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for lb.tunnels.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			lb.closeTunnelsOnce.Do(func() { close(lb.closeTunnels) })
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"round-robin-api/internal/balancer"
+)
+
+// echoUpgradeBackend switches to the "echo" protocol, which sends back
+// whatever it gets, and turns down any other upgrade
+func echoUpgradeBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		brw.Flush()
+		io.Copy(conn, brw)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+// upgrade asks the load balancer to switch the connection to protocol
+func upgrade(t *testing.T, front *httptest.Server, protocol string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", front.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	fmt.Fprintf(conn, "GET /chat HTTP/1.1\r\nHost: lb\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", protocol)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read the upgrade response: %v", err)
+	}
+	return conn, reader, resp
+}
+
+func activeTunnels(lb *LoadBalancer, backend string) int64 {
+	return lb.metrics.GetMetrics()["active_tunnels"].(map[string]int64)[backend]
+}
+
+func TestTunnel_RelaysAndDrains(t *testing.T) {
+	backend := echoUpgradeBackend(t)
+	lb := newTestLB(t, balancer.PoolSettings{}, nil, backend.URL)
+	conn, reader, resp := upgrade(t, serveLB(t, lb), "echo")
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "echo" {
+		t.Fatalf("Expected a switch to echo, got %d %q", resp.StatusCode, resp.Header.Get("Upgrade"))
+	}
+
+	conn.Write([]byte("ping"))
+	echoed := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(reader, echoed); err != nil || string(echoed) != "ping" {
+		t.Fatalf("Expected ping echoed through the tunnel, got %q, %v", echoed, err)
+	}
+	if got := activeTunnels(lb, backend.URL); got != 1 {
+		t.Errorf("Expected 1 active tunnel, got %d", got)
+	}
+
+	// Tunnels still open when draining runs out of time are closed
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := lb.DrainTunnels(ctx); err == nil {
+		t.Error("Expected draining to time out with a tunnel open")
+	}
+	if _, err := reader.ReadByte(); err == nil {
+		t.Error("Expected the tunnel to be closed")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for lb.tunnels.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if lb.tunnels.Load() != 0 || activeTunnels(lb, backend.URL) != 0 {
+		t.Errorf("Expected no tunnels left, got %d", lb.tunnels.Load())
+	}
+}
+
+func TestTunnel_UpgradeDeclined(t *testing.T) {
+	lb := newTestLB(t, balancer.PoolSettings{}, nil, echoUpgradeBackend(t).URL)
+	if _, _, resp := upgrade(t, serveLB(t, lb), "other"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected the backend's 400 to be passed on, got %d", resp.StatusCode)
+	}
+}
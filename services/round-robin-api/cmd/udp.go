@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+
+	"round-robin-api/internal/balancer"
+	"round-robin-api/internal/config"
+	"round-robin-api/internal/upstream"
+)
+
+// maxDatagram is the largest UDP payload
+const maxDatagram = 64 * 1024
+
+// ListenUDP opens a UDP socket relaying datagrams to the backends of its
+// pool, which must use protocol udp. Datagrams are relayed until the socket
+// is closed.
+func (lb *LoadBalancer) ListenUDP(cfg config.ListenerConfig) (net.PacketConn, error) {
+	lb.Lock()
+	defer lb.Unlock()
+
+	pool, err := lb.addListener(balancer.ProtocolUDP, cfg)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.ListenPacket("udp", cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+
+	go lb.serveUDP(listener, pool)
+	lb.logger.Info("Relaying UDP datagrams on %s to pool %s", listener.LocalAddr(), cfg.Pool)
+	return listener, nil
+}
+
+// serveUDP relays datagrams from clients until the listener is closed. Each
+// client address gets a session with its own socket to its backend, so
+// replies can be sent back to the right client.
+func (lb *LoadBalancer) serveUDP(listener net.PacketConn, pool *balancer.Pool) {
+	idle := pool.Settings().Timeouts.SessionIdle
+	sessions := balancer.NewSessionTable(idle)
+	stop := make(chan struct{})
+	defer func() {
+		close(stop)
+		for _, session := range sessions.Clear() {
+			lb.closeSession(session)
+		}
+	}()
+	go lb.expireSessions(sessions, idle, stop)
+
+	buf := make([]byte, maxDatagram)
+	for {
+		n, client, err := listener.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			lb.logger.Warn("Failed to read UDP datagram on %s: %v", listener.LocalAddr(), err)
+			continue
+		}
+
+		session := sessions.Get(client.String())
+		// A client whose backend went unhealthy is moved to another one
+		if session != nil && pool.AvailableBackend(session.Backend.URL) == nil {
+			if sessions.Remove(session) {
+				lb.closeSession(session)
+			}
+			session = nil
+		}
+		if session == nil {
+			if session = lb.startSession(listener, pool, sessions, client); session == nil {
+				continue
+			}
+		}
+
+		session.Touch()
+		_, err = session.Conn.Write(buf[:n])
+		if errors.Is(err, net.ErrClosed) {
+			// The session expired after it was looked up, which says
+			// nothing about its backend; the datagram starts a new one
+			if sessions.Remove(session) {
+				lb.closeSession(session)
+			}
+			if session = lb.startSession(listener, pool, sessions, client); session == nil {
+				continue
+			}
+			_, err = session.Conn.Write(buf[:n])
+		}
+		if err != nil {
+			lb.recordDatagramError(session, err)
+			continue
+		}
+		lb.metrics.RecordDatagram(session.Backend.URL, true)
+	}
+}
+
+// startSession opens a session for a new client, adds it to the table and
+// relays the backend's replies to the client
+func (lb *LoadBalancer) startSession(listener net.PacketConn, pool *balancer.Pool, sessions *balancer.SessionTable, client net.Addr) *balancer.Session {
+	session := lb.openSession(pool, client)
+	if session == nil {
+		return nil
+	}
+	sessions.Add(session)
+	go lb.relayReplies(listener, sessions, session)
+	return session
+}
+
+// openSession picks a backend for a new client and opens a socket to it.
+// The session counts as an outstanding request for balancing until it is
+// closed.
+func (lb *LoadBalancer) openSession(pool *balancer.Pool, client net.Addr) *balancer.Session {
+	backend := lb.NextBackend(pool, clientRequest(client))
+	if backend == nil {
+		lb.logger.Error("No healthy backends available in pool %s for %s", pool.Name(), client)
+		return nil
+	}
+
+	addr, err := balancer.BackendAddress(balancer.ProtocolUDP, backend.URL)
+	var conn net.Conn
+	if err == nil {
+		// Nothing is sent yet; this only resolves the backend's address
+		conn, err = net.DialTimeout("udp", addr, pool.Settings().Timeouts.Connect)
+	}
+	if err != nil {
+		upstreamErr := upstream.NewError(nil, backend.URL, err)
+		backend.Breaker.RecordError(upstreamErr.Kind)
+		lb.metrics.RecordErrorKind(upstreamErr.Kind)
+		lb.logger.Error("Failed to open UDP session to %s: %v", backend.URL, upstreamErr)
+		return nil
+	}
+
+	backend.Acquire()
+	lb.metrics.RecordUDPSessionOpen(backend.URL)
+	lb.logger.Debug("UDP session from %s to %s opened", client, backend.URL)
+	return &balancer.Session{Client: client, Backend: backend, Conn: conn}
+}
+
+// closeSession closes a session that has been removed from its table
+func (lb *LoadBalancer) closeSession(session *balancer.Session) {
+	session.Conn.Close()
+	session.Backend.Release()
+	lb.metrics.RecordUDPSessionClose(session.Backend.URL)
+	lb.logger.Debug("UDP session from %s to %s closed", session.Client, session.Backend.URL)
+}
+
+// relayReplies sends the backend's datagrams back to the session's client
+// until the session is closed
+func (lb *LoadBalancer) relayReplies(listener net.PacketConn, sessions *balancer.SessionTable, session *balancer.Session) {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, err := session.Conn.Read(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			// Reported for an earlier datagram; the socket is still usable
+			lb.recordDatagramError(session, err)
+			continue
+		}
+		if err != nil {
+			lb.logger.Warn("UDP session from %s to %s failed: %v", session.Client, session.Backend.URL, err)
+			if sessions.Remove(session) {
+				lb.closeSession(session)
+			}
+			return
+		}
+
+		session.Touch()
+		session.Backend.Breaker.RecordSuccess()
+		lb.metrics.RecordDatagram(session.Backend.URL, false)
+		if _, err := listener.WriteTo(buf[:n], session.Client); err != nil {
+			lb.logger.Debug("Failed to relay UDP reply to %s: %v", session.Client, err)
+		}
+	}
+}
+
+// recordDatagramError feeds a failed send or receive to the backend's
+// circuit breaker. UDP only learns that nothing listens on the backend's
+// port from an ICMP error, which surfaces on the next send or receive.
+func (lb *LoadBalancer) recordDatagramError(session *balancer.Session, err error) {
+	backend := session.Backend
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		lb.metrics.RecordPortUnreachable(backend.URL)
+	}
+	kind := upstream.Classify(nil, err)
+	backend.Breaker.RecordError(kind)
+	lb.metrics.RecordErrorKind(kind)
+	lb.logger.Debug("UDP datagram to %s failed: %v", backend.URL, err)
+}
+
+// expireSessions closes sessions that have gone idle until stop is closed.
+// Sessions are checked twice per idle timeout, so one lives for up to one and
+// a half idle timeouts after its last datagram.
+func (lb *LoadBalancer) expireSessions(sessions *balancer.SessionTable, idle time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(idle / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		for _, session := range sessions.Expire(time.Now()) {
+			lb.closeSession(session)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"round-robin-api/internal/balancer"
+	"round-robin-api/internal/config"
+	"round-robin-api/internal/metrics"
+	"round-robin-api/internal/router"
+)
+
+// echoUDPBackend sends every datagram back to where it came from
+func echoUDPBackend(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, maxDatagram)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+	return "udp://" + conn.LocalAddr().String()
+}
+
+// udpSessions waits up to a second for the number of open sessions to
+// reach want and returns the last count seen
+func udpSessions(lb *LoadBalancer, want int64) int64 {
+	deadline := time.Now().Add(time.Second)
+	for {
+		got := lb.metrics.GetMetrics()["udp_sessions"].(int64)
+		if got == want || time.Now().After(deadline) {
+			return got
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func ping(t *testing.T, client net.PacketConn, addr net.Addr) {
+	t.Helper()
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := client.WriteTo([]byte("ping"), addr); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	buf := make([]byte, 16)
+	n, _, err := client.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "ping" {
+		t.Fatalf("Expected ping echoed, got %q, %v", buf[:n], err)
+	}
+}
+
+func TestServeUDP_Sessions(t *testing.T) {
+	backend := echoUDPBackend(t)
+	settings := balancer.PoolSettings{
+		Protocol: balancer.ProtocolUDP,
+		Timeouts: balancer.Timeouts{SessionIdle: 100 * time.Millisecond},
+	}
+	lb := newTestLB(t, settings, []router.Route{}, backend)
+	listener, err := lb.ListenUDP(config.ListenerConfig{Listen: "127.0.0.1:0", Pool: balancer.DefaultPool})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer client.Close()
+
+	ping(t, client, listener.LocalAddr())
+	if got := udpSessions(lb, 1); got != 1 {
+		t.Errorf("Expected 1 session, got %d", got)
+	}
+
+	// An idle session expires, and the client's next datagram opens a
+	// new one
+	if got := udpSessions(lb, 0); got != 0 {
+		t.Fatalf("Expected the idle session to expire, got %d sessions", got)
+	}
+	ping(t, client, listener.LocalAddr())
+	if got := udpSessions(lb, 1); got != 1 {
+		t.Errorf("Expected a new session, got %d", got)
+	}
+	stats := lb.metrics.GetMetrics()["udp"].(map[string]metrics.UDPStats)[backend]
+	if stats.DatagramsSent != 2 || stats.DatagramsReceived != 2 {
+		t.Errorf("Expected 2 datagrams each way, got %+v", stats)
+	}
+
+	// Closing the listener closes its sessions
+	listener.Close()
+	if got := udpSessions(lb, 0); got != 0 {
+		t.Errorf("Expected no sessions after closing, got %d", got)
+	}
+}
@@ -1,3 +1,3 @@
 module round-robin-api
 
-go 1.21
+go 1.24
@@ -5,26 +5,62 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"sync"
 
+	"round-robin-api/internal/balancer"
+	"round-robin-api/internal/config"
 	"round-robin-api/internal/metrics"
+	"round-robin-api/internal/router"
 )
 
 type AdminServer struct {
 	metrics  *metrics.Metrics
 	lb       LoadBalancer
+	pools    PoolManager
+	// routesMu serializes read-modify-write updates of the routing table
+	routesMu sync.Mutex
 }
 
 type LoadBalancer interface {
-	AddBackend(url string)
+	AddBackend(cfg balancer.BackendConfig) error
 	RemoveBackend(url string)
 	GetBackends() []string
+	GetBackendStatus() []balancer.BackendStatus
+	GetStrategy() string
+	SetStrategy(name string) error
 }
 
-func NewAdminServer(metrics *metrics.Metrics, lb LoadBalancer) *AdminServer {
+// PoolManager manages the named backend pools and the routing table
+type PoolManager interface {
+	GetPool(name string) (LoadBalancer, bool)
+	GetPoolStatus() []balancer.PoolStatus
+	AddPool(cfg config.PoolConfig) error
+	RemovePool(name string) error
+	GetRoutes() []router.Route
+	SetRoutes(routes []router.Route) error
+}
+
+// NewAdminServer creates the admin API. lb is the default pool; pools may be
+// nil when there is only the one pool.
+func NewAdminServer(metrics *metrics.Metrics, lb LoadBalancer, pools PoolManager) *AdminServer {
 	return &AdminServer{
 		metrics: metrics,
 		lb:      lb,
+		pools:   pools,
+	}
+}
+
+// poolFor returns the pool selected by the ?pool= query parameter, or the
+// default pool when it is absent
+func (s *AdminServer) poolFor(r *http.Request) (LoadBalancer, bool) {
+	name := r.URL.Query().Get("pool")
+	if name == "" || name == balancer.DefaultPool {
+		return s.lb, true
+	}
+	if s.pools == nil {
+		return nil, false
 	}
+	return s.pools.GetPool(name)
 }
 
 // HandleMetrics returns current metrics
@@ -48,12 +84,19 @@ func (s *AdminServer) HandleHealth(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	health := map[string]interface{}{
-		"backends": s.lb.GetBackends(),
-		"status":   "ok",
+		"backends":       s.lb.GetBackends(),
+		"backend_status": s.lb.GetBackendStatus(),
+		"status":         "ok",
 	}
 	json.NewEncoder(w).Encode(health)
 }
 
+// errorBody renders an error as a JSON body, escaping its message
+func errorBody(err error) string {
+	body, _ := json.Marshal(map[string]string{"error": err.Error()})
+	return string(body)
+}
+
 // extractHostPort extracts host:port from a URL
 func extractHostPort(urlStr string) (string, error) {
 	u, err := url.Parse(urlStr)
@@ -77,8 +120,24 @@ func validateBackendURL(rawURL string) (string, error) {
 	// Ensure scheme is present
 	if u.Scheme == "" {
 		u.Scheme = "http"
-	} else if u.Scheme != "http" && u.Scheme != "https" {
-		return "", fmt.Errorf("invalid URL scheme: must be http or https")
+	} else if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != balancer.SchemeUnix && !balancer.Layer4(u.Scheme) {
+		return "", fmt.Errorf("invalid URL scheme: must be http, https, unix, tcp or udp")
+	}
+
+	// Unix socket backends have a path rather than a host
+	if u.Scheme == balancer.SchemeUnix {
+		if _, _, err := balancer.ParseUnixBackend(rawURL); err != nil {
+			return "", err
+		}
+		return rawURL, nil
+	}
+
+	// TCP and UDP backends have no default port to fall back on
+	if balancer.Layer4(u.Scheme) {
+		if _, err := balancer.BackendAddress(u.Scheme, u.String()); err != nil {
+			return "", err
+		}
+		return u.String(), nil
 	}
 
 	// Validate host and port
@@ -101,19 +160,23 @@ func validateBackendURL(rawURL string) (string, error) {
 // HandleBackends manages backend list
 func (s *AdminServer) HandleBackends(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	
+
+	lb, ok := s.poolFor(r)
+	if !ok {
+		http.Error(w, `{"error":"Unknown pool"}`, http.StatusNotFound)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		// List all backends
-		backends := s.lb.GetBackends()
+		backends := lb.GetBackends()
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"backends": backends,
 		})
 
 	case http.MethodPost, http.MethodDelete:
-		var backend struct {
-			URL string `json:"url"`
-		}
+		var backend balancer.BackendConfig
 		if err := json.NewDecoder(r.Body).Decode(&backend); err != nil {
 			http.Error(w, `{"error":"Invalid request body"}`, http.StatusBadRequest)
 			return
@@ -121,19 +184,31 @@ func (s *AdminServer) HandleBackends(w http.ResponseWriter, r *http.Request) {
 
 		normalizedURL, err := validateBackendURL(backend.URL)
 		if err != nil {
-			http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
+			http.Error(w, errorBody(err), http.StatusBadRequest)
+			return
+		}
+		if backend.Weight < 0 {
+			http.Error(w, `{"error":"weight must be a positive integer"}`, http.StatusBadRequest)
+			return
+		}
+		if backend.Priority < 0 {
+			http.Error(w, `{"error":"priority must be a non-negative integer"}`, http.StatusBadRequest)
 			return
 		}
+		backend.URL = normalizedURL
 
 		if r.Method == http.MethodPost {
-			s.lb.AddBackend(normalizedURL)
+			if err := lb.AddBackend(backend); err != nil {
+				http.Error(w, errorBody(err), http.StatusBadRequest)
+				return
+			}
 			w.WriteHeader(http.StatusCreated)
 			json.NewEncoder(w).Encode(map[string]string{
 				"status": "added",
 				"backend": normalizedURL,
 			})
 		} else {
-			s.lb.RemoveBackend(normalizedURL)
+			lb.RemoveBackend(normalizedURL)
 			w.WriteHeader(http.StatusOK)
 			json.NewEncoder(w).Encode(map[string]string{
 				"status": "removed",
@@ -145,3 +220,182 @@ func (s *AdminServer) HandleBackends(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error":"Method not allowed"}`, http.StatusMethodNotAllowed)
 	}
 }
+
+// HandleStrategy reports or swaps the active balancing strategy
+func (s *AdminServer) HandleStrategy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	lb, ok := s.poolFor(r)
+	if !ok {
+		http.Error(w, `{"error":"Unknown pool"}`, http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"strategy":  lb.GetStrategy(),
+			"available": balancer.Names(),
+		})
+
+	case http.MethodPut:
+		var body struct {
+			Strategy string `json:"strategy"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, `{"error":"Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
+
+		if err := lb.SetStrategy(body.Strategy); err != nil {
+			http.Error(w, errorBody(err), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":   "updated",
+			"strategy": lb.GetStrategy(),
+		})
+
+	default:
+		http.Error(w, `{"error":"Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// HandlePools lists, creates and deletes backend pools
+func (s *AdminServer) HandlePools(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if s.pools == nil {
+		http.Error(w, `{"error":"Pool management not available"}`, http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"pools": s.pools.GetPoolStatus(),
+		})
+
+	case http.MethodPost:
+		var pool config.PoolConfig
+		if err := json.NewDecoder(r.Body).Decode(&pool); err != nil {
+			http.Error(w, `{"error":"Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
+		if err := pool.Validate(); err != nil {
+			http.Error(w, errorBody(err), http.StatusBadRequest)
+			return
+		}
+		for i, backend := range pool.Backends {
+			normalizedURL, err := validateBackendURL(backend.URL)
+			if err != nil {
+				http.Error(w, errorBody(err), http.StatusBadRequest)
+				return
+			}
+			pool.Backends[i].URL = normalizedURL
+		}
+
+		if err := s.pools.AddPool(pool); err != nil {
+			http.Error(w, errorBody(err), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": "added",
+			"pool":   pool.Name,
+		})
+
+	case http.MethodDelete:
+		var body struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, `{"error":"Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
+
+		if err := s.pools.RemovePool(body.Name); err != nil {
+			http.Error(w, errorBody(err), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": "removed",
+			"pool":   body.Name,
+		})
+
+	default:
+		http.Error(w, `{"error":"Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleRoutes lists and edits the routing table. PUT replaces the whole
+// table, POST appends a route and DELETE removes the route at an index.
+func (s *AdminServer) HandleRoutes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if s.pools == nil {
+		http.Error(w, `{"error":"Route management not available"}`, http.StatusNotImplemented)
+		return
+	}
+
+	s.routesMu.Lock()
+	defer s.routesMu.Unlock()
+
+	var routes []router.Route
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"routes": s.pools.GetRoutes(),
+		})
+		return
+
+	case http.MethodPut:
+		var body struct {
+			Routes []router.Route `json:"routes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, `{"error":"Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
+		routes = body.Routes
+
+	case http.MethodPost:
+		var route router.Route
+		if err := json.NewDecoder(r.Body).Decode(&route); err != nil {
+			http.Error(w, `{"error":"Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
+		routes = append(s.pools.GetRoutes(), route)
+
+	case http.MethodDelete:
+		var body struct {
+			Index *int `json:"index"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Index == nil {
+			http.Error(w, `{"error":"Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
+		routes = s.pools.GetRoutes()
+		if *body.Index < 0 || *body.Index >= len(routes) {
+			http.Error(w, `{"error":"Route index out of range"}`, http.StatusNotFound)
+			return
+		}
+		routes = append(routes[:*body.Index], routes[*body.Index+1:]...)
+
+	default:
+		http.Error(w, `{"error":"Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := s.pools.SetRoutes(routes); err != nil {
+		http.Error(w, errorBody(err), http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodPost {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "updated",
+		"routes": s.pools.GetRoutes(),
+	})
+}
@@ -1,21 +1,206 @@
 package admin
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"round-robin-api/internal/balancer"
+	"round-robin-api/internal/config"
 	"round-robin-api/internal/metrics"
+	"round-robin-api/internal/router"
+	"strings"
 	"testing"
 )
 
 type dummyLB struct{}
 
-func (d *dummyLB) AddBackend(url string) {}
+func (d *dummyLB) AddBackend(cfg balancer.BackendConfig) error {
+	if cfg.URL == "tcp://redis-1:6379" {
+		return fmt.Errorf("tcp backend %q needs a pool with protocol tcp", cfg.URL)
+	}
+	return nil
+}
 func (d *dummyLB) RemoveBackend(url string) {}
 func (d *dummyLB) GetBackends() []string { return []string{"http://localhost:8081"} }
+func (d *dummyLB) GetBackendStatus() []balancer.BackendStatus {
+	return []balancer.BackendStatus{{URL: "http://localhost:8081", Weight: 1, Healthy: true}}
+}
+func (d *dummyLB) GetStrategy() string { return "round-robin" }
+func (d *dummyLB) SetStrategy(name string) error { return nil }
 
 func TestNewAdminServer(t *testing.T) {
 	m := metrics.NewMetrics()
 	lb := &dummyLB{}
-	admin := NewAdminServer(m, lb)
+	admin := NewAdminServer(m, lb, nil)
 	if admin == nil {
 		t.Fatal("AdminServer should not be nil")
 	}
 }
+
+func TestHandleHealth_IncludesBackendStatus(t *testing.T) {
+	admin := NewAdminServer(metrics.NewMetrics(), &dummyLB{}, nil)
+	w := httptest.NewRecorder()
+	admin.HandleHealth(w, httptest.NewRequest(http.MethodGet, "/admin/health", nil))
+
+	var body struct {
+		Status        string                   `json:"status"`
+		Backends      []string                 `json:"backends"`
+		BackendStatus []balancer.BackendStatus `json:"backend_status"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if body.Status != "ok" || len(body.Backends) != 1 {
+		t.Errorf("Unexpected health response: %+v", body)
+	}
+	if len(body.BackendStatus) != 1 || !body.BackendStatus[0].Healthy {
+		t.Errorf("Expected backend status details, got %+v", body.BackendStatus)
+	}
+}
+
+type dummyPools struct {
+	pools  map[string]*dummyLB
+	routes []router.Route
+}
+
+func (d *dummyPools) GetPool(name string) (LoadBalancer, bool) {
+	lb, ok := d.pools[name]
+	return lb, ok
+}
+func (d *dummyPools) GetPoolStatus() []balancer.PoolStatus {
+	var statuses []balancer.PoolStatus
+	for name := range d.pools {
+		statuses = append(statuses, balancer.PoolStatus{Name: name})
+	}
+	return statuses
+}
+func (d *dummyPools) AddPool(cfg config.PoolConfig) error {
+	if _, ok := d.pools[cfg.Name]; ok {
+		return fmt.Errorf("pool already exists: %s", cfg.Name)
+	}
+	d.pools[cfg.Name] = &dummyLB{}
+	return nil
+}
+func (d *dummyPools) RemovePool(name string) error {
+	delete(d.pools, name)
+	return nil
+}
+func (d *dummyPools) GetRoutes() []router.Route { return append([]router.Route(nil), d.routes...) }
+func (d *dummyPools) SetRoutes(routes []router.Route) error {
+	for _, rt := range routes {
+		if _, ok := d.pools[rt.Pool]; !ok {
+			return fmt.Errorf("unknown pool %s", rt.Pool)
+		}
+	}
+	d.routes = routes
+	return nil
+}
+
+func TestHandleBackends_UnknownPool(t *testing.T) {
+	admin := NewAdminServer(metrics.NewMetrics(), &dummyLB{}, &dummyPools{pools: map[string]*dummyLB{}})
+	w := httptest.NewRecorder()
+	admin.HandleBackends(w, httptest.NewRequest(http.MethodGet, "/admin/backends?pool=missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown pool, got %d", w.Code)
+	}
+}
+
+func TestHandleBackends_WrongProtocol(t *testing.T) {
+	admin := NewAdminServer(metrics.NewMetrics(), &dummyLB{}, nil)
+	w := httptest.NewRecorder()
+	admin.HandleBackends(w, httptest.NewRequest(http.MethodPost, "/admin/backends", strings.NewReader(`{"url":"tcp://redis-1:6379"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a backend the pool can't reach, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "protocol tcp") {
+		t.Errorf("Expected the reason in the response, got %s", w.Body)
+	}
+}
+
+func TestHandlePools(t *testing.T) {
+	pools := &dummyPools{pools: map[string]*dummyLB{}}
+	admin := NewAdminServer(metrics.NewMetrics(), &dummyLB{}, pools)
+
+	w := httptest.NewRecorder()
+	admin.HandlePools(w, httptest.NewRequest(http.MethodPost, "/admin/pools",
+		strings.NewReader(`{"name":"users","backends":[{"url":"http://users-1"}],"timeout":"1s"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, ok := pools.pools["users"]; !ok {
+		t.Error("Pool should have been created")
+	}
+
+	w = httptest.NewRecorder()
+	admin.HandlePools(w, httptest.NewRequest(http.MethodPost, "/admin/pools",
+		strings.NewReader(`{"name":"bad","strategy":"random"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown strategy, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	admin.HandlePools(w, httptest.NewRequest(http.MethodDelete, "/admin/pools", strings.NewReader(`{"name":"users"}`)))
+	if w.Code != http.StatusOK || len(pools.pools) != 0 {
+		t.Errorf("Expected pool to be removed, got %d", w.Code)
+	}
+}
+
+func TestHandleRoutes(t *testing.T) {
+	pools := &dummyPools{pools: map[string]*dummyLB{"default": {}, "users": {}}}
+	admin := NewAdminServer(metrics.NewMetrics(), &dummyLB{}, pools)
+
+	w := httptest.NewRecorder()
+	admin.HandleRoutes(w, httptest.NewRequest(http.MethodPut, "/admin/routes",
+		strings.NewReader(`{"routes":[{"prefix":"/","pool":"default"}]}`)))
+	if w.Code != http.StatusOK || len(pools.routes) != 1 {
+		t.Fatalf("Expected table to be replaced, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	admin.HandleRoutes(w, httptest.NewRequest(http.MethodPost, "/admin/routes",
+		strings.NewReader(`{"host":"users.example.com","pool":"users"}`)))
+	if w.Code != http.StatusCreated || len(pools.routes) != 2 || pools.routes[1].Host != "users.example.com" {
+		t.Fatalf("Expected route to be appended, got %d: %+v", w.Code, pools.routes)
+	}
+
+	w = httptest.NewRecorder()
+	admin.HandleRoutes(w, httptest.NewRequest(http.MethodPost, "/admin/routes",
+		strings.NewReader(`{"prefix":"/x","pool":"missing"}`)))
+	if w.Code != http.StatusBadRequest || len(pools.routes) != 2 {
+		t.Errorf("Expected route to an unknown pool to be rejected, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	admin.HandleRoutes(w, httptest.NewRequest(http.MethodDelete, "/admin/routes", strings.NewReader(`{"index":0}`)))
+	if w.Code != http.StatusOK || len(pools.routes) != 1 || pools.routes[0].Pool != "users" {
+		t.Errorf("Expected first route to be removed, got %d: %+v", w.Code, pools.routes)
+	}
+
+	w = httptest.NewRecorder()
+	admin.HandleRoutes(w, httptest.NewRequest(http.MethodDelete, "/admin/routes", strings.NewReader(`{"index":5}`)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for out of range index, got %d", w.Code)
+	}
+}
+
+func TestValidateBackendURL(t *testing.T) {
+	valid := map[string]string{
+		"http://localhost":          "http://localhost:80",
+		"https://api.internal":      "https://api.internal:443",
+		"tcp://redis-1:6379":        "tcp://redis-1:6379",
+		"udp://dns-1:53":            "udp://dns-1:53",
+		"unix:///run/app.sock":      "unix:///run/app.sock",
+		"unix:///run/app.sock:/api": "unix:///run/app.sock:/api",
+	}
+	for rawURL, want := range valid {
+		if got, err := validateBackendURL(rawURL); err != nil || got != want {
+			t.Errorf("%q: expected %s, got %q, %v", rawURL, want, got, err)
+		}
+	}
+	for _, rawURL := range []string{"", "ftp://files:21", "tcp://redis-1", "unix://run/app.sock", "unix:///run/app.sock:api"} {
+		if _, err := validateBackendURL(rawURL); err == nil {
+			t.Errorf("%q: expected error", rawURL)
+		}
+	}
+}
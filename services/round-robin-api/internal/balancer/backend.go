@@ -0,0 +1,122 @@
+package balancer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"round-robin-api/internal/circuit"
+)
+
+// DefaultWeight is the weight of a backend that doesn't specify one
+const DefaultWeight = 1
+
+// BackendConfig describes a backend as given in BACKENDS or the admin API
+type BackendConfig struct {
+	URL      string `json:"url"`
+	Weight   int    `json:"weight,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+}
+
+// BackendStatus is a point-in-time view of a backend for the admin API
+type BackendStatus struct {
+	URL          string        `json:"url"`
+	Weight       int           `json:"weight"`
+	Priority     int           `json:"priority"`
+	Healthy      bool          `json:"healthy"`
+	CircuitState circuit.State `json:"circuit_state"`
+	Inflight     int64         `json:"inflight"`
+}
+
+// Backend is a single upstream server along with the per-backend state
+// that strategies use when picking where to send a request
+type Backend struct {
+	URL      string
+	Weight   int
+	Priority int // 0 is the primary tier, higher values are backups
+	Breaker  *circuit.CircuitBreaker
+
+	inflight int64
+	latency  peakEWMA
+
+	rampMu    sync.Mutex
+	ramp      SlowStart
+	rampStart time.Time
+}
+
+// NewBackend creates a backend with a fresh circuit breaker
+func NewBackend(cfg BackendConfig) *Backend {
+	weight := cfg.Weight
+	if weight <= 0 {
+		weight = DefaultWeight
+	}
+	return &Backend{
+		URL:      cfg.URL,
+		Weight:   weight,
+		Priority: cfg.Priority,
+		Breaker:  circuit.NewCircuitBreaker(),
+	}
+}
+
+// Acquire marks the start of a request to the backend
+func (b *Backend) Acquire() {
+	atomic.AddInt64(&b.inflight, 1)
+}
+
+// Release marks the end of a request started with Acquire
+func (b *Backend) Release() {
+	atomic.AddInt64(&b.inflight, -1)
+}
+
+// Inflight returns the number of requests currently outstanding on the backend
+func (b *Backend) Inflight() int64 {
+	return atomic.LoadInt64(&b.inflight)
+}
+
+// ObserveLatency feeds a completed request's response time into the
+// backend's peak EWMA latency estimate
+func (b *Backend) ObserveLatency(rtt time.Duration) {
+	b.latency.observe(rtt, time.Now())
+}
+
+// Latency returns the backend's current peak EWMA latency estimate
+func (b *Backend) Latency() time.Duration {
+	return time.Duration(b.latency.value(time.Now()))
+}
+
+// ParseBackendSpec parses a single BACKENDS entry of the form
+// "url[;key=value...]", e.g. "http://echo-go:8081;weight=3;priority=1"
+func ParseBackendSpec(spec string) (BackendConfig, error) {
+	parts := strings.Split(strings.TrimSpace(spec), ";")
+	cfg := BackendConfig{URL: strings.TrimSpace(parts[0])}
+	if cfg.URL == "" {
+		return cfg, fmt.Errorf("backend URL cannot be empty")
+	}
+
+	for _, opt := range parts[1:] {
+		key, value, found := strings.Cut(strings.TrimSpace(opt), "=")
+		if !found {
+			return cfg, fmt.Errorf("invalid backend option %q: expected key=value", opt)
+		}
+		switch strings.TrimSpace(key) {
+		case "weight":
+			weight, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || weight <= 0 {
+				return cfg, fmt.Errorf("invalid weight %q: must be a positive integer", value)
+			}
+			cfg.Weight = weight
+		case "priority":
+			priority, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || priority < 0 {
+				return cfg, fmt.Errorf("invalid priority %q: must be a non-negative integer", value)
+			}
+			cfg.Priority = priority
+		default:
+			return cfg, fmt.Errorf("unknown backend option %q", key)
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,83 @@
+package balancer
+
+import (
+	"testing"
+)
+
+func TestParseBackendSpec(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		expected BackendConfig
+		wantErr  bool
+	}{
+		{
+			name:     "plain URL",
+			spec:     "http://echo-go:8081",
+			expected: BackendConfig{URL: "http://echo-go:8081"},
+		},
+		{
+			name:     "with weight",
+			spec:     " http://echo-go:8081;weight=3 ",
+			expected: BackendConfig{URL: "http://echo-go:8081", Weight: 3},
+		},
+		{
+			name:     "with weight and priority",
+			spec:     "http://echo-java:8083;weight=2;priority=1",
+			expected: BackendConfig{URL: "http://echo-java:8083", Weight: 2, Priority: 1},
+		},
+		{
+			name:    "negative priority",
+			spec:    "http://echo-go:8081;priority=-1",
+			wantErr: true,
+		},
+		{
+			name:    "empty URL",
+			spec:    ";weight=3",
+			wantErr: true,
+		},
+		{
+			name:    "zero weight",
+			spec:    "http://echo-go:8081;weight=0",
+			wantErr: true,
+		},
+		{
+			name:    "malformed option",
+			spec:    "http://echo-go:8081;weight",
+			wantErr: true,
+		},
+		{
+			name:    "unknown option",
+			spec:    "http://echo-go:8081;color=blue",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ParseBackendSpec(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error for %q", tt.spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if cfg != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, cfg)
+			}
+		})
+	}
+}
+
+func TestNewBackend_DefaultWeight(t *testing.T) {
+	b := NewBackend(BackendConfig{URL: "http://a"})
+	if b.Weight != DefaultWeight {
+		t.Errorf("Expected default weight %d, got %d", DefaultWeight, b.Weight)
+	}
+	if b.Breaker == nil {
+		t.Error("Breaker should be initialized")
+	}
+}
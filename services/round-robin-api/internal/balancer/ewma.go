@@ -0,0 +1,58 @@
+package balancer
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ewmaDecay is the time constant of the peak EWMA: a latency spike loses
+// about 63% of its influence after this long without new samples
+const ewmaDecay = 10 * time.Second
+
+// peakEWMA tracks a latency estimate that jumps up immediately on a slow
+// response and decays back exponentially over time, so a backend that just
+// got slow is penalised right away but recovers smoothly
+type peakEWMA struct {
+	mu    sync.Mutex
+	cost  float64 // nanoseconds
+	stamp time.Time
+}
+
+func (e *peakEWMA) observe(rtt time.Duration, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	sample := float64(rtt)
+	if e.stamp.IsZero() {
+		e.cost = sample
+		e.stamp = now
+		return
+	}
+
+	w := e.weight(now)
+	e.stamp = now
+	if sample > e.cost {
+		e.cost = sample
+	} else {
+		e.cost = e.cost*w + sample*(1-w)
+	}
+}
+
+func (e *peakEWMA) value(now time.Time) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.stamp.IsZero() {
+		return 0
+	}
+	return e.cost * e.weight(now)
+}
+
+func (e *peakEWMA) weight(now time.Time) float64 {
+	elapsed := now.Sub(e.stamp)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Exp(-float64(elapsed) / float64(ewmaDecay))
+}
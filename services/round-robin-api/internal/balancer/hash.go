@@ -0,0 +1,189 @@
+package balancer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"round-robin-api/internal/forward"
+	"round-robin-api/internal/grpcwire"
+)
+
+// virtualNodes is the number of ring points per unit of backend weight
+const virtualNodes = 100
+
+type ringEntry struct {
+	hash    uint64
+	backend *Backend
+}
+
+// ConsistentHash maps a request key onto a hash ring with virtual nodes so
+// the same key keeps hitting the same backend, and adding or removing a
+// backend only remaps the keys that land on its share of the ring. If the
+// owning backend is not a candidate, the next one clockwise takes over.
+type ConsistentHash struct {
+	mu   sync.RWMutex
+	ring []ringEntry
+
+	keySource string
+	keyName   string
+}
+
+// NewConsistentHash creates a consistent-hash strategy keyed on the given
+// source: "ip" (the default), "header:<name>", "cookie:<name>" or
+// "json:<field>" for a top-level field of the JSON request body
+func NewConsistentHash(key string) (*ConsistentHash, error) {
+	if key == "" {
+		key = "ip"
+	}
+	source, name, _ := strings.Cut(key, ":")
+	switch source {
+	case "ip":
+		if name != "" {
+			return nil, fmt.Errorf("invalid hash key %q: ip takes no name", key)
+		}
+	case "header", "cookie", "json":
+		if name == "" {
+			return nil, fmt.Errorf("invalid hash key %q: %s requires a name", key, source)
+		}
+	default:
+		return nil, fmt.Errorf("invalid hash key %q: must be ip, header:<name>, cookie:<name> or json:<field>", key)
+	}
+	return &ConsistentHash{keySource: source, keyName: name}, nil
+}
+
+func (ch *ConsistentHash) Name() string { return "consistent-hash" }
+
+// SetBackends rebuilds the ring from the full backend set
+func (ch *ConsistentHash) SetBackends(backends []*Backend) {
+	ring := make([]ringEntry, 0, len(backends)*virtualNodes)
+	for _, b := range backends {
+		for i := 0; i < virtualNodes*b.Weight; i++ {
+			ring = append(ring, ringEntry{
+				hash:    hashKey(b.URL + "#" + strconv.Itoa(i)),
+				backend: b,
+			})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.ring = ring
+}
+
+func (ch *ConsistentHash) Next(candidates []*Backend, r *http.Request) *Backend {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	eligible := make(map[*Backend]bool, len(candidates))
+	for _, b := range candidates {
+		eligible[b] = true
+	}
+
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	if len(ch.ring) > 0 {
+		h := hashKey(ch.requestKey(r))
+		start := sort.Search(len(ch.ring), func(i int) bool { return ch.ring[i].hash >= h })
+		for i := 0; i < len(ch.ring); i++ {
+			entry := ch.ring[(start+i)%len(ch.ring)]
+			if eligible[entry.backend] {
+				return entry.backend
+			}
+		}
+	}
+
+	// Ring doesn't know any candidate yet; fall back to the first one
+	return candidates[0]
+}
+
+func (ch *ConsistentHash) OnRequestStart(b *Backend) {}
+
+func (ch *ConsistentHash) OnRequestFinish(b *Backend, duration time.Duration, success bool) {}
+
+// requestKey extracts the routing key, falling back to the client IP when
+// the configured header, cookie or field is missing
+func (ch *ConsistentHash) requestKey(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+
+	switch ch.keySource {
+	case "header":
+		if v := r.Header.Get(ch.keyName); v != "" {
+			return v
+		}
+	case "cookie":
+		if c, err := r.Cookie(ch.keyName); err == nil && c.Value != "" {
+			return c.Value
+		}
+	case "json":
+		if v := jsonField(r, ch.keyName); v != "" {
+			return v
+		}
+	}
+	return forward.ClientIP(r)
+}
+
+// maxJSONKeyBody caps how much of a request body is read to find a JSON
+// hash key, as the proxy caps the bodies it buffers
+const maxJSONKeyBody = 1 << 20
+
+// jsonField reads a top-level field from the JSON body and restores the body
+// so it can still be forwarded. Only bodies of known length are read, and
+// no more than maxJSONKeyBody of them: a streamed body, such as a gRPC
+// call's, may not end before the backend has answered.
+func jsonField(r *http.Request, field string) string {
+	if r.Body == nil || r.ContentLength <= 0 || grpcwire.IsGRPC(r.Header) {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxJSONKeyBody))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil {
+		return ""
+	}
+
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ""
+	}
+	raw, ok := payload[field]
+	if !ok {
+		return ""
+	}
+	// Unquote strings so {"id":"42"} and {"id":42} hash the same
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+// hashKey hashes with FNV-1a and runs the result through the murmur3
+// finalizer; plain FNV clusters similar keys like "url#1", "url#2" too
+// closely to spread virtual nodes evenly around the ring
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
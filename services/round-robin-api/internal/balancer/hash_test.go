@@ -0,0 +1,174 @@
+package balancer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newHashBackends(n int) []*Backend {
+	backends := make([]*Backend, n)
+	for i := range backends {
+		backends[i] = NewBackend(BackendConfig{URL: fmt.Sprintf("http://backend-%d:8080", i)})
+	}
+	return backends
+}
+
+func requestWithHeader(value string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api", nil)
+	r.Header.Set("X-Customer-ID", value)
+	return r
+}
+
+func TestNewConsistentHash_KeyValidation(t *testing.T) {
+	valid := []string{"", "ip", "header:X-Customer-ID", "cookie:session", "json:customer_id"}
+	for _, key := range valid {
+		if _, err := NewConsistentHash(key); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", key, err)
+		}
+	}
+
+	invalid := []string{"header", "cookie:", "ip:foo", "query:id"}
+	for _, key := range invalid {
+		if _, err := NewConsistentHash(key); err == nil {
+			t.Errorf("Expected %q to be rejected", key)
+		}
+	}
+}
+
+func TestConsistentHash_SameKeySameBackend(t *testing.T) {
+	ch, _ := NewConsistentHash("header:X-Customer-ID")
+	backends := newHashBackends(3)
+	ch.SetBackends(backends)
+
+	first := ch.Next(backends, requestWithHeader("customer-42"))
+	for i := 0; i < 10; i++ {
+		if got := ch.Next(backends, requestWithHeader("customer-42")); got != first {
+			t.Fatalf("Expected %s for the same key, got %s", first.URL, got.URL)
+		}
+	}
+}
+
+func TestConsistentHash_MinimalRemapOnAdd(t *testing.T) {
+	ch, _ := NewConsistentHash("header:X-Customer-ID")
+	backends := newHashBackends(4)
+	ch.SetBackends(backends[:3])
+
+	keys := 2000
+	before := make([]*Backend, keys)
+	for i := 0; i < keys; i++ {
+		before[i] = ch.Next(backends[:3], requestWithHeader(fmt.Sprintf("customer-%d", i)))
+	}
+
+	ch.SetBackends(backends)
+	moved := 0
+	for i := 0; i < keys; i++ {
+		after := ch.Next(backends, requestWithHeader(fmt.Sprintf("customer-%d", i)))
+		if after != before[i] {
+			moved++
+			if after != backends[3] {
+				t.Fatalf("Key moved between existing backends: %s -> %s", before[i].URL, after.URL)
+			}
+		}
+	}
+
+	// The new backend should take roughly a quarter of the keys
+	if moved < keys/8 || moved > keys*3/8 {
+		t.Errorf("Expected about %d keys to move, got %d", keys/4, moved)
+	}
+}
+
+func TestConsistentHash_FailoverToNextOnRing(t *testing.T) {
+	ch, _ := NewConsistentHash("header:X-Customer-ID")
+	backends := newHashBackends(3)
+	ch.SetBackends(backends)
+
+	r := requestWithHeader("customer-7")
+	owner := ch.Next(backends, r)
+
+	remaining := make([]*Backend, 0, 2)
+	for _, b := range backends {
+		if b != owner {
+			remaining = append(remaining, b)
+		}
+	}
+	got := ch.Next(remaining, r)
+	if got == owner || got == nil {
+		t.Fatalf("Expected failover to another backend, got %v", got)
+	}
+	if again := ch.Next(remaining, r); again != got {
+		t.Errorf("Failover target should be stable, got %s then %s", got.URL, again.URL)
+	}
+}
+
+func TestConsistentHash_KeySources(t *testing.T) {
+	cookieReq := httptest.NewRequest(http.MethodPost, "/api", nil)
+	cookieReq.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+
+	body := `{"customer_id": 42, "payload": "x"}`
+	jsonReq := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(body))
+
+	ipReq := httptest.NewRequest(http.MethodPost, "/api", nil)
+	ipReq.RemoteAddr = "10.0.0.1:51234"
+
+	tests := []struct {
+		key      string
+		req      *http.Request
+		expected string
+	}{
+		{"header:X-Customer-ID", requestWithHeader("c-1"), "c-1"},
+		{"cookie:session", cookieReq, "abc"},
+		{"json:customer_id", jsonReq, "42"},
+		{"ip", ipReq, "10.0.0.1"},
+		{"header:X-Missing", ipReq, "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		ch, _ := NewConsistentHash(tt.key)
+		if got := ch.requestKey(tt.req); got != tt.expected {
+			t.Errorf("%s: expected key %q, got %q", tt.key, tt.expected, got)
+		}
+	}
+
+	// The JSON body must still be readable after extracting the key
+	rest, _ := io.ReadAll(jsonReq.Body)
+	if !bytes.Equal(rest, []byte(body)) {
+		t.Errorf("Request body was not restored, got %q", rest)
+	}
+}
+
+func TestConsistentHash_JSONKeySkipsStreams(t *testing.T) {
+	ch, _ := NewConsistentHash("json:customer_id")
+
+	// A stream that hasn't ended must not be waited for
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	stream := httptest.NewRequest(http.MethodPost, "/api", reader)
+	stream.ContentLength = -1
+	stream.RemoteAddr = "10.0.0.1:51234"
+	if got := ch.requestKey(stream); got != "10.0.0.1" {
+		t.Errorf("Expected a streamed body to fall back to the client IP, got %q", got)
+	}
+
+	grpc := httptest.NewRequest(http.MethodPost, "/echo.Echo/Say", strings.NewReader(`{"customer_id": 42}`))
+	grpc.Header.Set("Content-Type", "application/grpc")
+	grpc.RemoteAddr = "10.0.0.1:51234"
+	if got := ch.requestKey(grpc); got != "10.0.0.1" {
+		t.Errorf("Expected a gRPC call to fall back to the client IP, got %q", got)
+	}
+
+	// Only the start of a large body is read; all of it is still forwarded
+	large := `{"customer_id": 42, "payload": "` + strings.Repeat("x", maxJSONKeyBody) + `"}`
+	r := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(large))
+	r.RemoteAddr = "10.0.0.1:51234"
+	if got := ch.requestKey(r); got != "10.0.0.1" {
+		t.Errorf("Expected a body over the limit to fall back to the client IP, got %q", got)
+	}
+	if rest, _ := io.ReadAll(r.Body); string(rest) != large {
+		t.Errorf("Expected the whole body to be restored, got %d of %d bytes", len(rest), len(large))
+	}
+}
@@ -0,0 +1,115 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"round-robin-api/internal/circuit"
+)
+
+// Health check types
+const (
+	// HealthCheckHTTP expects GET /health to answer {"status":"ok"}
+	HealthCheckHTTP = "http"
+	// HealthCheckGRPC calls the standard grpc.health.v1 Check method
+	HealthCheckGRPC = "grpc"
+	// HealthCheckTCP only opens a connection to the backend's port
+	HealthCheckTCP = "tcp"
+	// HealthCheckUDP sends a datagram and, if a reply is expected, waits
+	// for it
+	HealthCheckUDP = "udp"
+)
+
+// HealthCheckSettings chooses how a pool probes its backends
+type HealthCheckSettings struct {
+	// Type is HealthCheckHTTP, HealthCheckGRPC, HealthCheckTCP or
+	// HealthCheckUDP; empty means the one matching a layer 4 pool's
+	// protocol and HealthCheckHTTP otherwise
+	Type string
+	// Service is the gRPC service asked about; empty asks about the server
+	// as a whole
+	Service string
+	// Send is the datagram a UDP health check sends, e.g. a DNS query
+	Send string
+	// Expect is what the reply to Send must contain; empty expects no
+	// reply and only fails on an ICMP port unreachable
+	Expect string
+}
+
+// ValidateHealthCheckType checks a health check type name; empty picks the
+// pool's default
+func ValidateHealthCheckType(name string) error {
+	switch name {
+	case "", HealthCheckHTTP, HealthCheckGRPC, HealthCheckTCP, HealthCheckUDP:
+		return nil
+	}
+	return fmt.Errorf("unknown health check type %q: must be %s, %s, %s or %s", name, HealthCheckHTTP, HealthCheckGRPC, HealthCheckTCP, HealthCheckUDP)
+}
+
+// Validate checks the health check against the protocol spoken to the
+// backends: gRPC needs HTTP/2, TCP pools can only be checked with TCP and
+// UDP pools only with UDP
+func (h HealthCheckSettings) Validate(protocol string) error {
+	if err := ValidateHealthCheckType(h.Type); err != nil {
+		return err
+	}
+	if (h.Send != "" || h.Expect != "") && h.Type != HealthCheckUDP && protocol != ProtocolUDP {
+		return fmt.Errorf("health check send and expect are only used by %s health checks", HealthCheckUDP)
+	}
+	if h.Type == HealthCheckUDP && protocol != ProtocolUDP {
+		return fmt.Errorf("%s health checks need protocol %s", HealthCheckUDP, ProtocolUDP)
+	}
+	if Layer4(protocol) && h.Type != "" && h.Type != protocol {
+		return fmt.Errorf("%s pools only support %s health checks", protocol, protocol)
+	}
+	if h.Type != HealthCheckGRPC {
+		if h.Service != "" {
+			return fmt.Errorf("health check service is only used by %s health checks", HealthCheckGRPC)
+		}
+		return nil
+	}
+	if protocol != ProtocolHTTP2 && protocol != ProtocolH2C {
+		return fmt.Errorf("%s health checks need protocol %s or %s", HealthCheckGRPC, ProtocolHTTP2, ProtocolH2C)
+	}
+	return nil
+}
+
+// probe returns the circuit probe for the health check type
+func (h HealthCheckSettings) probe() circuit.Probe {
+	switch h.Type {
+	case HealthCheckGRPC:
+		return requestURLProbe(circuit.GRPCProbe(h.Service))
+	case HealthCheckTCP:
+		return tcpProbe
+	case HealthCheckUDP:
+		return circuit.UDPProbe([]byte(h.Send), []byte(h.Expect))
+	}
+	return requestURLProbe(circuit.HTTPProbe)
+}
+
+// tcpProbe checks that a backend accepts connections, on its Unix socket
+// for unix backends
+func tcpProbe(client *http.Client, backendURL string) bool {
+	if !IsUnixBackend(backendURL) {
+		return circuit.TCPProbe(client, backendURL)
+	}
+	socket, _, err := ParseUnixBackend(backendURL)
+	if err != nil {
+		return false
+	}
+	conn, err := net.DialTimeout("unix", socket, client.Timeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// requestURLProbe lets an HTTP probe reach Unix socket backends, by handing
+// it the backend's URL as requests see it
+func requestURLProbe(probe circuit.Probe) circuit.Probe {
+	return func(client *http.Client, backendURL string) bool {
+		return probe(client, RequestURL(backendURL, ""))
+	}
+}
@@ -0,0 +1,55 @@
+package balancer
+
+import "testing"
+
+func TestHealthCheckSettings_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		check    HealthCheckSettings
+		protocol string
+		wantErr  bool
+	}{
+		{"default", HealthCheckSettings{}, ProtocolHTTP1, false},
+		{"http", HealthCheckSettings{Type: HealthCheckHTTP}, ProtocolH2C, false},
+		{"grpc over h2c", HealthCheckSettings{Type: HealthCheckGRPC, Service: "echo.Echo"}, ProtocolH2C, false},
+		{"grpc over http2", HealthCheckSettings{Type: HealthCheckGRPC}, ProtocolHTTP2, false},
+		{"grpc over http1", HealthCheckSettings{Type: HealthCheckGRPC}, ProtocolHTTP1, true},
+		{"service without grpc", HealthCheckSettings{Service: "echo.Echo"}, ProtocolH2C, true},
+		{"tcp", HealthCheckSettings{Type: HealthCheckTCP}, ProtocolHTTP1, false},
+		{"tcp pool default", HealthCheckSettings{}, ProtocolTCP, false},
+		{"http on a tcp pool", HealthCheckSettings{Type: HealthCheckHTTP}, ProtocolTCP, true},
+		{"udp", HealthCheckSettings{Type: HealthCheckUDP, Send: "ping", Expect: "pong"}, ProtocolUDP, false},
+		{"udp pool default", HealthCheckSettings{Send: "ping"}, ProtocolUDP, false},
+		{"udp on an http pool", HealthCheckSettings{Type: HealthCheckUDP}, ProtocolHTTP1, true},
+		{"tcp on a udp pool", HealthCheckSettings{Type: HealthCheckTCP}, ProtocolUDP, true},
+		{"send without udp", HealthCheckSettings{Send: "ping"}, ProtocolHTTP1, true},
+		{"unknown type", HealthCheckSettings{Type: "icmp"}, ProtocolHTTP1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.check.Validate(tt.protocol)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNewPool_Layer4(t *testing.T) {
+	for _, protocol := range []string{ProtocolTCP, ProtocolUDP} {
+		settings := PoolSettings{Protocol: protocol}
+		if _, err := NewPool("l4", []BackendConfig{{URL: "http://redis-1:6379"}}, settings, nil); err == nil {
+			t.Errorf("%s: expected error for an http backend URL", protocol)
+		}
+
+		pool, err := NewPool("l4", nil, settings, nil)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", protocol, err)
+		}
+		pool.Close()
+		if got := pool.Settings().HealthCheck.Type; got != protocol {
+			t.Errorf("Expected %s pools to default to %s health checks, got %s", protocol, protocol, got)
+		}
+	}
+}
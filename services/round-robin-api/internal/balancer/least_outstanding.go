@@ -0,0 +1,43 @@
+package balancer
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// LeastOutstanding sends each request to the candidate with the fewest
+// in-flight requests relative to its effective weight. The scan starts at a
+// rotating offset so ties are broken round-robin instead of always favouring
+// the first backend.
+type LeastOutstanding struct {
+	index uint64
+}
+
+func (lo *LeastOutstanding) Name() string { return "least-outstanding" }
+
+func (lo *LeastOutstanding) Next(candidates []*Backend, r *http.Request) *Backend {
+	n := uint64(len(candidates))
+	if n == 0 {
+		return nil
+	}
+
+	start := atomic.AddUint64(&lo.index, 1)
+	var best *Backend
+	var bestScore float64
+	for i := uint64(0); i < n; i++ {
+		b := candidates[(start+i)%n]
+		// Counting the request about to be sent keeps an idle backend in
+		// slow start from winning every pick while it still has no load
+		score := float64(b.Inflight()+1) / b.EffectiveWeight()
+		if best == nil || score < bestScore {
+			best = b
+			bestScore = score
+		}
+	}
+	return best
+}
+
+func (lo *LeastOutstanding) OnRequestStart(b *Backend) {}
+
+func (lo *LeastOutstanding) OnRequestFinish(b *Backend, duration time.Duration, success bool) {}
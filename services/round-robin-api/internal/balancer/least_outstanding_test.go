@@ -0,0 +1,57 @@
+package balancer
+
+import (
+	"testing"
+)
+
+func TestLeastOutstanding_PicksFewestInflight(t *testing.T) {
+	lo := &LeastOutstanding{}
+	a := NewBackend(BackendConfig{URL: "a"})
+	b := NewBackend(BackendConfig{URL: "b"})
+	c := NewBackend(BackendConfig{URL: "c"})
+
+	a.Acquire()
+	a.Acquire()
+	c.Acquire()
+
+	for i := 0; i < 10; i++ {
+		if got := lo.Next([]*Backend{a, b, c}, nil); got != b {
+			t.Fatalf("Expected b with no outstanding requests, got %s", got.URL)
+		}
+	}
+
+	b.Acquire()
+	b.Acquire()
+	if got := lo.Next([]*Backend{a, b, c}, nil); got != c {
+		t.Errorf("Expected c with one outstanding request, got %s", got.URL)
+	}
+}
+
+func TestLeastOutstanding_TieBreakRoundRobin(t *testing.T) {
+	lo := &LeastOutstanding{}
+	backends := []*Backend{
+		NewBackend(BackendConfig{URL: "a"}),
+		NewBackend(BackendConfig{URL: "b"}),
+		NewBackend(BackendConfig{URL: "c"}),
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 30; i++ {
+		counts[lo.Next(backends, nil).URL]++
+	}
+	for _, b := range backends {
+		if counts[b.URL] != 10 {
+			t.Errorf("Expected 10 picks for %s on ties, got %d", b.URL, counts[b.URL])
+		}
+	}
+}
+
+func TestBackend_AcquireRelease(t *testing.T) {
+	b := NewBackend(BackendConfig{URL: "a"})
+	b.Acquire()
+	b.Acquire()
+	b.Release()
+	if got := b.Inflight(); got != 1 {
+		t.Errorf("Expected 1 in-flight request, got %d", got)
+	}
+}
@@ -0,0 +1,58 @@
+package balancer
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// unmeasuredPenalty is the cost assigned to a backend that has requests in
+// flight but no latency sample yet, so a new backend isn't flooded before
+// its first response comes back
+const unmeasuredPenalty = float64(time.Second)
+
+// PowerOfTwoChoices samples two random candidates and sends the request to
+// the one with the lower peak-EWMA latency multiplied by its outstanding
+// requests (the Finagle/Linkerd "peak EWMA" load metric). Only the two
+// sampled backends are inspected, so no lock over the whole pool is needed.
+type PowerOfTwoChoices struct{}
+
+func (p *PowerOfTwoChoices) Name() string { return "p2c-ewma" }
+
+func (p *PowerOfTwoChoices) Next(candidates []*Backend, r *http.Request) *Backend {
+	switch len(candidates) {
+	case 0:
+		return nil
+	case 1:
+		return candidates[0]
+	}
+
+	i := rand.Intn(len(candidates))
+	j := rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := candidates[i], candidates[j]
+
+	now := time.Now()
+	if b.load(now) < a.load(now) {
+		return b
+	}
+	return a
+}
+
+func (p *PowerOfTwoChoices) OnRequestStart(b *Backend) {}
+
+func (p *PowerOfTwoChoices) OnRequestFinish(b *Backend, duration time.Duration, success bool) {}
+
+// load scores a backend by latency times outstanding requests, divided by
+// its effective weight so heavier backends and those out of slow start
+// absorb proportionally more
+func (b *Backend) load(now time.Time) float64 {
+	cost := b.latency.value(now)
+	inflight := float64(b.Inflight())
+	if cost == 0 && inflight > 0 {
+		return (unmeasuredPenalty + inflight) / b.EffectiveWeight()
+	}
+	return cost * (inflight + 1) / b.EffectiveWeight()
+}
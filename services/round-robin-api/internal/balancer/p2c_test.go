@@ -0,0 +1,67 @@
+package balancer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeakEWMA_JumpsOnPeakAndDecays(t *testing.T) {
+	var e peakEWMA
+	now := time.Now()
+
+	e.observe(10*time.Millisecond, now)
+	e.observe(100*time.Millisecond, now)
+	if got := time.Duration(e.value(now)); got != 100*time.Millisecond {
+		t.Errorf("Expected peak of 100ms, got %v", got)
+	}
+
+	later := now.Add(ewmaDecay)
+	if got := time.Duration(e.value(later)); got >= 50*time.Millisecond {
+		t.Errorf("Expected estimate to decay after %v, got %v", ewmaDecay, got)
+	}
+
+	e.observe(10*time.Millisecond, later)
+	if got := time.Duration(e.value(later)); got <= 10*time.Millisecond || got >= 100*time.Millisecond {
+		t.Errorf("Expected smoothed estimate between samples, got %v", got)
+	}
+}
+
+func TestPowerOfTwoChoices_PrefersFasterBackend(t *testing.T) {
+	p := &PowerOfTwoChoices{}
+	fast := NewBackend(BackendConfig{URL: "fast"})
+	slow := NewBackend(BackendConfig{URL: "slow"})
+	fast.ObserveLatency(5 * time.Millisecond)
+	slow.ObserveLatency(500 * time.Millisecond)
+
+	for i := 0; i < 20; i++ {
+		if got := p.Next([]*Backend{fast, slow}, nil); got != fast {
+			t.Fatalf("Expected fast backend, got %s", got.URL)
+		}
+	}
+}
+
+func TestPowerOfTwoChoices_AccountsForInflight(t *testing.T) {
+	p := &PowerOfTwoChoices{}
+	busy := NewBackend(BackendConfig{URL: "busy"})
+	idle := NewBackend(BackendConfig{URL: "idle"})
+	busy.ObserveLatency(10 * time.Millisecond)
+	idle.ObserveLatency(20 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		busy.Acquire()
+	}
+
+	if got := p.Next([]*Backend{busy, idle}, nil); got != idle {
+		t.Errorf("Expected idle backend, got %s", got.URL)
+	}
+}
+
+func TestPowerOfTwoChoices_SingleOrNoCandidate(t *testing.T) {
+	p := &PowerOfTwoChoices{}
+	if p.Next(nil, nil) != nil {
+		t.Error("Expected nil with no candidates")
+	}
+	only := NewBackend(BackendConfig{URL: "only"})
+	if p.Next([]*Backend{only}, nil) != only {
+		t.Error("Expected the only candidate")
+	}
+}
@@ -0,0 +1,424 @@
+package balancer
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"round-robin-api/internal/circuit"
+	"round-robin-api/internal/logger"
+)
+
+// DefaultPool is the name of the pool built from the BACKENDS variable
+const DefaultPool = "default"
+
+// PoolSettings tunes how a pool balances and protects its backends
+type PoolSettings struct {
+	// Strategy is the balancing strategy name; empty means DefaultStrategy
+	Strategy string
+	Options  Options
+	Timeouts Timeouts
+	Breaker  circuit.Settings
+	// Overprovisioning controls how traffic spills from one priority level
+	// to the next; 0 means strict primary/backup failover
+	Overprovisioning float64
+	SlowStart        SlowStart
+	// Retries is how many other backends a failed request may be retried
+	// on; 0 disables retries
+	Retries int
+	// RetryBudget caps retries at this share of requests; 0 means
+	// DefaultRetryBudget. Hedged requests draw from the same budget.
+	RetryBudget float64
+	Hedge       HedgeSettings
+	// Protocol is spoken to the backends: ProtocolHTTP1 (the default),
+	// ProtocolHTTP2, ProtocolH2C, ProtocolTCP or ProtocolUDP
+	Protocol string
+	// FlushInterval is how often a streamed response is flushed to the
+	// client; 0 flushes after every write. Server-sent events are always
+	// flushed at once.
+	FlushInterval time.Duration
+	HealthCheck   HealthCheckSettings
+	// TLS applies to https backends, both proxied requests and health
+	// checks
+	TLS TLSSettings
+	// ProxyProtocol is the PROXY protocol version sent at the start of
+	// every connection to an http1 or tcp backend; empty sends none
+	ProxyProtocol string
+}
+
+// HedgeSettings controls hedged requests: when a backend hasn't answered an
+// idempotent request within its Percentile latency, a second copy is sent to
+// another backend and whichever answers first wins
+type HedgeSettings struct {
+	// Percentile of the backend's recent latencies to wait for, e.g. 0.95;
+	// 0 disables hedging
+	Percentile float64
+	// MinDelay keeps very fast backends from being hedged almost at once
+	MinDelay time.Duration
+}
+
+// Enabled reports whether hedging is configured
+func (h HedgeSettings) Enabled() bool {
+	return h.Percentile > 0
+}
+
+// PoolStatus describes a pool's settings and backends
+type PoolStatus struct {
+	Name             string          `json:"name"`
+	Strategy         string          `json:"strategy"`
+	HashKey          string          `json:"hash_key,omitempty"`
+	Protocol         string          `json:"protocol"`
+	Timeouts         TimeoutStatus   `json:"timeouts"`
+	FailureThreshold int             `json:"failure_threshold"`
+	OpenTimeout      string          `json:"open_timeout"`
+	Retries          int             `json:"retries"`
+	RetryBudget      float64         `json:"retry_budget"`
+	HedgePercentile  float64         `json:"hedge_percentile,omitempty"`
+	FlushInterval    string          `json:"flush_interval"`
+	HealthCheck      string          `json:"health_check"`
+	TLS              *TLSStatus      `json:"tls,omitempty"`
+	ProxyProtocol    string          `json:"proxy_protocol,omitempty"`
+	Backends         []BackendStatus `json:"backends"`
+}
+
+// Pool is a named group of backends sharing a balancing strategy and
+// health checker
+type Pool struct {
+	sync.RWMutex
+	name          string
+	backends      []*Backend
+	strategy      Strategy
+	settings      PoolSettings
+	retryBudget   *RetryBudget
+	client        *http.Client
+	healthChecker *circuit.HealthChecker
+	logger        *logger.Logger
+}
+
+// NewPool creates a pool and starts health checking its backends
+func NewPool(name string, configs []BackendConfig, settings PoolSettings, appLogger *logger.Logger) (*Pool, error) {
+	strategy, err := New(settings.Strategy, settings.Options)
+	if err != nil {
+		return nil, err
+	}
+	settings.Strategy = strategy.Name()
+	if err := ValidateProtocol(settings.Protocol); err != nil {
+		return nil, err
+	}
+	if settings.Protocol == "" {
+		settings.Protocol = ProtocolHTTP1
+	}
+	if err := settings.HealthCheck.Validate(settings.Protocol); err != nil {
+		return nil, err
+	}
+	if settings.HealthCheck.Type == "" {
+		switch settings.Protocol {
+		case ProtocolTCP:
+			settings.HealthCheck.Type = HealthCheckTCP
+		case ProtocolUDP:
+			settings.HealthCheck.Type = HealthCheckUDP
+		default:
+			settings.HealthCheck.Type = HealthCheckHTTP
+		}
+	}
+	if err := validateProxyProtocol(settings.ProxyProtocol, settings.Protocol); err != nil {
+		return nil, err
+	}
+	for _, cfg := range configs {
+		if err := ValidateBackend(settings.Protocol, cfg.URL); err != nil {
+			return nil, err
+		}
+	}
+	settings.Timeouts = settings.Timeouts.WithDefaults()
+	defaults := circuit.DefaultSettings()
+	if settings.Breaker.FailureThreshold <= 0 {
+		settings.Breaker.FailureThreshold = defaults.FailureThreshold
+	}
+	if settings.Breaker.OpenTimeout <= 0 {
+		settings.Breaker.OpenTimeout = defaults.OpenTimeout
+	}
+	if settings.Retries < 0 {
+		settings.Retries = 0
+	}
+	if settings.RetryBudget <= 0 {
+		settings.RetryBudget = DefaultRetryBudget
+	}
+
+	// Proxied requests are bounded by Timeouts.Total through their context
+	// rather than a client timeout, so client deadlines can shorten it
+	tlsConfig, err := settings.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+	transport := newTransport(settings.Timeouts, settings.Protocol, tlsConfig, settings.ProxyProtocol)
+	p := &Pool{
+		name:        name,
+		strategy:    strategy,
+		settings:    settings,
+		retryBudget: NewRetryBudget(settings.RetryBudget),
+		client:      &http.Client{Transport: unixTransport{transport}},
+		healthChecker: circuit.NewHealthCheckerWithClient(&http.Client{
+			Transport: unixTransport{transport},
+			Timeout:   settings.Timeouts.HealthCheck,
+		}),
+		logger: appLogger,
+	}
+	p.healthChecker.SetProbe(settings.HealthCheck.probe())
+	for _, cfg := range configs {
+		backend := p.newBackend(cfg)
+		p.backends = append(p.backends, backend)
+		// Start health checking for this backend
+		p.healthChecker.StartChecking(cfg.URL, time.Second*5) // Check every 5 seconds
+		appLogger.Info("Added backend to pool %s: %s (weight %d, priority %d)", name, cfg.URL, backend.Weight, backend.Priority)
+	}
+	p.notifyBackends()
+	p.healthChecker.OnRecover(p.handleRecovery)
+	return p, nil
+}
+
+// newBackend creates a backend whose circuit breaker uses the pool's settings
+func (p *Pool) newBackend(cfg BackendConfig) *Backend {
+	backend := NewBackend(cfg)
+	backend.Breaker = circuit.NewCircuitBreakerWithSettings(p.settings.Breaker)
+	return backend
+}
+
+// Name returns the pool's name
+func (p *Pool) Name() string {
+	return p.name
+}
+
+// AddBackend adds a new backend to the pool, which must be able to reach
+// it with its protocol
+func (p *Pool) AddBackend(cfg BackendConfig) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if err := ValidateBackend(p.settings.Protocol, cfg.URL); err != nil {
+		return err
+	}
+
+	// Check if backend already exists
+	for _, b := range p.backends {
+		if b.URL == cfg.URL { // URL is already normalized by admin layer
+			p.logger.Warn("Backend already exists in pool %s: %s", p.name, cfg.URL)
+			return nil // Already exists
+		}
+	}
+
+	backend := p.newBackend(cfg)
+	backend.BeginSlowStart(p.settings.SlowStart)
+	p.backends = append(p.backends, backend)
+	p.notifyBackends()
+	p.healthChecker.StartChecking(cfg.URL, time.Second*5)
+	p.logger.Info("Added new backend to pool %s: %s (weight %d, priority %d)", p.name, cfg.URL, backend.Weight, backend.Priority)
+	return nil
+}
+
+// RemoveBackend removes a backend from the pool
+func (p *Pool) RemoveBackend(url string) {
+	p.Lock()
+	defer p.Unlock()
+
+	initialCount := len(p.backends)
+	newBackends := make([]*Backend, 0)
+	for _, b := range p.backends {
+		if b.URL != url { // URL is already normalized by admin layer
+			newBackends = append(newBackends, b)
+		}
+	}
+	p.backends = newBackends
+	p.notifyBackends()
+
+	if len(p.backends) < initialCount {
+		p.healthChecker.StopChecking(url)
+		p.logger.Info("Removed backend from pool %s: %s", p.name, url)
+	} else {
+		p.logger.Warn("Backend not found for removal in pool %s: %s", p.name, url)
+	}
+}
+
+// Close stops health checking every backend in the pool and closes idle
+// connections to them
+func (p *Pool) Close() {
+	p.RLock()
+	defer p.RUnlock()
+	for _, backend := range p.backends {
+		p.healthChecker.StopChecking(backend.URL)
+	}
+	p.client.CloseIdleConnections()
+}
+
+// Client returns the HTTP client for forwarding requests to the pool's
+// backends
+func (p *Pool) Client() *http.Client {
+	return p.client
+}
+
+// Settings returns the pool's current settings
+func (p *Pool) Settings() PoolSettings {
+	p.RLock()
+	defer p.RUnlock()
+	return p.settings
+}
+
+// Status returns the pool's settings and the state of its backends
+func (p *Pool) Status() PoolStatus {
+	settings := p.Settings()
+	return PoolStatus{
+		Name:             p.name,
+		Strategy:         settings.Strategy,
+		HashKey:          settings.Options.HashKey,
+		Protocol:         settings.Protocol,
+		Timeouts:         settings.Timeouts.Status(),
+		FailureThreshold: settings.Breaker.FailureThreshold,
+		OpenTimeout:      settings.Breaker.OpenTimeout.String(),
+		Retries:          settings.Retries,
+		RetryBudget:      settings.RetryBudget,
+		HedgePercentile:  settings.Hedge.Percentile,
+		FlushInterval:    settings.FlushInterval.String(),
+		HealthCheck:      settings.HealthCheck.Type,
+		TLS:              settings.TLS.Status(),
+		ProxyProtocol:    settings.ProxyProtocol,
+		Backends:         p.GetBackendStatus(),
+	}
+}
+
+// handleRecovery puts a backend that passed its health check again into
+// slow start so it isn't hit with a full share of traffic straight away
+func (p *Pool) handleRecovery(url string) {
+	p.RLock()
+	defer p.RUnlock()
+
+	for _, backend := range p.backends {
+		if backend.URL == url {
+			backend.BeginSlowStart(p.settings.SlowStart)
+			p.logger.Info("Backend recovered in pool %s: %s", p.name, url)
+			return
+		}
+	}
+}
+
+// GetBackends returns a list of backend URLs
+func (p *Pool) GetBackends() []string {
+	p.RLock()
+	defer p.RUnlock()
+
+	urls := make([]string, len(p.backends))
+	for i, backend := range p.backends {
+		urls[i] = backend.URL
+	}
+	return urls
+}
+
+// GetBackendStatus returns the configuration and current state of every backend
+func (p *Pool) GetBackendStatus() []BackendStatus {
+	p.RLock()
+	defer p.RUnlock()
+
+	statuses := make([]BackendStatus, len(p.backends))
+	for i, backend := range p.backends {
+		statuses[i] = BackendStatus{
+			URL:          backend.URL,
+			Weight:       backend.Weight,
+			Priority:     backend.Priority,
+			Healthy:      p.healthChecker.IsHealthy(backend.URL),
+			CircuitState: backend.Breaker.GetState(),
+			Inflight:     backend.Inflight(),
+		}
+	}
+	return statuses
+}
+
+// GetStrategy returns the name of the active balancing strategy
+func (p *Pool) GetStrategy() string {
+	return p.Strategy().Name()
+}
+
+// SetStrategy swaps the balancing strategy at runtime
+func (p *Pool) SetStrategy(name string) error {
+	p.Lock()
+	defer p.Unlock()
+
+	strategy, err := New(name, p.settings.Options)
+	if err != nil {
+		return err
+	}
+	p.strategy = strategy
+	p.settings.Strategy = strategy.Name()
+	p.notifyBackends()
+	p.logger.Info("Switched balancing strategy of pool %s to %s", p.name, strategy.Name())
+	return nil
+}
+
+// RetryBudget returns the budget limiting retries within the pool
+func (p *Pool) RetryBudget() *RetryBudget {
+	return p.retryBudget
+}
+
+// Strategy returns the active strategy, for bracketing forwarded requests
+// with its OnRequestStart/OnRequestFinish hooks
+func (p *Pool) Strategy() Strategy {
+	p.RLock()
+	defer p.RUnlock()
+	return p.strategy
+}
+
+// notifyBackends tells strategies that track the full backend set about a
+// change. Must be called with the lock held.
+func (p *Pool) notifyBackends() {
+	if observer, ok := p.strategy.(BackendObserver); ok {
+		observer.SetBackends(p.backends)
+	}
+}
+
+// available reports whether a backend can take traffic right now
+func (p *Pool) available(backend *Backend) bool {
+	return p.healthChecker.IsHealthy(backend.URL) && backend.Breaker.IsAvailable()
+}
+
+// NextBackend picks a backend for the request, or returns nil if no backend
+// is healthy. Excluded backends, e.g. ones a retry already failed on, are
+// never picked.
+func (p *Pool) NextBackend(r *http.Request, exclude ...*Backend) *Backend {
+	p.RLock()
+	// Only healthy backends with an available circuit are eligible
+	candidates := make([]*Backend, 0, len(p.backends))
+	for _, backend := range p.backends {
+		if p.available(backend) && !containsBackend(exclude, backend) {
+			candidates = append(candidates, backend)
+		}
+	}
+	candidates = SelectPriority(p.backends, candidates, p.settings.Overprovisioning)
+	strategy := p.strategy
+	p.RUnlock()
+
+	// Pick outside the lock: some strategies read the request body
+	return strategy.Next(candidates, r)
+}
+
+// AvailableBackend returns the backend with the given URL if it is still in
+// the pool, healthy and its circuit is available
+func (p *Pool) AvailableBackend(url string) *Backend {
+	p.RLock()
+	defer p.RUnlock()
+
+	for _, backend := range p.backends {
+		if backend.URL == url {
+			if p.available(backend) {
+				return backend
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+func containsBackend(backends []*Backend, backend *Backend) bool {
+	for _, b := range backends {
+		if b == backend {
+			return true
+		}
+	}
+	return false
+}
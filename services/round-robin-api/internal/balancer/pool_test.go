@@ -0,0 +1,48 @@
+package balancer
+
+import (
+	"testing"
+
+	"round-robin-api/internal/logger"
+)
+
+func TestPool_AddBackend(t *testing.T) {
+	pool, err := NewPool("web", nil, PoolSettings{}, logger.New(logger.ERROR))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer pool.Close()
+
+	if err := pool.AddBackend(BackendConfig{URL: "tcp://127.0.0.1:9000"}); err == nil {
+		t.Error("Expected error for a tcp backend in an HTTP pool")
+	}
+	if err := pool.AddBackend(BackendConfig{URL: "http://127.0.0.1:9000"}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if backends := pool.GetBackends(); len(backends) != 1 || backends[0] != "http://127.0.0.1:9000" {
+		t.Errorf("Expected only the http backend to be added, got %v", backends)
+	}
+
+	relay, err := NewPool("redis", nil, PoolSettings{Protocol: ProtocolTCP}, logger.New(logger.ERROR))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer relay.Close()
+	if err := relay.AddBackend(BackendConfig{URL: "http://127.0.0.1:9000"}); err == nil {
+		t.Error("Expected error for an http backend in a tcp pool")
+	}
+
+	dns, err := NewPool("dns", nil, PoolSettings{Protocol: ProtocolUDP}, logger.New(logger.ERROR))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer dns.Close()
+	for _, backendURL := range []string{"http://127.0.0.1:53", "tcp://127.0.0.1:53"} {
+		if err := dns.AddBackend(BackendConfig{URL: backendURL}); err == nil {
+			t.Errorf("%q: expected error in a udp pool", backendURL)
+		}
+	}
+	if err := pool.AddBackend(BackendConfig{URL: "udp://127.0.0.1:53"}); err == nil {
+		t.Error("Expected error for a udp backend in an HTTP pool")
+	}
+}
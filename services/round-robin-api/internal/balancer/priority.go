@@ -0,0 +1,72 @@
+package balancer
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// SelectPriority narrows the candidates down to a single priority level.
+//
+// With an overprovisioning factor of 0 this is strict failover: all traffic
+// goes to the lowest-numbered level that has any candidate, so backups only
+// see traffic once every primary is unhealthy or open-circuited.
+//
+// With a positive factor it follows Envoy's priority load model: a level's
+// health is min(1, factor * candidates / backends), the healthiest-first
+// levels absorb load up to their health, and the rest spills over to the
+// next level. E.g. with factor 1.4 a level can lose ~28% of its backends
+// before any traffic moves to backups.
+func SelectPriority(backends, candidates []*Backend, overprovisioning float64) []*Backend {
+	if len(candidates) == 0 {
+		return candidates
+	}
+
+	total := make(map[int]int)
+	for _, b := range backends {
+		total[b.Priority]++
+	}
+	byLevel := make(map[int][]*Backend)
+	for _, b := range candidates {
+		byLevel[b.Priority] = append(byLevel[b.Priority], b)
+	}
+	if len(byLevel) == 1 {
+		return candidates
+	}
+
+	levels := make([]int, 0, len(byLevel))
+	for level := range byLevel {
+		levels = append(levels, level)
+	}
+	sort.Ints(levels)
+
+	if overprovisioning <= 0 {
+		return byLevel[levels[0]]
+	}
+
+	// Levels that are configured but have no candidates have zero health
+	// and take no load, so only levels with candidates need to be scored
+	loads := make([]float64, len(levels))
+	remaining, sum := 1.0, 0.0
+	for i, level := range levels {
+		health := overprovisioning * float64(len(byLevel[level])) / float64(total[level])
+		if health > 1 {
+			health = 1
+		}
+		if health > remaining {
+			health = remaining
+		}
+		loads[i] = health
+		remaining -= health
+		sum += health
+	}
+
+	// If the levels together can't carry all traffic, scale up proportionally
+	pick := rand.Float64() * sum
+	for i, level := range levels {
+		if pick < loads[i] {
+			return byLevel[level]
+		}
+		pick -= loads[i]
+	}
+	return byLevel[levels[len(levels)-1]]
+}
@@ -0,0 +1,80 @@
+package balancer
+
+import (
+	"testing"
+)
+
+func newPriorityBackends(primaries, backups int) []*Backend {
+	var backends []*Backend
+	for i := 0; i < primaries; i++ {
+		backends = append(backends, NewBackend(BackendConfig{URL: "primary"}))
+	}
+	for i := 0; i < backups; i++ {
+		backends = append(backends, NewBackend(BackendConfig{URL: "backup", Priority: 1}))
+	}
+	return backends
+}
+
+func countLevels(picks [][]*Backend) map[int]int {
+	counts := make(map[int]int)
+	for _, level := range picks {
+		counts[level[0].Priority]++
+	}
+	return counts
+}
+
+func TestSelectPriority_StrictFailover(t *testing.T) {
+	backends := newPriorityBackends(3, 1)
+
+	// Two of three primaries left: strict mode never touches the backup
+	got := SelectPriority(backends, backends[1:], 0)
+	for _, b := range got {
+		if b.Priority != 0 {
+			t.Fatalf("Expected only primaries, got priority %d", b.Priority)
+		}
+	}
+	if len(got) != 2 {
+		t.Errorf("Expected 2 primaries, got %d", len(got))
+	}
+
+	// All primaries gone: the backup takes over
+	got = SelectPriority(backends, backends[3:], 0)
+	if len(got) != 1 || got[0].Priority != 1 {
+		t.Errorf("Expected failover to backup, got %v", got)
+	}
+}
+
+func TestSelectPriority_Overprovisioning(t *testing.T) {
+	backends := newPriorityBackends(4, 2)
+
+	// 3/4 primaries healthy with factor 1.4 -> health 1.05, no spillover
+	picks := make([][]*Backend, 0, 1000)
+	candidates := append([]*Backend{}, backends[1:]...)
+	for i := 0; i < 1000; i++ {
+		picks = append(picks, SelectPriority(backends, candidates, 1.4))
+	}
+	if counts := countLevels(picks); counts[1] != 0 {
+		t.Errorf("Expected no spillover at 75%% primary health, got %d", counts[1])
+	}
+
+	// 1/4 primaries healthy -> primary health 0.35, rest spills to backups
+	picks = picks[:0]
+	candidates = append([]*Backend{backends[0]}, backends[4:]...)
+	for i := 0; i < 1000; i++ {
+		picks = append(picks, SelectPriority(backends, candidates, 1.4))
+	}
+	counts := countLevels(picks)
+	if counts[0] < 250 || counts[0] > 450 {
+		t.Errorf("Expected ~35%% of traffic on primaries, got %d/1000", counts[0])
+	}
+}
+
+func TestSelectPriority_SingleLevelAndEmpty(t *testing.T) {
+	backends := newPriorityBackends(3, 0)
+	if got := SelectPriority(backends, backends, 1.4); len(got) != 3 {
+		t.Errorf("Expected all candidates for a single level, got %d", len(got))
+	}
+	if got := SelectPriority(backends, nil, 1.4); len(got) != 0 {
+		t.Errorf("Expected no candidates, got %d", len(got))
+	}
+}
@@ -0,0 +1,48 @@
+package balancer
+
+import "sync"
+
+// DefaultRetryBudget is the share of requests that may be retried when the
+// pool doesn't set one
+const DefaultRetryBudget = 0.2
+
+// retryBudgetBurst is how many retries can be spent before any requests have
+// been seen, so a quiet pool can still retry its first failures
+const retryBudgetBurst = 10
+
+// RetryBudget caps retries at a fraction of requests so a failing backend
+// can't turn every request into a retry storm. Each request deposits ratio
+// tokens and each retry withdraws a whole one; the balance is capped so
+// credit from quiet periods can't be saved up.
+type RetryBudget struct {
+	mu      sync.Mutex
+	ratio   float64
+	balance float64
+}
+
+// NewRetryBudget creates a budget allowing retries for ratio of requests
+func NewRetryBudget(ratio float64) *RetryBudget {
+	return &RetryBudget{ratio: ratio, balance: retryBudgetBurst}
+}
+
+// Deposit records a request, earning credit for retries
+func (b *RetryBudget) Deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.balance += b.ratio
+	if b.balance > retryBudgetBurst {
+		b.balance = retryBudgetBurst
+	}
+}
+
+// Withdraw spends credit for one retry, reporting false if the budget is
+// exhausted
+func (b *RetryBudget) Withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.balance < 1 {
+		return false
+	}
+	b.balance--
+	return true
+}
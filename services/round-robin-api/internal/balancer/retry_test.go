@@ -0,0 +1,48 @@
+package balancer
+
+import "testing"
+
+func TestRetryBudget_Burst(t *testing.T) {
+	budget := NewRetryBudget(0.2)
+	for i := 0; i < retryBudgetBurst; i++ {
+		if !budget.Withdraw() {
+			t.Fatalf("Expected burst retry %d to be allowed", i)
+		}
+	}
+	if budget.Withdraw() {
+		t.Error("Expected budget to be exhausted after the burst")
+	}
+}
+
+func TestRetryBudget_Ratio(t *testing.T) {
+	budget := NewRetryBudget(0.2)
+	for budget.Withdraw() {
+	}
+
+	// Every request fails and wants a retry: only a fifth may get one
+	retries := 0
+	for i := 0; i < 1000; i++ {
+		budget.Deposit()
+		if budget.Withdraw() {
+			retries++
+		}
+	}
+	if retries < 195 || retries > 200 {
+		t.Errorf("Expected about 200 retries for 1000 requests, got %d", retries)
+	}
+}
+
+func TestRetryBudget_Capped(t *testing.T) {
+	budget := NewRetryBudget(0.5)
+	for i := 0; i < 1000; i++ {
+		budget.Deposit()
+	}
+
+	retries := 0
+	for budget.Withdraw() {
+		retries++
+	}
+	if retries != retryBudgetBurst {
+		t.Errorf("Expected idle credit capped at %d, got %d", retryBudgetBurst, retries)
+	}
+}
@@ -0,0 +1,107 @@
+package balancer
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Session ties a UDP client to the backend serving it and the socket its
+// datagrams are relayed through
+type Session struct {
+	Client  net.Addr
+	Backend *Backend
+	Conn    net.Conn
+	// lastActive is when a datagram last went either way, in Unix nanoseconds
+	lastActive atomic.Int64
+}
+
+// Touch marks the session as active now
+func (s *Session) Touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+// idleSince reports whether the session has been quiet since before cutoff
+func (s *Session) idleSince(cutoff time.Time) bool {
+	return s.lastActive.Load() < cutoff.UnixNano()
+}
+
+// SessionTable maps client addresses to their sessions, so every datagram
+// from a client goes to the same backend, and forgets clients that go
+// quiet for longer than the idle timeout
+type SessionTable struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+	idle     time.Duration
+}
+
+// NewSessionTable creates an empty table expiring sessions after idle
+func NewSessionTable(idle time.Duration) *SessionTable {
+	return &SessionTable{sessions: make(map[string]*Session), idle: idle}
+}
+
+// Get returns the session of the client with the given address, or nil
+func (t *SessionTable) Get(client string) *Session {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sessions[client]
+}
+
+// Add stores a session for its client and marks it active
+func (t *SessionTable) Add(s *Session) {
+	s.Touch()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sessions[s.Client.String()] = s
+}
+
+// Remove removes a session if it is still in the table, and reports
+// whether it was
+func (t *SessionTable) Remove(s *Session) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	client := s.Client.String()
+	if t.sessions[client] != s {
+		return false
+	}
+	delete(t.sessions, client)
+	return true
+}
+
+// Expire removes the sessions that have been idle for longer than the idle
+// timeout at now and returns them, so the caller can close them
+func (t *SessionTable) Expire(now time.Time) []*Session {
+	cutoff := now.Add(-t.idle)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var expired []*Session
+	for client, s := range t.sessions {
+		if s.idleSince(cutoff) {
+			delete(t.sessions, client)
+			expired = append(expired, s)
+		}
+	}
+	return expired
+}
+
+// Clear removes every session and returns them
+func (t *SessionTable) Clear() []*Session {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sessions := make([]*Session, 0, len(t.sessions))
+	for _, s := range t.sessions {
+		sessions = append(sessions, s)
+	}
+	t.sessions = make(map[string]*Session)
+	return sessions
+}
+
+// Len returns the number of sessions in the table
+func (t *SessionTable) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.sessions)
+}
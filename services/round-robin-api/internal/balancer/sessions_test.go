@@ -0,0 +1,42 @@
+package balancer
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSessionTable_Expire(t *testing.T) {
+	table := NewSessionTable(time.Minute)
+	backend := NewBackend(BackendConfig{URL: "udp://dns-1:53"})
+	quiet := &Session{Client: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5353}, Backend: backend}
+	busy := &Session{Client: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5353}, Backend: backend}
+	table.Add(quiet)
+	table.Add(busy)
+
+	if got := table.Get("10.0.0.1:5353"); got != quiet {
+		t.Errorf("Expected the client's session, got %v", got)
+	}
+	if expired := table.Expire(time.Now()); len(expired) != 0 {
+		t.Errorf("Expected no session to expire yet, got %d", len(expired))
+	}
+
+	// Only the busy client keeps sending
+	quiet.lastActive.Store(time.Now().Add(-2 * time.Minute).UnixNano())
+	busy.Touch()
+	expired := table.Expire(time.Now())
+	if len(expired) != 1 || expired[0] != quiet {
+		t.Fatalf("Expected the quiet session to expire, got %v", expired)
+	}
+	if table.Len() != 1 || table.Get("10.0.0.1:5353") != nil {
+		t.Errorf("Expected only the busy session to be left, got %d", table.Len())
+	}
+
+	if table.Remove(quiet) || !table.Remove(busy) {
+		t.Error("Expected only sessions still in the table to be removed")
+	}
+	table.Add(busy)
+	if cleared := table.Clear(); len(cleared) != 1 || table.Len() != 0 {
+		t.Errorf("Expected Clear to empty the table, got %d cleared, %d left", len(cleared), table.Len())
+	}
+}
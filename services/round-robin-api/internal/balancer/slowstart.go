@@ -0,0 +1,80 @@
+package balancer
+
+import (
+	"math"
+	"time"
+)
+
+// DefaultSlowStartMinWeight is the share of its weight a backend gets at the
+// very start of its slow-start window when none is configured
+const DefaultSlowStartMinWeight = 0.1
+
+// SlowStart controls how a newly added or recovered backend ramps up to its
+// full share of traffic. A zero Window disables slow start.
+type SlowStart struct {
+	Window time.Duration
+	// MinWeight is the fraction of full weight the backend starts at
+	MinWeight float64
+	// Aggression shapes the curve: 1 is linear, higher values ramp up
+	// faster early in the window, lower values hold back longer
+	Aggression float64
+}
+
+// Enabled reports whether slow start is configured
+func (s SlowStart) Enabled() bool {
+	return s.Window > 0
+}
+
+// factor returns the fraction of full weight after elapsed time in the window
+func (s SlowStart) factor(elapsed time.Duration) float64 {
+	if !s.Enabled() || elapsed >= s.Window {
+		return 1
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	minWeight := s.MinWeight
+	if minWeight <= 0 {
+		minWeight = DefaultSlowStartMinWeight
+	}
+	aggression := s.Aggression
+	if aggression <= 0 {
+		aggression = 1
+	}
+
+	f := math.Pow(float64(elapsed)/float64(s.Window), 1/aggression)
+	return math.Max(minWeight, f)
+}
+
+// BeginSlowStart starts ramping the backend up from the configured minimum
+func (b *Backend) BeginSlowStart(s SlowStart) {
+	if !s.Enabled() {
+		return
+	}
+	b.rampMu.Lock()
+	defer b.rampMu.Unlock()
+	b.ramp = s
+	b.rampStart = time.Now()
+}
+
+// SlowStartFactor returns the fraction of its weight the backend should
+// currently receive: 1 once it is out of slow start
+func (b *Backend) SlowStartFactor() float64 {
+	b.rampMu.Lock()
+	defer b.rampMu.Unlock()
+	if b.rampStart.IsZero() {
+		return 1
+	}
+	f := b.ramp.factor(time.Since(b.rampStart))
+	if f >= 1 {
+		// Window is over, skip the maths from now on
+		b.rampStart = time.Time{}
+	}
+	return f
+}
+
+// EffectiveWeight is the backend's weight scaled by its slow-start factor
+func (b *Backend) EffectiveWeight() float64 {
+	return float64(b.Weight) * b.SlowStartFactor()
+}
@@ -0,0 +1,82 @@
+package balancer
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSlowStart_Factor(t *testing.T) {
+	linear := SlowStart{Window: 10 * time.Second, MinWeight: 0.1}
+	tests := []struct {
+		elapsed  time.Duration
+		expected float64
+	}{
+		{0, 0.1},
+		{500 * time.Millisecond, 0.1},
+		{5 * time.Second, 0.5},
+		{10 * time.Second, 1},
+		{time.Minute, 1},
+	}
+	for _, tt := range tests {
+		if got := linear.factor(tt.elapsed); math.Abs(got-tt.expected) > 1e-9 {
+			t.Errorf("factor(%v) = %f, expected %f", tt.elapsed, got, tt.expected)
+		}
+	}
+
+	aggressive := SlowStart{Window: 10 * time.Second, Aggression: 2}
+	if got := aggressive.factor(2500 * time.Millisecond); math.Abs(got-0.5) > 1e-9 {
+		t.Errorf("Expected aggressive curve to reach 0.5 at a quarter window, got %f", got)
+	}
+
+	if got := (SlowStart{}).factor(0); got != 1 {
+		t.Errorf("Disabled slow start should give full weight, got %f", got)
+	}
+}
+
+func TestBackend_EffectiveWeight(t *testing.T) {
+	b := NewBackend(BackendConfig{URL: "a", Weight: 4})
+	if got := b.EffectiveWeight(); got != 4 {
+		t.Errorf("Expected full weight outside slow start, got %f", got)
+	}
+
+	b.BeginSlowStart(SlowStart{Window: time.Hour, MinWeight: 0.25})
+	if got := b.EffectiveWeight(); got < 1 || got > 1.01 {
+		t.Errorf("Expected ~1 at the start of slow start, got %f", got)
+	}
+
+	b.BeginSlowStart(SlowStart{})
+	if got := b.EffectiveWeight(); got > 1.01 {
+		t.Errorf("Disabled config should not reset an ongoing ramp, got %f", got)
+	}
+}
+
+func TestWeightedRoundRobin_SlowStartBackendGetsLess(t *testing.T) {
+	w := &WeightedRoundRobin{}
+	warm := NewBackend(BackendConfig{URL: "warm"})
+	cold := NewBackend(BackendConfig{URL: "cold"})
+	cold.BeginSlowStart(SlowStart{Window: time.Hour, MinWeight: 0.1})
+
+	counts := make(map[*Backend]int)
+	for i := 0; i < 110; i++ {
+		counts[w.Next([]*Backend{warm, cold}, nil)]++
+	}
+	if counts[cold] < 8 || counts[cold] > 12 {
+		t.Errorf("Expected ~10 picks for the cold backend, got %d", counts[cold])
+	}
+}
+
+func TestRoundRobin_SlowStartBackendGetsLess(t *testing.T) {
+	rr := &RoundRobin{}
+	warm := NewBackend(BackendConfig{URL: "warm"})
+	cold := NewBackend(BackendConfig{URL: "cold"})
+	cold.BeginSlowStart(SlowStart{Window: time.Hour, MinWeight: 0.1})
+
+	counts := make(map[*Backend]int)
+	for i := 0; i < 1000; i++ {
+		counts[rr.Next([]*Backend{warm, cold}, nil)]++
+	}
+	if counts[cold] > 200 {
+		t.Errorf("Expected the cold backend to get a small share, got %d/1000", counts[cold])
+	}
+}
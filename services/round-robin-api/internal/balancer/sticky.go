@@ -0,0 +1,89 @@
+package balancer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultStickyCookie is the cookie name used when none is configured
+const DefaultStickyCookie = "lb_backend"
+
+// StickySessions pins clients to a backend with an HMAC-signed cookie, so a
+// client can't steer itself onto an arbitrary backend by editing the cookie
+type StickySessions struct {
+	cookieName string
+	secret     []byte
+}
+
+// NewStickySessions creates a cookie signer; secret must be shared by every
+// load balancer instance that should honour the same cookies
+func NewStickySessions(cookieName string, secret []byte) *StickySessions {
+	if cookieName == "" {
+		cookieName = DefaultStickyCookie
+	}
+	return &StickySessions{
+		cookieName: cookieName,
+		secret:     secret,
+	}
+}
+
+// cookie returns the name of the cookie pinning clients in a pool. Each
+// pool has its own, so a client using routes to several pools stays pinned
+// in all of them; the default pool's is the configured name itself.
+func (s *StickySessions) cookie(pool string) string {
+	if pool == DefaultPool {
+		return s.cookieName
+	}
+	// Escaped pool names only use characters allowed in cookie names
+	return s.cookieName + "." + url.QueryEscape(pool)
+}
+
+// Backend returns the backend URL the request's cookie pins it to in the
+// pool, if the cookie is present and its signature is valid
+func (s *StickySessions) Backend(r *http.Request, pool string) (string, bool) {
+	c, err := r.Cookie(s.cookie(pool))
+	if err != nil {
+		return "", false
+	}
+
+	encoded, sig, found := strings.Cut(c.Value, ".")
+	if !found {
+		return "", false
+	}
+	backendURL, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, s.sign(pool, backendURL)) {
+		return "", false
+	}
+	return string(backendURL), true
+}
+
+// SetCookie pins the client to the given backend of the pool
+func (s *StickySessions) SetCookie(w http.ResponseWriter, pool, backendURL string) {
+	value := base64.RawURLEncoding.EncodeToString([]byte(backendURL)) + "." +
+		base64.RawURLEncoding.EncodeToString(s.sign(pool, []byte(backendURL)))
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.cookie(pool),
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// sign covers the pool as well as the backend, so a cookie can't be
+// replayed under another pool's name
+func (s *StickySessions) sign(pool string, data []byte) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(pool))
+	mac.Write([]byte{0})
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,84 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func stickyRequest(w *httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestStickySessions_RoundTrip(t *testing.T) {
+	s := NewStickySessions("", []byte("secret"))
+	w := httptest.NewRecorder()
+	s.SetCookie(w, DefaultPool, "http://echo-go:8081")
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != DefaultStickyCookie {
+		t.Fatalf("Expected a %s cookie, got %v", DefaultStickyCookie, cookies)
+	}
+
+	url, ok := s.Backend(stickyRequest(w), DefaultPool)
+	if !ok || url != "http://echo-go:8081" {
+		t.Errorf("Expected pinned backend http://echo-go:8081, got %q (ok=%v)", url, ok)
+	}
+}
+
+func TestStickySessions_RejectsTampering(t *testing.T) {
+	s := NewStickySessions("", []byte("secret"))
+	w := httptest.NewRecorder()
+	s.SetCookie(w, DefaultPool, "http://echo-go:8081")
+
+	// Cookie signed with a different secret
+	other := NewStickySessions("", []byte("other-secret"))
+	if _, ok := other.Backend(stickyRequest(w), DefaultPool); ok {
+		t.Error("Cookie signed with another secret should be rejected")
+	}
+
+	tampered := []string{"", "garbage", "aHR0cDovL2V2aWw.AAAA", "!!!.!!!"}
+	for _, value := range tampered {
+		r := httptest.NewRequest(http.MethodPost, "/api", nil)
+		r.AddCookie(&http.Cookie{Name: DefaultStickyCookie, Value: value})
+		if _, ok := s.Backend(r, DefaultPool); ok {
+			t.Errorf("Tampered cookie %q should be rejected", value)
+		}
+	}
+
+	if _, ok := s.Backend(httptest.NewRequest(http.MethodPost, "/api", nil), DefaultPool); ok {
+		t.Error("Request without cookie should not be pinned")
+	}
+}
+
+func TestStickySessions_PerPool(t *testing.T) {
+	s := NewStickySessions("", []byte("secret"))
+	w := httptest.NewRecorder()
+	s.SetCookie(w, DefaultPool, "http://echo-go:8081")
+	s.SetCookie(w, "search api", "http://search-1:8080")
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 2 || cookies[1].Name != DefaultStickyCookie+".search+api" {
+		t.Fatalf("Expected a cookie per pool, got %v", cookies)
+	}
+
+	// Pinning a client in one pool leaves its pin in the other alone
+	r := stickyRequest(w)
+	if url, ok := s.Backend(r, DefaultPool); !ok || url != "http://echo-go:8081" {
+		t.Errorf("Expected http://echo-go:8081 in the default pool, got %q (ok=%v)", url, ok)
+	}
+	if url, ok := s.Backend(r, "search api"); !ok || url != "http://search-1:8080" {
+		t.Errorf("Expected http://search-1:8080 in the search pool, got %q (ok=%v)", url, ok)
+	}
+
+	// A cookie copied under another pool's name doesn't verify
+	copied := httptest.NewRequest(http.MethodGet, "/", nil)
+	copied.AddCookie(&http.Cookie{Name: DefaultStickyCookie + ".users", Value: cookies[1].Value})
+	if _, ok := s.Backend(copied, "users"); ok {
+		t.Error("Expected a cookie signed for another pool to be rejected")
+	}
+}
@@ -0,0 +1,100 @@
+package balancer
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultStrategy is used when no strategy is configured
+const DefaultStrategy = "round-robin"
+
+// Strategy decides which backend serves a request.
+//
+// Next is called with the backends that are currently healthy and whose
+// circuit is available; it must return one of them, or nil if it can't pick.
+// The request may be nil when the caller has no HTTP context. OnRequestStart
+// and OnRequestFinish bracket every forwarded request so stateful strategies
+// can track load and latency.
+type Strategy interface {
+	Name() string
+	Next(candidates []*Backend, r *http.Request) *Backend
+	OnRequestStart(b *Backend)
+	OnRequestFinish(b *Backend, duration time.Duration, success bool)
+}
+
+// BackendObserver is implemented by strategies that need to see the full
+// backend set rather than just the current candidates, e.g. to build a hash
+// ring. SetBackends is called whenever backends are added or removed.
+type BackendObserver interface {
+	SetBackends(backends []*Backend)
+}
+
+// Options carries settings used by some strategies
+type Options struct {
+	// HashKey selects what consistent-hash routes on: "ip", "header:<name>",
+	// "cookie:<name>" or "json:<field>"
+	HashKey string
+}
+
+var strategies = map[string]func(opts Options) (Strategy, error){
+	"round-robin":          func(Options) (Strategy, error) { return &RoundRobin{}, nil },
+	"weighted-round-robin": func(Options) (Strategy, error) { return &WeightedRoundRobin{}, nil },
+	"least-outstanding":    func(Options) (Strategy, error) { return &LeastOutstanding{}, nil },
+	"p2c-ewma":             func(Options) (Strategy, error) { return &PowerOfTwoChoices{}, nil },
+	"consistent-hash":      func(opts Options) (Strategy, error) { return NewConsistentHash(opts.HashKey) },
+}
+
+// New returns a fresh instance of the named strategy
+func New(name string, opts Options) (Strategy, error) {
+	if name == "" {
+		name = DefaultStrategy
+	}
+	factory, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy: %s", name)
+	}
+	return factory(opts)
+}
+
+// Names returns the names of all available strategies, sorted
+func Names() []string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RoundRobin cycles through the candidates in order. A backend in slow
+// start is skipped with a probability matching how far it is from full
+// weight, so it receives a growing share of its turns.
+type RoundRobin struct {
+	index uint64
+}
+
+func (rr *RoundRobin) Name() string { return "round-robin" }
+
+func (rr *RoundRobin) Next(candidates []*Backend, r *http.Request) *Backend {
+	if len(candidates) == 0 {
+		return nil
+	}
+	var backend *Backend
+	for i := 0; i < len(candidates); i++ {
+		idx := atomic.AddUint64(&rr.index, 1) % uint64(len(candidates))
+		backend = candidates[idx]
+		if f := backend.SlowStartFactor(); f >= 1 || rand.Float64() < f {
+			return backend
+		}
+	}
+	// Every candidate is warming up and lost the draw; use the last one
+	return backend
+}
+
+func (rr *RoundRobin) OnRequestStart(b *Backend) {}
+
+func (rr *RoundRobin) OnRequestFinish(b *Backend, duration time.Duration, success bool) {}
@@ -0,0 +1,56 @@
+package balancer
+
+import (
+	"testing"
+)
+
+func TestNew_UnknownStrategy(t *testing.T) {
+	if _, err := New("does-not-exist", Options{}); err == nil {
+		t.Error("Expected error for unknown strategy")
+	}
+}
+
+func TestNew_DefaultStrategy(t *testing.T) {
+	s, err := New("", Options{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.Name() != DefaultStrategy {
+		t.Errorf("Expected %s, got %s", DefaultStrategy, s.Name())
+	}
+}
+
+func TestNames_ContainsAllStrategies(t *testing.T) {
+	names := Names()
+	if len(names) != len(strategies) {
+		t.Fatalf("Expected %d names, got %d", len(strategies), len(names))
+	}
+	for _, name := range names {
+		s, err := New(name, Options{})
+		if err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		if s.Name() != name {
+			t.Errorf("Strategy registered as %s reports name %s", name, s.Name())
+		}
+	}
+}
+
+func TestRoundRobin_Next(t *testing.T) {
+	rr := &RoundRobin{}
+
+	if rr.Next(nil, nil) != nil {
+		t.Error("Expected nil with no candidates")
+	}
+
+	backends := []*Backend{NewBackend(BackendConfig{URL: "a"}), NewBackend(BackendConfig{URL: "b"}), NewBackend(BackendConfig{URL: "c"})}
+	counts := make(map[string]int)
+	for i := 0; i < 30; i++ {
+		counts[rr.Next(backends, nil).URL]++
+	}
+	for _, b := range backends {
+		if counts[b.URL] != 10 {
+			t.Errorf("Expected 10 picks for %s, got %d", b.URL, counts[b.URL])
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package balancer
+
+import "time"
+
+// Timeouts bound the stages of a request to a backend. Zero fields fall back
+// to DefaultTimeouts.
+type Timeouts struct {
+	// Connect bounds establishing the TCP connection
+	Connect time.Duration
+	// TLSHandshake bounds the handshake with https backends
+	TLSHandshake time.Duration
+	// ResponseHeader bounds the wait for response headers once the request
+	// has been written
+	ResponseHeader time.Duration
+	// Total bounds the whole exchange including retries, hedges and reading
+	// the response body; client-supplied deadlines are capped at it
+	Total time.Duration
+	// Idle is how long an unused keep-alive connection to a backend is kept
+	Idle time.Duration
+	// StreamIdle replaces Total once a response turns out to be a stream:
+	// the stream is cut off when no data has arrived for this long
+	StreamIdle time.Duration
+	// HealthCheck bounds a single health probe
+	HealthCheck time.Duration
+	// SessionIdle is how long a UDP client may go without sending or
+	// receiving a datagram before its session, and so its backend, is
+	// forgotten
+	SessionIdle time.Duration
+}
+
+// TimeoutStatus is Timeouts rendered for the admin API
+type TimeoutStatus struct {
+	Connect        string `json:"connect"`
+	TLSHandshake   string `json:"tls_handshake"`
+	ResponseHeader string `json:"response_header"`
+	Total          string `json:"total"`
+	Idle           string `json:"idle"`
+	StreamIdle     string `json:"stream_idle"`
+	HealthCheck    string `json:"health_check"`
+	SessionIdle    string `json:"session_idle"`
+}
+
+// DefaultTimeouts returns the timeouts used when nothing is configured
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		Connect:        2 * time.Second,
+		TLSHandshake:   2 * time.Second,
+		ResponseHeader: 2 * time.Second,
+		Total:          2 * time.Second,
+		Idle:           90 * time.Second,
+		StreamIdle:     60 * time.Second,
+		HealthCheck:    2 * time.Second,
+		SessionIdle:    30 * time.Second,
+	}
+}
+
+// WithDefaults fills unset timeouts from DefaultTimeouts
+func (t Timeouts) WithDefaults() Timeouts {
+	defaults := DefaultTimeouts()
+	for _, f := range []struct{ value, fallback *time.Duration }{
+		{&t.Connect, &defaults.Connect},
+		{&t.TLSHandshake, &defaults.TLSHandshake},
+		{&t.ResponseHeader, &defaults.ResponseHeader},
+		{&t.Total, &defaults.Total},
+		{&t.Idle, &defaults.Idle},
+		{&t.StreamIdle, &defaults.StreamIdle},
+		{&t.HealthCheck, &defaults.HealthCheck},
+		{&t.SessionIdle, &defaults.SessionIdle},
+	} {
+		if *f.value <= 0 {
+			*f.value = *f.fallback
+		}
+	}
+	return t
+}
+
+// Status renders the timeouts for the admin API
+func (t Timeouts) Status() TimeoutStatus {
+	return TimeoutStatus{
+		Connect:        t.Connect.String(),
+		TLSHandshake:   t.TLSHandshake.String(),
+		ResponseHeader: t.ResponseHeader.String(),
+		Total:          t.Total.String(),
+		Idle:           t.Idle.String(),
+		StreamIdle:     t.StreamIdle.String(),
+		HealthCheck:    t.HealthCheck.String(),
+		SessionIdle:    t.SessionIdle.String(),
+	}
+}
@@ -0,0 +1,18 @@
+package balancer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeouts_WithDefaults(t *testing.T) {
+	timeouts := Timeouts{Connect: 100 * time.Millisecond, Total: 5 * time.Second}.WithDefaults()
+	defaults := DefaultTimeouts()
+
+	if timeouts.Connect != 100*time.Millisecond || timeouts.Total != 5*time.Second {
+		t.Errorf("Configured timeouts should be kept, got %+v", timeouts)
+	}
+	if timeouts.ResponseHeader != defaults.ResponseHeader || timeouts.Idle != defaults.Idle || timeouts.StreamIdle != defaults.StreamIdle || timeouts.HealthCheck != defaults.HealthCheck || timeouts.SessionIdle != defaults.SessionIdle {
+		t.Errorf("Unset timeouts should use the defaults, got %+v", timeouts)
+	}
+}
@@ -0,0 +1,128 @@
+package balancer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"round-robin-api/internal/proxyproto"
+)
+
+// Protocols a pool can speak to its backends
+const (
+	ProtocolHTTP1 = "http1"
+	// ProtocolHTTP2 negotiates HTTP/2 with https backends, falling back to
+	// HTTP/1.1 for backends that don't offer it
+	ProtocolHTTP2 = "http2"
+	// ProtocolH2C speaks cleartext HTTP/2 to http backends with prior
+	// knowledge
+	ProtocolH2C = "h2c"
+	// ProtocolTCP relays raw TCP connections to tcp://host:port backends;
+	// such a pool serves TCP listeners rather than HTTP routes
+	ProtocolTCP = "tcp"
+	// ProtocolUDP relays datagrams to udp://host:port backends; such a pool
+	// serves UDP listeners rather than HTTP routes
+	ProtocolUDP = "udp"
+)
+
+// ValidateProtocol checks an upstream protocol name; empty means HTTP/1.1
+func ValidateProtocol(name string) error {
+	switch name {
+	case "", ProtocolHTTP1, ProtocolHTTP2, ProtocolH2C, ProtocolTCP, ProtocolUDP:
+		return nil
+	}
+	return fmt.Errorf("unknown protocol %q: must be %s, %s, %s, %s or %s", name, ProtocolHTTP1, ProtocolHTTP2, ProtocolH2C, ProtocolTCP, ProtocolUDP)
+}
+
+// Layer4 reports whether pools with the protocol relay connections or
+// datagrams rather than serve HTTP routes
+func Layer4(protocol string) bool {
+	return protocol == ProtocolTCP || protocol == ProtocolUDP
+}
+
+// BackendAddress returns the host:port of a backend in a layer 4 pool,
+// whose URL is tcp://host:port or udp://host:port after the protocol
+func BackendAddress(protocol, backendURL string) (string, error) {
+	u, err := url.Parse(backendURL)
+	if err != nil || u.Scheme != protocol || u.Hostname() == "" || u.Port() == "" {
+		return "", fmt.Errorf("invalid %s backend %q: must be %s://host:port", protocol, backendURL, protocol)
+	}
+	return u.Host, nil
+}
+
+// ValidateBackend checks that a pool with the given protocol can reach a
+// backend URL: layer 4 pools need backends of their own scheme, and HTTP
+// pools can't reach tcp:// or udp:// backends
+func ValidateBackend(protocol, backendURL string) error {
+	if Layer4(protocol) {
+		_, err := BackendAddress(protocol, backendURL)
+		return err
+	}
+	if IsUnixBackend(backendURL) {
+		_, _, err := ParseUnixBackend(backendURL)
+		return err
+	}
+	if u, err := url.Parse(backendURL); err == nil && Layer4(u.Scheme) {
+		return fmt.Errorf("%s backend %q needs a pool with protocol %s", u.Scheme, backendURL, u.Scheme)
+	}
+	return nil
+}
+
+// validateProxyProtocol checks that a pool can send PROXY protocol headers.
+// A header names one client for the whole connection, which HTTP/2 shares
+// between clients and UDP doesn't have.
+func validateProxyProtocol(version, protocol string) error {
+	if err := proxyproto.ValidateVersion(version); err != nil {
+		return err
+	}
+	if version != "" && protocol != ProtocolHTTP1 && protocol != ProtocolTCP {
+		return fmt.Errorf("PROXY protocol headers can only be sent to %s and %s backends", ProtocolHTTP1, ProtocolTCP)
+	}
+	return nil
+}
+
+// newTransport builds the connection pool used to reach a pool's backends.
+// Under HTTP/2 requests to a backend share one multiplexed connection.
+// tlsConfig is used for https backends; nil means Go's defaults. With a
+// PROXY protocol version, every connection starts with a header naming the
+// client, so connections aren't reused for other requests. Addresses made up
+// by RequestURL are dialed as the Unix sockets they stand for.
+func newTransport(t Timeouts, protocol string, tlsConfig *tls.Config, proxyProtocol string) *http.Transport {
+	protocols := new(http.Protocols)
+	switch protocol {
+	case ProtocolHTTP2:
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	case ProtocolH2C:
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		protocols.SetHTTP1(true)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   t.Connect,
+		KeepAlive: 30 * time.Second,
+	}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if socket, ok := unixSocket(addr); ok {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	return &http.Transport{
+		DialContext:           proxyproto.Dialer(dial, proxyProtocol),
+		DisableKeepAlives:     proxyProtocol != "",
+		TLSHandshakeTimeout:   t.TLSHandshake,
+		ResponseHeaderTimeout: t.ResponseHeader,
+		IdleConnTimeout:       t.Idle,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		Protocols:             protocols,
+		TLSClientConfig:       tlsConfig,
+	}
+}
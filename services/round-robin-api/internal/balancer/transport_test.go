@@ -0,0 +1,147 @@
+package balancer
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"round-robin-api/internal/logger"
+	"round-robin-api/internal/proxyproto"
+)
+
+func TestNewTransport(t *testing.T) {
+	transport := newTransport(Timeouts{TLSHandshake: time.Second, ResponseHeader: 3 * time.Second, Idle: time.Minute}, "", nil, "")
+	if transport.TLSHandshakeTimeout != time.Second || transport.ResponseHeaderTimeout != 3*time.Second || transport.IdleConnTimeout != time.Minute {
+		t.Errorf("Transport doesn't reflect the timeouts: %+v", transport)
+	}
+	if !transport.Protocols.HTTP1() || transport.Protocols.HTTP2() || transport.Protocols.UnencryptedHTTP2() {
+		t.Errorf("Expected HTTP/1.1 only by default, got %v", transport.Protocols)
+	}
+}
+
+func TestNewTransport_Protocols(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+	}))
+	backend.Config.Protocols = new(http.Protocols)
+	backend.Config.Protocols.SetHTTP1(true)
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	backend.Start()
+	defer backend.Close()
+
+	tests := map[string]string{
+		"":            "HTTP/1.1",
+		ProtocolHTTP1: "HTTP/1.1",
+		ProtocolH2C:   "HTTP/2.0",
+	}
+	for protocol, want := range tests {
+		client := &http.Client{Transport: newTransport(DefaultTimeouts(), protocol, nil, "")}
+		resp, err := client.Get(backend.URL)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", protocol, err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("X-Proto"); got != want {
+			t.Errorf("%q: expected backend to see %s, got %s", protocol, want, got)
+		}
+	}
+}
+
+func TestNewTransport_ProxyProtocol(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Client", r.RemoteAddr)
+	}))
+	backend.Listener = proxyproto.NewListener(backend.Listener, nil, logger.New(logger.ERROR))
+	backend.Start()
+	defer backend.Close()
+
+	client := &http.Client{Transport: newTransport(DefaultTimeouts(), ProtocolHTTP1, nil, proxyproto.Version2)}
+	for _, addr := range []string{"192.0.2.1:1234", "192.0.2.2:5678"} {
+		header := proxyproto.Header{
+			Source:      net.TCPAddrFromAddrPort(netip.MustParseAddrPort(addr)),
+			Destination: &net.TCPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 80},
+		}
+		req, _ := http.NewRequestWithContext(proxyproto.WithHeader(context.Background(), header), http.MethodGet, backend.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		// Each client needs a connection of its own
+		if got := resp.Header.Get("X-Client"); got != addr {
+			t.Errorf("Expected backend to see client %s, got %s", addr, got)
+		}
+	}
+}
+
+func TestValidateProxyProtocol(t *testing.T) {
+	for _, protocol := range []string{ProtocolHTTP1, ProtocolTCP} {
+		if err := validateProxyProtocol(proxyproto.Version1, protocol); err != nil {
+			t.Errorf("%s: unexpected error: %v", protocol, err)
+		}
+	}
+	for _, protocol := range []string{ProtocolHTTP2, ProtocolH2C, ProtocolUDP} {
+		if err := validateProxyProtocol(proxyproto.Version1, protocol); err == nil {
+			t.Errorf("%s: expected error", protocol)
+		}
+	}
+	if err := validateProxyProtocol("v3", ProtocolTCP); err == nil {
+		t.Error("Expected error for unknown version")
+	}
+}
+
+func TestValidateProtocol(t *testing.T) {
+	for _, name := range []string{"", ProtocolHTTP1, ProtocolHTTP2, ProtocolH2C, ProtocolTCP, ProtocolUDP} {
+		if err := ValidateProtocol(name); err != nil {
+			t.Errorf("%q: unexpected error: %v", name, err)
+		}
+	}
+	if err := ValidateProtocol("spdy"); err == nil {
+		t.Error("Expected error for unknown protocol")
+	}
+}
+
+func TestBackendAddress(t *testing.T) {
+	if addr, err := BackendAddress(ProtocolTCP, "tcp://redis-1:6379"); err != nil || addr != "redis-1:6379" {
+		t.Errorf("Expected redis-1:6379, got %q, %v", addr, err)
+	}
+	if addr, err := BackendAddress(ProtocolUDP, "udp://dns-1:53"); err != nil || addr != "dns-1:53" {
+		t.Errorf("Expected dns-1:53, got %q, %v", addr, err)
+	}
+	for _, backendURL := range []string{"http://redis-1:6379", "udp://redis-1:6379", "tcp://redis-1", "tcp://:6379", "redis-1:6379"} {
+		if _, err := BackendAddress(ProtocolTCP, backendURL); err == nil {
+			t.Errorf("%q: expected error", backendURL)
+		}
+	}
+}
+
+func TestValidateBackend(t *testing.T) {
+	valid := map[string]string{
+		"":            "http://users-1:8080",
+		ProtocolHTTP1: "unix:///run/app.sock",
+		ProtocolH2C:   "https://users-1:8443",
+		ProtocolTCP:   "tcp://redis-1:6379",
+		ProtocolUDP:   "udp://dns-1:53",
+	}
+	for protocol, backendURL := range valid {
+		if err := ValidateBackend(protocol, backendURL); err != nil {
+			t.Errorf("%s pool, %q: unexpected error: %v", protocol, backendURL, err)
+		}
+	}
+	invalid := map[string]string{
+		ProtocolHTTP1: "tcp://redis-1:6379",
+		ProtocolHTTP2: "unix://run/app.sock",
+		ProtocolTCP:   "http://users-1:8080",
+		ProtocolH2C:   "udp://dns-1:53",
+		ProtocolUDP:   "tcp://dns-1:53",
+	}
+	for protocol, backendURL := range invalid {
+		if err := ValidateBackend(protocol, backendURL); err == nil {
+			t.Errorf("%s pool, %q: expected error", protocol, backendURL)
+		}
+	}
+}
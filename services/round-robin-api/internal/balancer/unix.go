@@ -0,0 +1,78 @@
+package balancer
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SchemeUnix marks HTTP backends listening on a Unix domain socket, e.g.
+// unix:///run/app.sock or, to send requests under a path prefix,
+// unix:///run/app.sock:/api
+const SchemeUnix = "unix"
+
+// unixHostSuffix ends the made-up host that stands for a Unix socket in
+// request URLs; the rest of the host is the socket's path in hex
+const unixHostSuffix = ".sock.invalid"
+
+// IsUnixBackend reports whether a backend URL uses the unix scheme
+func IsUnixBackend(backendURL string) bool {
+	return strings.HasPrefix(backendURL, SchemeUnix+":")
+}
+
+// ParseUnixBackend splits a unix backend URL into the socket's path and the
+// request path prefix, which may be empty. Everything up to the first colon
+// in the path names the socket.
+func ParseUnixBackend(backendURL string) (socket, prefix string, err error) {
+	u, err := url.Parse(backendURL)
+	if err == nil && u.Scheme == SchemeUnix && u.Host == "" && u.RawQuery == "" {
+		socket, prefix, _ = strings.Cut(u.Path, ":")
+		if socket != "" && (prefix == "" || strings.HasPrefix(prefix, "/")) {
+			return socket, strings.TrimSuffix(prefix, "/"), nil
+		}
+	}
+	return "", "", fmt.Errorf("invalid unix backend %q: must be unix:///path/to.sock, optionally followed by :/request/path", backendURL)
+}
+
+// RequestURL returns the URL a request for path, e.g. "/users", is sent to
+// on a backend. Unix socket backends get an http URL whose made-up host
+// tells the pool's transport which socket to dial.
+func RequestURL(backendURL, path string) string {
+	if !IsUnixBackend(backendURL) {
+		return strings.TrimSuffix(backendURL, "/") + path
+	}
+	socket, prefix, err := ParseUnixBackend(backendURL)
+	if err != nil {
+		// Pools check their backends' URLs; this request will just fail
+		return backendURL + path
+	}
+	return "http://" + hex.EncodeToString([]byte(socket)) + unixHostSuffix + prefix + path
+}
+
+// unixSocket returns the socket path a dial address made up by RequestURL
+// stands for
+func unixSocket(addr string) (string, bool) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || !strings.HasSuffix(host, unixHostSuffix) {
+		return "", false
+	}
+	socket, err := hex.DecodeString(strings.TrimSuffix(host, unixHostSuffix))
+	return string(socket), err == nil
+}
+
+// unixTransport sends requests to Unix socket backends with Host:
+// localhost, as their URL's host only says which socket to dial
+type unixTransport struct {
+	*http.Transport
+}
+
+func (t unixTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(req.URL.Hostname(), unixHostSuffix) && (req.Host == "" || req.Host == req.URL.Host) {
+		req = req.Clone(req.Context())
+		req.Host = "localhost"
+	}
+	return t.Transport.RoundTrip(req)
+}
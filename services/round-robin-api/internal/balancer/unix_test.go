@@ -0,0 +1,93 @@
+package balancer
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"round-robin-api/internal/logger"
+)
+
+func TestParseUnixBackend(t *testing.T) {
+	valid := map[string][2]string{
+		"unix:///run/app.sock":       {"/run/app.sock", ""},
+		"unix:///run/app.sock:/api":  {"/run/app.sock", "/api"},
+		"unix:///run/app.sock:/api/": {"/run/app.sock", "/api"},
+		"unix:/run/app.sock":         {"/run/app.sock", ""},
+	}
+	for backendURL, want := range valid {
+		socket, prefix, err := ParseUnixBackend(backendURL)
+		if err != nil || socket != want[0] || prefix != want[1] {
+			t.Errorf("%q: expected %q and %q, got %q, %q, %v", backendURL, want[0], want[1], socket, prefix, err)
+		}
+	}
+	for _, backendURL := range []string{"unix://run/app.sock", "unix:///run/app.sock:api", "unix://", "unix:///run/app.sock?x=1", "http://app:80"} {
+		if _, _, err := ParseUnixBackend(backendURL); err == nil {
+			t.Errorf("%q: expected error", backendURL)
+		}
+	}
+}
+
+func TestRequestURL(t *testing.T) {
+	if got := RequestURL("http://users-1:8080/", "/users"); got != "http://users-1:8080/users" {
+		t.Errorf("Expected http backends to be joined with the path, got %q", got)
+	}
+
+	got := RequestURL("unix:///run/app.sock:/api", "/users")
+	want := "http://2f72756e2f6170702e736f636b" + unixHostSuffix + "/api/users"
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if socket, ok := unixSocket("2f72756e2f6170702e736f636b" + unixHostSuffix + ":80"); !ok || socket != "/run/app.sock" {
+		t.Errorf("Expected the socket path back, got %q, %v", socket, ok)
+	}
+	if _, ok := unixSocket("users-1:8080"); ok {
+		t.Error("Expected an ordinary address not to name a socket")
+	}
+}
+
+func TestPool_UnixBackend(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "app.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Host", r.Host)
+		w.Header().Set("X-Path", r.URL.Path)
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	backend.Listener = listener
+	backend.Start()
+	defer backend.Close()
+
+	backendURL := "unix://" + socket + ":/api"
+	pool, err := NewPool("sidecar", []BackendConfig{{URL: backendURL}}, PoolSettings{}, logger.New(logger.ERROR))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer pool.Close()
+
+	resp, err := pool.Client().Get(RequestURL(backendURL, "/users"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("X-Path") != "/api/users" || resp.Header.Get("X-Host") != "localhost" {
+		t.Errorf("Expected /api/users with Host: localhost, got %s with Host: %s", resp.Header.Get("X-Path"), resp.Header.Get("X-Host"))
+	}
+
+	client := &http.Client{Transport: pool.Client().Transport, Timeout: time.Second}
+	for _, check := range []HealthCheckSettings{{Type: HealthCheckHTTP}, {Type: HealthCheckTCP}} {
+		if !check.probe()(client, backendURL) {
+			t.Errorf("Expected the %s health check to pass", check.Type)
+		}
+	}
+
+	if _, err := NewPool("sidecar", []BackendConfig{{URL: "unix://run/app.sock"}}, PoolSettings{}, nil); err == nil {
+		t.Error("Expected error for a unix backend with a host")
+	}
+}
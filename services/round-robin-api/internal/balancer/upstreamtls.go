@@ -0,0 +1,97 @@
+package balancer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSSettings controls how a pool verifies https backends and
+// authenticates to them. The zero value verifies backends against the
+// system roots and presents no client certificate.
+type TLSSettings struct {
+	// CAFile is a PEM bundle of the CAs trusted to sign backend
+	// certificates, instead of the system roots
+	CAFile string
+	// CertFile and KeyFile are the client certificate presented to
+	// backends that require mutual TLS
+	CertFile string
+	KeyFile  string
+	// ServerName is sent as SNI and checked against backend certificates
+	// instead of the host in the backend URL
+	ServerName string
+	// InsecureSkipVerify accepts any backend certificate; for testing only
+	InsecureSkipVerify bool
+}
+
+// Enabled reports whether any upstream TLS setting is configured
+func (t TLSSettings) Enabled() bool {
+	return t != TLSSettings{}
+}
+
+// Validate checks the settings without reading any files
+func (t TLSSettings) Validate() error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("tls cert_file and key_file must be set together")
+	}
+	return nil
+}
+
+// Config builds the client TLS config for the settings, or nil when none
+// are configured
+func (t TLSSettings) Config() (*tls.Config, error) {
+	if !t.Enabled() {
+		return nil, nil
+	}
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		bundle, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls ca_file: %v", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("tls ca_file %s: no certificates found", t.CAFile)
+		}
+		config.RootCAs = roots
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// TLSStatus describes a pool's upstream TLS settings
+type TLSStatus struct {
+	CAFile             string `json:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+// Status returns the settings for reporting, or nil when none are
+// configured; the key file is left out
+func (t TLSSettings) Status() *TLSStatus {
+	if !t.Enabled() {
+		return nil
+	}
+	return &TLSStatus{
+		CAFile:             t.CAFile,
+		CertFile:           t.CertFile,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+}
@@ -0,0 +1,185 @@
+package balancer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeClientCert writes a self-signed client certificate and its key into
+// dir and returns the parsed certificate along with the file names
+func writeClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "load-balancer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return cert, certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTLSBackend starts an https backend answering with the protocol it
+// saw; with clientCA set it requires a client certificate signed by it
+func newTLSBackend(t *testing.T, clientCA *x509.Certificate) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+	}))
+	backend.EnableHTTP2 = true
+	if clientCA != nil {
+		roots := x509.NewCertPool()
+		roots.AddCert(clientCA)
+		backend.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: roots}
+	}
+	backend.StartTLS()
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+// get sends a request to url through a transport built from settings
+func get(t *testing.T, settings TLSSettings, protocol, url string) (*http.Response, error) {
+	t.Helper()
+	config, err := settings.Config()
+	if err != nil {
+		t.Fatalf("Unexpected config error: %v", err)
+	}
+	client := &http.Client{Transport: newTransport(DefaultTimeouts(), protocol, config, "")}
+	resp, err := client.Get(url)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func TestTLSSettings_Verification(t *testing.T) {
+	dir := t.TempDir()
+	backend := newTLSBackend(t, nil)
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", backend.Certificate().Raw)
+
+	tests := []struct {
+		name     string
+		settings TLSSettings
+		wantErr  bool
+	}{
+		{"system roots", TLSSettings{}, true},
+		{"custom CA", TLSSettings{CAFile: caFile}, false},
+		// The test certificate is issued for example.com
+		{"server name override", TLSSettings{CAFile: caFile, ServerName: "example.com"}, false},
+		{"wrong server name", TLSSettings{CAFile: caFile, ServerName: "other.test"}, true},
+		{"skip verify", TLSSettings{InsecureSkipVerify: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := get(t, tt.settings, ProtocolHTTP1, backend.URL)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestTLSSettings_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	clientCert, certFile, keyFile := writeClientCert(t, dir)
+	backend := newTLSBackend(t, clientCert)
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", backend.Certificate().Raw)
+
+	if _, err := get(t, TLSSettings{CAFile: caFile}, ProtocolHTTP1, backend.URL); err == nil {
+		t.Error("Expected the backend to refuse a client without a certificate")
+	}
+
+	settings := TLSSettings{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}
+	resp, err := get(t, settings, ProtocolHTTP2, backend.URL)
+	if err != nil {
+		t.Fatalf("Expected the client certificate to be accepted: %v", err)
+	}
+	// Custom TLS settings must not turn HTTP/2 off
+	if got := resp.Header.Get("X-Proto"); got != "HTTP/2.0" {
+		t.Errorf("Expected HTTP/2, got %s", got)
+	}
+}
+
+func TestTLSSettings_Config(t *testing.T) {
+	if config, err := (TLSSettings{}).Config(); config != nil || err != nil {
+		t.Errorf("Expected no config without settings, got %v, %v", config, err)
+	}
+
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("no certificates here"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, certFile, _ := writeClientCert(t, dir)
+
+	invalid := map[string]TLSSettings{
+		"cert without key": {CertFile: certFile},
+		"missing CA file":  {CAFile: filepath.Join(dir, "missing.pem")},
+		"empty CA file":    {CAFile: empty},
+		"mismatched key":   {CertFile: certFile, KeyFile: empty},
+	}
+	for name, settings := range invalid {
+		if _, err := settings.Config(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestNewPool_TLS(t *testing.T) {
+	settings := PoolSettings{TLS: TLSSettings{CAFile: filepath.Join(t.TempDir(), "missing.pem")}}
+	if _, err := NewPool("secure", nil, settings, nil); err == nil {
+		t.Error("Expected error for an unreadable CA file")
+	}
+
+	settings.TLS = TLSSettings{ServerName: "backend.internal", InsecureSkipVerify: true}
+	pool, err := NewPool("secure", nil, settings, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer pool.Close()
+	status := pool.Status().TLS
+	if status == nil || status.ServerName != "backend.internal" || !status.InsecureSkipVerify {
+		t.Errorf("Expected TLS settings in the pool status, got %+v", status)
+	}
+}
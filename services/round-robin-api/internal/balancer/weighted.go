@@ -0,0 +1,62 @@
+package balancer
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WeightedRoundRobin implements nginx's smooth weighted round-robin: every
+// pick adds each candidate's weight to its running score, chooses the highest
+// score and subtracts the total weight from the winner. With weights 5/1/1
+// this yields a,a,b,a,c,a,a instead of five a's in a row. Backends in slow
+// start take part with their reduced effective weight.
+type WeightedRoundRobin struct {
+	mu      sync.Mutex
+	current map[*Backend]float64
+}
+
+func (w *WeightedRoundRobin) Name() string { return "weighted-round-robin" }
+
+func (w *WeightedRoundRobin) Next(candidates []*Backend, r *http.Request) *Backend {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.current == nil {
+		w.current = make(map[*Backend]float64)
+	}
+
+	total := 0.0
+	var best *Backend
+	for _, b := range candidates {
+		weight := b.EffectiveWeight()
+		w.current[b] += weight
+		total += weight
+		if best == nil || w.current[b] > w.current[best] {
+			best = b
+		}
+	}
+	w.current[best] -= total
+
+	// Drop state for backends that were removed or are no longer eligible
+	if len(w.current) > len(candidates) {
+		eligible := make(map[*Backend]bool, len(candidates))
+		for _, b := range candidates {
+			eligible[b] = true
+		}
+		for b := range w.current {
+			if !eligible[b] {
+				delete(w.current, b)
+			}
+		}
+	}
+	return best
+}
+
+func (w *WeightedRoundRobin) OnRequestStart(b *Backend) {}
+
+func (w *WeightedRoundRobin) OnRequestFinish(b *Backend, duration time.Duration, success bool) {}
@@ -0,0 +1,61 @@
+package balancer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWeightedRoundRobin_SmoothInterleaving(t *testing.T) {
+	w := &WeightedRoundRobin{}
+	backends := []*Backend{
+		NewBackend(BackendConfig{URL: "a", Weight: 5}),
+		NewBackend(BackendConfig{URL: "b", Weight: 1}),
+		NewBackend(BackendConfig{URL: "c", Weight: 1}),
+	}
+
+	picks := make([]string, 0, 7)
+	for i := 0; i < 7; i++ {
+		picks = append(picks, w.Next(backends, nil).URL)
+	}
+
+	if got := strings.Join(picks, ""); got != "aabacaa" {
+		t.Errorf("Expected smooth sequence aabacaa, got %s", got)
+	}
+}
+
+func TestWeightedRoundRobin_Distribution(t *testing.T) {
+	w := &WeightedRoundRobin{}
+	backends := []*Backend{
+		NewBackend(BackendConfig{URL: "a", Weight: 3}),
+		NewBackend(BackendConfig{URL: "b", Weight: 2}),
+		NewBackend(BackendConfig{URL: "c"}),
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 60; i++ {
+		counts[w.Next(backends, nil).URL]++
+	}
+
+	expected := map[string]int{"a": 30, "b": 20, "c": 10}
+	for url, want := range expected {
+		if counts[url] != want {
+			t.Errorf("Expected %d picks for %s, got %d", want, url, counts[url])
+		}
+	}
+}
+
+func TestWeightedRoundRobin_PrunesRemovedBackends(t *testing.T) {
+	w := &WeightedRoundRobin{}
+	a := NewBackend(BackendConfig{URL: "a", Weight: 2})
+	b := NewBackend(BackendConfig{URL: "b"})
+
+	w.Next([]*Backend{a, b}, nil)
+	w.Next([]*Backend{a}, nil)
+
+	if _, ok := w.current[b]; ok {
+		t.Error("State for ineligible backend should be dropped")
+	}
+	if w.Next(nil, nil) != nil {
+		t.Error("Expected nil with no candidates")
+	}
+}
@@ -0,0 +1,246 @@
+package certs
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"round-robin-api/internal/logger"
+)
+
+// Pair names a certificate file and its private key file, both PEM
+type Pair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// ParsePairs parses a comma-separated list of cert.pem:key.pem pairs
+func ParsePairs(spec string) ([]Pair, error) {
+	var pairs []Pair
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		certFile, keyFile, ok := strings.Cut(entry, ":")
+		if !ok || certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("invalid certificate %q: must be cert-file:key-file", entry)
+		}
+		pairs = append(pairs, Pair{CertFile: certFile, KeyFile: keyFile})
+	}
+	return pairs, nil
+}
+
+// fileStamp identifies a version of a file on disk
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stat(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// loaded is a certificate as last read from its files
+type loaded struct {
+	cert      *tls.Certificate
+	certStamp fileStamp
+	keyStamp  fileStamp
+}
+
+// Store holds the server's certificates, picks one for each handshake by
+// SNI and reloads them when their files change
+type Store struct {
+	mu     sync.RWMutex
+	pairs  []Pair
+	certs  []loaded
+	onLoad func(file string, names []string, notAfter time.Time)
+}
+
+// NewStore loads every pair; the first one is served to clients that ask
+// for a name none of the certificates cover
+func NewStore(pairs []Pair) (*Store, error) {
+	if len(pairs) == 0 {
+		return nil, errors.New("no certificates configured")
+	}
+	s := &Store{pairs: pairs, certs: make([]loaded, len(pairs))}
+	for i, pair := range pairs {
+		cert, err := load(pair)
+		if err != nil {
+			return nil, err
+		}
+		s.certs[i] = cert
+	}
+	return s, nil
+}
+
+// load reads a pair, noting the file versions it was read from
+func load(pair Pair) (loaded, error) {
+	certStamp, err := stat(pair.CertFile)
+	if err != nil {
+		return loaded{}, err
+	}
+	keyStamp, err := stat(pair.KeyFile)
+	if err != nil {
+		return loaded{}, err
+	}
+	cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+	if err != nil {
+		return loaded{}, fmt.Errorf("certificate %s: %v", pair.CertFile, err)
+	}
+	return loaded{cert: &cert, certStamp: certStamp, keyStamp: keyStamp}, nil
+}
+
+// OnLoad registers a callback told about every certificate now and again
+// whenever one is reloaded, e.g. to track expiry
+func (s *Store) OnLoad(fn func(file string, names []string, notAfter time.Time)) {
+	s.mu.Lock()
+	s.onLoad = fn
+	s.mu.Unlock()
+
+	for i := range s.pairs {
+		s.notify(i)
+	}
+}
+
+// notify tells the OnLoad callback about the i-th certificate
+func (s *Store) notify(i int) {
+	s.mu.RLock()
+	fn, leaf, file := s.onLoad, s.certs[i].cert.Leaf, s.pairs[i].CertFile
+	s.mu.RUnlock()
+	if fn != nil && leaf != nil {
+		fn(file, leaf.DNSNames, leaf.NotAfter)
+	}
+}
+
+// GetCertificate picks the first certificate that covers the name the
+// client asked for and that it can use, falling back to the first one.
+// It is meant for tls.Config.GetCertificate.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, c := range s.certs {
+		if hello.SupportsCertificate(c.cert) == nil {
+			return c.cert, nil
+		}
+	}
+	return s.certs[0].cert, nil
+}
+
+// Reload rereads the certificates whose files changed since they were last
+// loaded. A certificate that fails to load, e.g. because only one of its
+// files has been replaced yet, keeps being served and is tried again on the
+// next reload. It returns the files it reloaded.
+func (s *Store) Reload() ([]string, error) {
+	var reloaded []string
+	var errs []error
+	for i, pair := range s.pairs {
+		certStamp, err := stat(pair.CertFile)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		keyStamp, err := stat(pair.KeyFile)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.mu.RLock()
+		current := s.certs[i]
+		s.mu.RUnlock()
+		if certStamp == current.certStamp && keyStamp == current.keyStamp {
+			continue
+		}
+
+		cert, err := load(pair)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.mu.Lock()
+		s.certs[i] = cert
+		s.mu.Unlock()
+		s.notify(i)
+		reloaded = append(reloaded, pair.CertFile)
+	}
+	return reloaded, errors.Join(errs...)
+}
+
+// Watch reloads changed certificates every interval until stop is closed
+func (s *Store) Watch(interval time.Duration, stop <-chan struct{}, appLogger *logger.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		reloaded, err := s.Reload()
+		for _, file := range reloaded {
+			appLogger.Info("Reloaded TLS certificate %s", file)
+		}
+		if err != nil {
+			appLogger.Warn("Failed to reload TLS certificates, keeping the current ones: %v", err)
+		}
+	}
+}
+
+// ServerConfig builds a TLS config that serves the store's certificates
+func (s *Store) ServerConfig(minVersion uint16, cipherSuites []uint16) *tls.Config {
+	return &tls.Config{
+		GetCertificate: s.GetCertificate,
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+	}
+}
+
+// ParseVersion parses a TLS version such as "1.2"
+func ParseVersion(name string) (uint16, error) {
+	versions := map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+	version, ok := versions[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q: must be 1.0, 1.1, 1.2 or 1.3", name)
+	}
+	return version, nil
+}
+
+// ParseCipherSuites parses a comma-separated list of cipher suite names
+// such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Only suites Go considers
+// secure are accepted. The list applies up to TLS 1.2; TLS 1.3 suites are
+// not configurable.
+func ParseCipherSuites(spec string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	var ids []uint16
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,232 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePair writes a self-signed certificate for names into dir
+func writePair(t *testing.T, dir, name string, notAfter time.Time, names ...string) Pair {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pair := Pair{CertFile: filepath.Join(dir, name+".pem"), KeyFile: filepath.Join(dir, name+"-key.pem")}
+	if err := os.WriteFile(pair.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pair.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return pair
+}
+
+// hello is a client hello asking for serverName
+func hello(serverName string) *tls.ClientHelloInfo {
+	return &tls.ClientHelloInfo{
+		ServerName:        serverName,
+		SupportedVersions: []uint16{tls.VersionTLS13, tls.VersionTLS12},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedCurves:   []tls.CurveID{tls.CurveP256},
+		CipherSuites:      []uint16{tls.TLS_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	}
+}
+
+func TestParsePairs(t *testing.T) {
+	pairs, err := ParsePairs("a.pem:a-key.pem, b.pem:b-key.pem")
+	if err != nil || len(pairs) != 2 || pairs[1] != (Pair{CertFile: "b.pem", KeyFile: "b-key.pem"}) {
+		t.Errorf("Unexpected pairs: %v, %v", pairs, err)
+	}
+	for _, spec := range []string{"a.pem", "a.pem:", ":a-key.pem"} {
+		if _, err := ParsePairs(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
+
+func TestStore_GetCertificate(t *testing.T) {
+	dir := t.TempDir()
+	expiry := time.Now().Add(24 * time.Hour)
+	store, err := NewStore([]Pair{
+		writePair(t, dir, "default", expiry, "default.example.com"),
+		writePair(t, dir, "api", expiry, "api.example.com"),
+		writePair(t, dir, "wildcard", expiry, "*.internal.example.com"),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := map[string]string{
+		"api.example.com":           "api.example.com",
+		"grpc.internal.example.com": "*.internal.example.com",
+		"unknown.example.com":       "default.example.com",
+		"":                          "default.example.com",
+	}
+	for serverName, want := range tests {
+		cert, err := store.GetCertificate(hello(serverName))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", serverName, err)
+		}
+		if got := cert.Leaf.DNSNames[0]; got != want {
+			t.Errorf("%q: expected certificate for %s, got %s", serverName, want, got)
+		}
+	}
+}
+
+func TestNewStore_Errors(t *testing.T) {
+	if _, err := NewStore(nil); err == nil {
+		t.Error("Expected error without certificates")
+	}
+	dir := t.TempDir()
+	if _, err := NewStore([]Pair{{CertFile: filepath.Join(dir, "missing.pem"), KeyFile: filepath.Join(dir, "missing-key.pem")}}); err == nil {
+		t.Error("Expected error for missing files")
+	}
+
+	a := writePair(t, dir, "a", time.Now().Add(time.Hour), "a.example.com")
+	b := writePair(t, dir, "b", time.Now().Add(time.Hour), "b.example.com")
+	if _, err := NewStore([]Pair{{CertFile: a.CertFile, KeyFile: b.KeyFile}}); err == nil {
+		t.Error("Expected error for a key that doesn't match the certificate")
+	}
+}
+
+func TestStore_Reload(t *testing.T) {
+	dir := t.TempDir()
+	first := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	pair := writePair(t, dir, "site", first, "site.example.com")
+	store, err := NewStore([]Pair{pair})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expiries := make(map[string]time.Time)
+	store.OnLoad(func(file string, names []string, notAfter time.Time) {
+		expiries[file] = notAfter
+	})
+	if !expiries[pair.CertFile].Equal(first) {
+		t.Errorf("Expected OnLoad to report the current expiry, got %v", expiries)
+	}
+
+	// Nothing changed
+	if reloaded, err := store.Reload(); err != nil || len(reloaded) != 0 {
+		t.Errorf("Expected nothing to reload, got %v, %v", reloaded, err)
+	}
+
+	// Renewed certificate
+	second := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	writePair(t, dir, "site", second, "site.example.com")
+	// The rewrite may land within the file system's timestamp granularity
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(pair.CertFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := store.Reload()
+	if err != nil || len(reloaded) != 1 {
+		t.Fatalf("Expected the certificate to be reloaded, got %v, %v", reloaded, err)
+	}
+	cert, _ := store.GetCertificate(hello("site.example.com"))
+	if !cert.Leaf.NotAfter.Equal(second) || !expiries[pair.CertFile].Equal(second) {
+		t.Errorf("Expected the renewed certificate, got expiry %v", cert.Leaf.NotAfter)
+	}
+
+	// A half-written renewal keeps the current certificate
+	if err := os.WriteFile(pair.KeyFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Reload(); err == nil {
+		t.Error("Expected error for a broken key")
+	}
+	cert, _ = store.GetCertificate(hello("site.example.com"))
+	if !cert.Leaf.NotAfter.Equal(second) {
+		t.Errorf("Expected the previous certificate to be kept, got expiry %v", cert.Leaf.NotAfter)
+	}
+}
+
+func TestStore_ServesTLS(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore([]Pair{
+		writePair(t, dir, "a", time.Now().Add(time.Hour), "a.example.com"),
+		writePair(t, dir, "b", time.Now().Add(time.Hour), "b.example.com"),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", store.ServerConfig(tls.VersionTLS12, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: "b.example.com", InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+	defer conn.Close()
+	if got := conn.ConnectionState().PeerCertificates[0].DNSNames[0]; got != "b.example.com" {
+		t.Errorf("Expected the certificate for b.example.com, got %s", got)
+	}
+
+	_, err = tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS11})
+	if err == nil {
+		t.Error("Expected TLS 1.1 to be refused")
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	if v, err := ParseVersion("1.3"); err != nil || v != tls.VersionTLS13 {
+		t.Errorf("Expected TLS 1.3, got %x, %v", v, err)
+	}
+	if _, err := ParseVersion("1.4"); err == nil {
+		t.Error("Expected error for an unknown version")
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	ids, err := ParseCipherSuites("TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384")
+	if err != nil || len(ids) != 2 || ids[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("Unexpected cipher suites: %v, %v", ids, err)
+	}
+	if _, err := ParseCipherSuites("TLS_RSA_WITH_RC4_128_SHA"); err == nil {
+		t.Error("Expected an insecure suite to be refused")
+	}
+	if _, err := ParseCipherSuites("TLS_MADE_UP"); err == nil {
+		t.Error("Expected error for an unknown suite")
+	}
+}
@@ -3,6 +3,8 @@ package circuit
 import (
 	"sync"
 	"time"
+
+	"round-robin-api/internal/upstream"
 )
 
 // State represents the circuit breaker states
@@ -24,12 +26,39 @@ type CircuitBreaker struct {
 	timeout          time.Duration
 }
 
+// Settings configures when a circuit breaker opens and how long it stays open
+type Settings struct {
+	FailureThreshold int
+	OpenTimeout      time.Duration
+}
+
+// DefaultSettings returns the settings used by NewCircuitBreaker
+func DefaultSettings() Settings {
+	return Settings{
+		FailureThreshold: 5,                // After 5 failures, circuit opens
+		OpenTimeout:      time.Second * 10, // Wait 10 seconds before trying again
+	}
+}
+
 // NewCircuitBreaker creates a new circuit breaker with default settings
 func NewCircuitBreaker() *CircuitBreaker {
+	return NewCircuitBreakerWithSettings(DefaultSettings())
+}
+
+// NewCircuitBreakerWithSettings creates a new circuit breaker; zero fields
+// fall back to the defaults
+func NewCircuitBreakerWithSettings(settings Settings) *CircuitBreaker {
+	defaults := DefaultSettings()
+	if settings.FailureThreshold <= 0 {
+		settings.FailureThreshold = defaults.FailureThreshold
+	}
+	if settings.OpenTimeout <= 0 {
+		settings.OpenTimeout = defaults.OpenTimeout
+	}
 	return &CircuitBreaker{
 		state:            CLOSED,
-		failureThreshold: 5,            // After 5 failures, circuit opens
-		timeout:          time.Second * 10, // Wait 10 seconds before trying again
+		failureThreshold: settings.FailureThreshold,
+		timeout:          settings.OpenTimeout,
 	}
 }
 
@@ -82,6 +111,14 @@ func (cb *CircuitBreaker) RecordFailure() {
 	}
 }
 
+// RecordError records a failed request according to its kind. Failures that
+// aren't the backend's fault, such as client cancellations, are ignored.
+func (cb *CircuitBreaker) RecordError(kind upstream.Kind) {
+	if kind.BackendFault() {
+		cb.RecordFailure()
+	}
+}
+
 // GetState returns the current state of the circuit breaker
 func (cb *CircuitBreaker) GetState() State {
 	cb.RLock()
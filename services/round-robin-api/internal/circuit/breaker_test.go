@@ -0,0 +1,60 @@
+package circuit
+
+import (
+	"testing"
+	"time"
+
+	"round-robin-api/internal/upstream"
+)
+
+func TestCircuitBreaker_OpensAfterThreshold(t *testing.T) {
+	cb := NewCircuitBreakerWithSettings(Settings{FailureThreshold: 2, OpenTimeout: time.Hour})
+
+	cb.RecordFailure()
+	if cb.GetState() != CLOSED {
+		t.Fatal("Circuit should stay closed below the threshold")
+	}
+	cb.RecordFailure()
+	if cb.GetState() != OPEN || cb.IsAvailable() {
+		t.Error("Circuit should be open and unavailable after reaching the threshold")
+	}
+}
+
+func TestCircuitBreaker_HalfOpenAfterTimeout(t *testing.T) {
+	cb := NewCircuitBreakerWithSettings(Settings{FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond})
+	cb.RecordFailure()
+
+	time.Sleep(20 * time.Millisecond)
+	if !cb.IsAvailable() || cb.GetState() != HALF_OPEN {
+		t.Fatal("Circuit should be half-open after the open timeout")
+	}
+
+	cb.RecordSuccess()
+	if cb.GetState() != CLOSED {
+		t.Error("Circuit should close after a successful trial request")
+	}
+}
+
+func TestNewCircuitBreakerWithSettings_Defaults(t *testing.T) {
+	cb := NewCircuitBreakerWithSettings(Settings{})
+	defaults := DefaultSettings()
+	if cb.failureThreshold != defaults.FailureThreshold || cb.timeout != defaults.OpenTimeout {
+		t.Errorf("Expected defaults %+v, got threshold %d timeout %v", defaults, cb.failureThreshold, cb.timeout)
+	}
+}
+
+func TestCircuitBreaker_RecordError(t *testing.T) {
+	cb := NewCircuitBreakerWithSettings(Settings{FailureThreshold: 1, OpenTimeout: time.Hour})
+
+	cb.RecordError(upstream.ClientCancelled)
+	cb.RecordError(upstream.ClientDeadline)
+	cb.RecordError(upstream.BodyTooLarge)
+	if cb.GetState() != CLOSED {
+		t.Fatal("Client-side failures should not open the circuit")
+	}
+
+	cb.RecordError(upstream.DialRefused)
+	if cb.GetState() != OPEN {
+		t.Error("Backend failures should open the circuit")
+	}
+}
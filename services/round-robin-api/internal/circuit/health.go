@@ -1,9 +1,6 @@
 package circuit
 
 import (
-	"context"
-	"encoding/json"
-	"fmt"
 	"net/http"
 	"sync"
 	"time"
@@ -13,36 +10,85 @@ import (
 type HealthChecker struct {
 	sync.RWMutex
 	healthStatus map[string]bool
+	stops        map[string]chan struct{}
 	client       *http.Client
+	probe        Probe
+	onRecover    func(url string)
 }
 
 // NewHealthChecker creates a new health checker
 func NewHealthChecker() *HealthChecker {
+	return NewHealthCheckerWithClient(&http.Client{
+		Timeout: time.Second * 2, // 2 second timeout for health checks
+	})
+}
+
+// NewHealthCheckerWithClient creates a health checker that probes through
+// the given client; its Timeout bounds each probe
+func NewHealthCheckerWithClient(client *http.Client) *HealthChecker {
 	return &HealthChecker{
 		healthStatus: make(map[string]bool),
-		client: &http.Client{
-			Timeout: time.Second * 2, // 2 second timeout for health checks
-		},
+		stops:        make(map[string]chan struct{}),
+		client:       client,
+		probe:        HTTPProbe,
 	}
 }
 
+// SetProbe changes how backends are checked; set it before StartChecking
+func (hc *HealthChecker) SetProbe(probe Probe) {
+	hc.Lock()
+	defer hc.Unlock()
+	hc.probe = probe
+}
+
 // StartChecking begins periodic health checks for a backend
 func (hc *HealthChecker) StartChecking(url string, interval time.Duration) {
+	stop := make(chan struct{})
+	hc.Lock()
+	if previous, ok := hc.stops[url]; ok {
+		close(previous)
+	}
+	hc.stops[url] = stop
+	hc.Unlock()
+
 	// Set initial health status to true (optimistic)
-	hc.setHealth(url, true)
-	
+	hc.recordHealth(url, stop, true)
+
 	go func() {
 		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 
 		for {
-			<-ticker.C
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
 			isHealthy := hc.checkHealth(url)
-			hc.setHealth(url, isHealthy)
+			hc.recordHealth(url, stop, isHealthy)
 		}
 	}()
 }
 
+// StopChecking stops health checks for a backend and forgets its status
+func (hc *HealthChecker) StopChecking(url string) {
+	hc.Lock()
+	defer hc.Unlock()
+	if stop, ok := hc.stops[url]; ok {
+		close(stop)
+		delete(hc.stops, url)
+	}
+	delete(hc.healthStatus, url)
+}
+
+// OnRecover registers a callback invoked when a backend that was marked
+// unhealthy passes a health check again
+func (hc *HealthChecker) OnRecover(fn func(url string)) {
+	hc.Lock()
+	defer hc.Unlock()
+	hc.onRecover = fn
+}
+
 // IsHealthy returns whether a backend is currently healthy
 func (hc *HealthChecker) IsHealthy(url string) bool {
 	hc.RLock()
@@ -52,36 +98,35 @@ func (hc *HealthChecker) IsHealthy(url string) bool {
 
 // checkHealth performs a single health check
 func (hc *HealthChecker) checkHealth(url string) bool {
-	healthURL := fmt.Sprintf("%s/health", url)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
-	defer cancel()
-
-	req, err := http.NewRequestWithContext(ctx, "GET", healthURL, nil)
-	if err != nil {
-		return false
-	}
-
-	resp, err := hc.client.Do(req)
-	if err != nil {
-		return false
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return false
-	}
-
-	var result map[string]string
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return false
-	}
-
-	return result["status"] == "ok"
+	hc.RLock()
+	probe := hc.probe
+	hc.RUnlock()
+	return probe(hc.client, url)
 }
 
 // setHealth updates the health status of a backend
 func (hc *HealthChecker) setHealth(url string, isHealthy bool) {
+	hc.recordHealth(url, nil, isHealthy)
+}
+
+// recordHealth updates the health status of a backend unless stop has been
+// closed. A probe still in flight when checking stops would otherwise bring
+// back the status of a backend that has been removed.
+func (hc *HealthChecker) recordHealth(url string, stop <-chan struct{}, isHealthy bool) {
 	hc.Lock()
-	defer hc.Unlock()
+	select {
+	case <-stop:
+		hc.Unlock()
+		return
+	default:
+	}
+	wasHealthy, known := hc.healthStatus[url]
 	hc.healthStatus[url] = isHealthy
+	onRecover := hc.onRecover
+	hc.Unlock()
+
+	// Call outside the lock so the callback can query health itself
+	if known && !wasHealthy && isHealthy && onRecover != nil {
+		onRecover(url)
+	}
 }
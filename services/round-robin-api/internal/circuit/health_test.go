@@ -185,3 +185,68 @@ func TestHealthChecker_ConcurrentAccess(t *testing.T) {
 	// Wait for goroutines to finish
 	time.Sleep(100 * time.Millisecond)
 }
+
+func TestHealthChecker_OnRecover(t *testing.T) {
+	hc := NewHealthChecker()
+	backend := "http://test-backend"
+
+	var recovered []string
+	hc.OnRecover(func(url string) {
+		recovered = append(recovered, url)
+	})
+
+	// Initial and repeated healthy states are not recoveries
+	hc.setHealth(backend, true)
+	hc.setHealth(backend, true)
+	if len(recovered) != 0 {
+		t.Fatalf("Expected no recovery callbacks, got %v", recovered)
+	}
+
+	hc.setHealth(backend, false)
+	hc.setHealth(backend, true)
+	if len(recovered) != 1 || recovered[0] != backend {
+		t.Errorf("Expected one recovery for %s, got %v", backend, recovered)
+	}
+}
+
+func TestHealthChecker_StopChecking(t *testing.T) {
+	hc := NewHealthChecker()
+	hc.StartChecking("http://localhost:1", time.Hour)
+	if !hc.IsHealthy("http://localhost:1") {
+		t.Fatal("Backend should start out healthy")
+	}
+
+	hc.StopChecking("http://localhost:1")
+	if hc.IsHealthy("http://localhost:1") {
+		t.Error("Stopped backend should no longer be reported healthy")
+	}
+	// Stopping twice must be harmless
+	hc.StopChecking("http://localhost:1")
+}
+
+func TestHealthChecker_StopDuringProbe(t *testing.T) {
+	probing, release := make(chan struct{}), make(chan struct{})
+	probed := make(chan struct{})
+	hc := NewHealthChecker()
+	hc.SetProbe(func(client *http.Client, url string) bool {
+		close(probing)
+		<-release
+		defer close(probed)
+		return false
+	})
+	hc.StartChecking("http://localhost:1", 10*time.Millisecond)
+
+	// The probe finishes after the backend has been removed
+	<-probing
+	hc.StopChecking("http://localhost:1")
+	close(release)
+	<-probed
+	time.Sleep(10 * time.Millisecond)
+
+	hc.RLock()
+	_, known := hc.healthStatus["http://localhost:1"]
+	hc.RUnlock()
+	if known {
+		t.Error("A probe finishing after StopChecking should not bring the backend's status back")
+	}
+}
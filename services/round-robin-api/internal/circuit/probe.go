@@ -0,0 +1,143 @@
+package circuit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"round-robin-api/internal/grpcwire"
+)
+
+// Probe performs one health check of the backend at url
+type Probe func(client *http.Client, url string) bool
+
+// HTTPProbe expects GET /health to answer 200 with {"status":"ok"}
+func HTTPProbe(client *http.Client, url string) bool {
+	healthURL := fmt.Sprintf("%s/health", url)
+
+	req, err := http.NewRequest("GET", healthURL, nil)
+	if err != nil {
+		return false
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+
+	var result map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false
+	}
+
+	return result["status"] == "ok"
+}
+
+// TCPProbe passes when a connection to the backend's port opens within the
+// client's timeout. URLs without a port use their scheme's default.
+func TCPProbe(client *http.Client, backendURL string) bool {
+	u, err := url.Parse(backendURL)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+		if port == "" {
+			return false
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(u.Hostname(), port), client.Timeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// UDPProbe sends send to the backend from a connected socket. With expect
+// set, a reply containing it must arrive within the client's timeout.
+// Without it, UDP has no way to confirm delivery, so the probe only fails
+// when the backend's host answers with an ICMP port unreachable.
+func UDPProbe(send, expect []byte) Probe {
+	return func(client *http.Client, backendURL string) bool {
+		u, err := url.Parse(backendURL)
+		if err != nil || u.Hostname() == "" || u.Port() == "" {
+			return false
+		}
+		conn, err := net.DialTimeout("udp", u.Host, client.Timeout)
+		if err != nil {
+			return false
+		}
+		defer conn.Close()
+
+		if _, err := conn.Write(send); err != nil {
+			return false
+		}
+		conn.SetReadDeadline(time.Now().Add(client.Timeout))
+		reply := make([]byte, 64*1024)
+		n, err := conn.Read(reply)
+		if len(expect) > 0 {
+			return err == nil && bytes.Contains(reply[:n], expect)
+		}
+		// Without an expected reply, silence passes
+		var netErr net.Error
+		return err == nil || errors.As(err, &netErr) && netErr.Timeout()
+	}
+}
+
+// GRPCProbe calls grpc.health.v1.Health/Check for service ("" for the
+// whole server) and expects SERVING. The client has to speak HTTP/2 to the
+// backend
+func GRPCProbe(service string) Probe {
+	body := grpcwire.Frame(grpcwire.EncodeHealthCheckRequest(service))
+
+	return func(client *http.Client, url string) bool {
+		req, err := http.NewRequest("POST", url+grpcwire.HealthCheckPath, bytes.NewReader(body))
+		if err != nil {
+			return false
+		}
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("TE", "trailers")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK || !grpcwire.IsGRPC(resp.Header) {
+			return false
+		}
+		// A trailers-only response is an error status with no message, e.g.
+		// UNIMPLEMENTED from a server without the health service
+		if _, ok := grpcwire.Status(resp.Header); ok {
+			return false
+		}
+
+		message, err := grpcwire.ReadFrame(resp.Body)
+		if err != nil {
+			return false
+		}
+		// Trailers arrive once the body is done
+		io.Copy(io.Discard, resp.Body)
+		if code, ok := grpcwire.Status(resp.Trailer); !ok || code != grpcwire.OK {
+			return false
+		}
+
+		status, err := grpcwire.DecodeHealthCheckResponse(message)
+		return err == nil && status == grpcwire.StatusServing
+	}
+}
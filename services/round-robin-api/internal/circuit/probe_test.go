@@ -0,0 +1,152 @@
+package circuit
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"round-robin-api/internal/grpcwire"
+)
+
+// newGRPCHealthServer starts an h2c server answering health checks with
+// status and grpc-status code
+func newGRPCHealthServer(t *testing.T, status grpcwire.ServingStatus, code grpcwire.Code) (*httptest.Server, *string) {
+	t.Helper()
+	var service string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != grpcwire.HealthCheckPath || !grpcwire.IsGRPC(r.Header) {
+			grpcwire.WriteStatus(w, grpcwire.Unimplemented, "unknown method")
+			return
+		}
+		message, err := grpcwire.ReadFrame(r.Body)
+		if err != nil {
+			grpcwire.WriteStatus(w, grpcwire.Internal, err.Error())
+			return
+		}
+		if len(message) > 2 {
+			service = string(message[2:])
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write(grpcwire.Frame(grpcwire.EncodeHealthCheckResponse(status)))
+		w.Header().Set("Grpc-Status", strconv.Itoa(int(code)))
+	}))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	t.Cleanup(server.Close)
+	return server, &service
+}
+
+func h2cClient() *http.Client {
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: transport}
+}
+
+func TestGRPCProbe(t *testing.T) {
+	tests := []struct {
+		name   string
+		status grpcwire.ServingStatus
+		code   grpcwire.Code
+		want   bool
+	}{
+		{"serving", grpcwire.StatusServing, grpcwire.OK, true},
+		{"not serving", grpcwire.StatusNotServing, grpcwire.OK, false},
+		{"error status", grpcwire.StatusServing, grpcwire.Unavailable, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, service := newGRPCHealthServer(t, tt.status, tt.code)
+			if got := GRPCProbe("echo.Echo")(h2cClient(), server.URL); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+			if *service != "echo.Echo" {
+				t.Errorf("Expected the service to be asked about, got %q", *service)
+			}
+		})
+	}
+}
+
+func TestGRPCProbe_Unimplemented(t *testing.T) {
+	server, _ := newGRPCHealthServer(t, grpcwire.StatusServing, grpcwire.OK)
+	probe := GRPCProbe("")
+	if probe(h2cClient(), server.URL+"/elsewhere") {
+		t.Error("Expected a trailers-only error to fail the probe")
+	}
+	if !probe(h2cClient(), server.URL) {
+		t.Error("Expected the whole server to be SERVING")
+	}
+}
+
+func TestTCPProbe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	client := &http.Client{Timeout: time.Second}
+
+	if !TCPProbe(client, "tcp://"+addr) {
+		t.Error("Expected an open port to pass")
+	}
+	listener.Close()
+	if TCPProbe(client, "tcp://"+addr) {
+		t.Error("Expected a closed port to fail")
+	}
+	if TCPProbe(client, "tcp://no-port") {
+		t.Error("Expected a URL without a port to fail")
+	}
+}
+
+func TestUDPProbe(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if string(buf[:n]) == "ping" {
+				conn.WriteTo([]byte("pong"), from)
+			}
+		}
+	}()
+	client := &http.Client{Timeout: 200 * time.Millisecond}
+
+	if !UDPProbe([]byte("ping"), []byte("pong"))(client, "udp://"+addr) {
+		t.Error("Expected the expected reply to pass")
+	}
+	if UDPProbe([]byte("hello"), []byte("pong"))(client, "udp://"+addr) {
+		t.Error("Expected a missing reply to fail")
+	}
+	if !UDPProbe([]byte("hello"), nil)(client, "udp://"+addr) {
+		t.Error("Expected silence to pass without an expected reply")
+	}
+	conn.Close()
+	// Nothing listens any more, so the host answers with port unreachable
+	if UDPProbe([]byte("hello"), nil)(client, "udp://"+addr) {
+		t.Error("Expected a closed port to fail")
+	}
+}
+
+func TestHealthChecker_SetProbe(t *testing.T) {
+	hc := NewHealthChecker()
+	var probed string
+	hc.SetProbe(func(client *http.Client, url string) bool {
+		probed = url
+		return false
+	})
+	if hc.checkHealth("http://backend") || probed != "http://backend" {
+		t.Errorf("Expected the custom probe to be used, probed %q", probed)
+	}
+}
@@ -0,0 +1,342 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"round-robin-api/internal/balancer"
+	"round-robin-api/internal/proxyproto"
+	"round-robin-api/internal/router"
+)
+
+// Config is the optional JSON file pointed to by CONFIG_FILE. It declares
+// backend pools beyond the default BACKENDS pool and the routes that map
+// requests onto them, and TCP and UDP listeners relaying to pools.
+type Config struct {
+	Pools  []PoolConfig     `json:"pools"`
+	Routes []router.Route   `json:"routes"`
+	TCP    []ListenerConfig `json:"tcp,omitempty"`
+	UDP    []ListenerConfig `json:"udp,omitempty"`
+}
+
+// ListenerConfig accepts TCP connections or UDP datagrams on Listen, e.g.
+// ":6379", and relays them to the backends of Pool, which must use the
+// listener's protocol
+type ListenerConfig struct {
+	Listen string `json:"listen"`
+	Pool   string `json:"pool"`
+	// ProxyProtocol reads a PROXY protocol header from trusted peers at the
+	// start of each TCP connection
+	ProxyProtocol bool `json:"proxy_protocol,omitempty"`
+}
+
+// PoolConfig declares a named pool of backends. Unset settings fall back to
+// the process-wide defaults.
+type PoolConfig struct {
+	Name     string                   `json:"name"`
+	Backends []balancer.BackendConfig `json:"backends"`
+	Strategy string                   `json:"strategy,omitempty"`
+	HashKey  string                   `json:"hash_key,omitempty"`
+	Protocol string                   `json:"protocol,omitempty"`
+	Timeouts TimeoutsConfig           `json:"timeouts,omitempty"`
+	Breaker  BreakerConfig            `json:"breaker,omitempty"`
+	// Retries is a pointer so a pool can turn retries off with 0
+	Retries     *int         `json:"retries,omitempty"`
+	RetryBudget float64      `json:"retry_budget,omitempty"`
+	Hedge       *HedgeConfig `json:"hedge,omitempty"`
+	// FlushInterval is a pointer so a pool can ask for a flush after every
+	// write with "0s"
+	FlushInterval *Duration          `json:"flush_interval,omitempty"`
+	HealthCheck   *HealthCheckConfig `json:"health_check,omitempty"`
+	TLS           *TLSConfig         `json:"tls,omitempty"`
+	ProxyProtocol string             `json:"proxy_protocol,omitempty"`
+}
+
+// TLSConfig replaces the upstream TLS settings of a pool
+type TLSConfig struct {
+	CAFile             string `json:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+// settings converts the config to the balancer's settings
+func (t TLSConfig) settings() balancer.TLSSettings {
+	return balancer.TLSSettings{
+		CAFile:             t.CAFile,
+		CertFile:           t.CertFile,
+		KeyFile:            t.KeyFile,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+}
+
+// HealthCheckConfig overrides how a pool probes its backends
+type HealthCheckConfig struct {
+	Type    string `json:"type,omitempty"`
+	Service string `json:"service,omitempty"`
+	Send    string `json:"send,omitempty"`
+	Expect  string `json:"expect,omitempty"`
+}
+
+// HedgeConfig overrides the hedged request settings of a pool; a percentile
+// of 0 turns hedging off
+type HedgeConfig struct {
+	Percentile float64  `json:"percentile"`
+	MinDelay   Duration `json:"min_delay,omitempty"`
+}
+
+// TimeoutsConfig overrides the timeouts of a pool
+type TimeoutsConfig struct {
+	Connect        Duration `json:"connect,omitempty"`
+	TLSHandshake   Duration `json:"tls_handshake,omitempty"`
+	ResponseHeader Duration `json:"response_header,omitempty"`
+	Total          Duration `json:"total,omitempty"`
+	Idle           Duration `json:"idle,omitempty"`
+	StreamIdle     Duration `json:"stream_idle,omitempty"`
+	HealthCheck    Duration `json:"health_check,omitempty"`
+	SessionIdle    Duration `json:"session_idle,omitempty"`
+}
+
+// negative reports whether any timeout is negative
+func (t TimeoutsConfig) negative() bool {
+	for _, d := range []Duration{t.Connect, t.TLSHandshake, t.ResponseHeader, t.Total, t.Idle, t.StreamIdle, t.HealthCheck, t.SessionIdle} {
+		if d < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// apply overrides the timeouts that are set
+func (t TimeoutsConfig) apply(timeouts *balancer.Timeouts) {
+	override := func(target *time.Duration, value Duration) {
+		if value > 0 {
+			*target = time.Duration(value)
+		}
+	}
+	override(&timeouts.Connect, t.Connect)
+	override(&timeouts.TLSHandshake, t.TLSHandshake)
+	override(&timeouts.ResponseHeader, t.ResponseHeader)
+	override(&timeouts.Total, t.Total)
+	override(&timeouts.Idle, t.Idle)
+	override(&timeouts.StreamIdle, t.StreamIdle)
+	override(&timeouts.HealthCheck, t.HealthCheck)
+	override(&timeouts.SessionIdle, t.SessionIdle)
+}
+
+// BreakerConfig overrides the circuit breaker settings of a pool
+type BreakerConfig struct {
+	FailureThreshold int      `json:"failure_threshold,omitempty"`
+	OpenTimeout      Duration `json:"open_timeout,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "1.5s" in JSON
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"2s\"")
+	}
+	value, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(value)
+	return nil
+}
+
+// Validate checks a single pool declaration
+func (p PoolConfig) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("pool name is required")
+	}
+	for _, backend := range p.Backends {
+		if backend.URL == "" {
+			return fmt.Errorf("pool %s: backend URL cannot be empty", p.Name)
+		}
+		if backend.Weight < 0 || backend.Priority < 0 {
+			return fmt.Errorf("pool %s: backend %s: weight and priority cannot be negative", p.Name, backend.URL)
+		}
+	}
+	if p.Strategy != "" {
+		if _, err := balancer.New(p.Strategy, balancer.Options{HashKey: p.HashKey}); err != nil {
+			return fmt.Errorf("pool %s: %v", p.Name, err)
+		}
+	}
+	if err := balancer.ValidateProtocol(p.Protocol); err != nil {
+		return fmt.Errorf("pool %s: %v", p.Name, err)
+	}
+	for _, backend := range p.Backends {
+		if err := balancer.ValidateBackend(p.Protocol, backend.URL); err != nil {
+			return fmt.Errorf("pool %s: %v", p.Name, err)
+		}
+	}
+	if p.Timeouts.negative() || p.Breaker.OpenTimeout < 0 || p.Breaker.FailureThreshold < 0 {
+		return fmt.Errorf("pool %s: timeouts and failure_threshold cannot be negative", p.Name)
+	}
+	if p.Retries != nil && *p.Retries < 0 {
+		return fmt.Errorf("pool %s: retries cannot be negative", p.Name)
+	}
+	if p.RetryBudget < 0 || p.RetryBudget > 1 {
+		return fmt.Errorf("pool %s: retry_budget must be between 0 and 1", p.Name)
+	}
+	if p.Hedge != nil && (p.Hedge.Percentile < 0 || p.Hedge.Percentile >= 1 || p.Hedge.MinDelay < 0) {
+		return fmt.Errorf("pool %s: hedge percentile must be between 0 and 1", p.Name)
+	}
+	if p.FlushInterval != nil && *p.FlushInterval < 0 {
+		return fmt.Errorf("pool %s: flush_interval cannot be negative", p.Name)
+	}
+	if p.HealthCheck != nil {
+		if err := balancer.ValidateHealthCheckType(p.HealthCheck.Type); err != nil {
+			return fmt.Errorf("pool %s: %v", p.Name, err)
+		}
+	}
+	if p.TLS != nil {
+		if err := p.TLS.settings().Validate(); err != nil {
+			return fmt.Errorf("pool %s: %v", p.Name, err)
+		}
+	}
+	if err := proxyproto.ValidateVersion(p.ProxyProtocol); err != nil {
+		return fmt.Errorf("pool %s: %v", p.Name, err)
+	}
+	return nil
+}
+
+// Settings merges the pool's overrides into the given defaults
+func (p PoolConfig) Settings(defaults balancer.PoolSettings) balancer.PoolSettings {
+	settings := defaults
+	if p.Strategy != "" {
+		settings.Strategy = p.Strategy
+	}
+	if p.HashKey != "" {
+		settings.Options.HashKey = p.HashKey
+	}
+	if p.Protocol != "" {
+		settings.Protocol = p.Protocol
+	}
+	p.Timeouts.apply(&settings.Timeouts)
+	if p.Breaker.FailureThreshold > 0 {
+		settings.Breaker.FailureThreshold = p.Breaker.FailureThreshold
+	}
+	if p.Breaker.OpenTimeout > 0 {
+		settings.Breaker.OpenTimeout = time.Duration(p.Breaker.OpenTimeout)
+	}
+	if p.Retries != nil {
+		settings.Retries = *p.Retries
+	}
+	if p.RetryBudget > 0 {
+		settings.RetryBudget = p.RetryBudget
+	}
+	if p.Hedge != nil {
+		settings.Hedge = balancer.HedgeSettings{
+			Percentile: p.Hedge.Percentile,
+			MinDelay:   time.Duration(p.Hedge.MinDelay),
+		}
+	}
+	if p.FlushInterval != nil {
+		settings.FlushInterval = time.Duration(*p.FlushInterval)
+	}
+	if p.HealthCheck != nil {
+		settings.HealthCheck = balancer.HealthCheckSettings{
+			Type:    p.HealthCheck.Type,
+			Service: p.HealthCheck.Service,
+			Send:    p.HealthCheck.Send,
+			Expect:  p.HealthCheck.Expect,
+		}
+	}
+	if p.TLS != nil {
+		settings.TLS = p.TLS.settings()
+	}
+	if p.ProxyProtocol != "" {
+		settings.ProxyProtocol = p.ProxyProtocol
+	}
+	return settings
+}
+
+// Load reads and validates a config file
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid config file: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate checks pools, routes and listeners for consistency
+func (c *Config) Validate() error {
+	// protocols maps each pool to the protocol it declares
+	protocols := make(map[string]string)
+	for _, pool := range c.Pools {
+		if err := pool.Validate(); err != nil {
+			return err
+		}
+		if _, ok := protocols[pool.Name]; ok {
+			return fmt.Errorf("duplicate pool: %s", pool.Name)
+		}
+		protocols[pool.Name] = pool.Protocol
+	}
+
+	for _, route := range c.Routes {
+		if err := route.Validate(); err != nil {
+			return err
+		}
+		protocol, ok := protocols[route.Pool]
+		// The default pool may come from BACKENDS instead of the file
+		if !ok && route.Pool != balancer.DefaultPool {
+			return fmt.Errorf("route %s: unknown pool %s", route, route.Pool)
+		}
+		if balancer.Layer4(protocol) {
+			return fmt.Errorf("route %s: pool %s relays %s and cannot serve HTTP routes", route, route.Pool, protocol)
+		}
+	}
+
+	if err := validateListeners(balancer.ProtocolTCP, c.TCP, protocols); err != nil {
+		return err
+	}
+	return validateListeners(balancer.ProtocolUDP, c.UDP, protocols)
+}
+
+// validateListeners checks that listeners of the given protocol have
+// distinct addresses and point at pools using that protocol
+func validateListeners(protocol string, listeners []ListenerConfig, protocols map[string]string) error {
+	listening := make(map[string]bool)
+	for _, listener := range listeners {
+		if _, _, err := net.SplitHostPort(listener.Listen); err != nil {
+			return fmt.Errorf("%s listener %q: %v", protocol, listener.Listen, err)
+		}
+		if listening[listener.Listen] {
+			return fmt.Errorf("duplicate %s listener: %s", protocol, listener.Listen)
+		}
+		listening[listener.Listen] = true
+		if listener.ProxyProtocol && protocol != balancer.ProtocolTCP {
+			return fmt.Errorf("%s listener %s: PROXY protocol headers are only read on tcp listeners", protocol, listener.Listen)
+		}
+		// The default pool's protocol may come from UPSTREAM_PROTOCOL
+		poolProtocol, ok := protocols[listener.Pool]
+		switch {
+		case !ok && listener.Pool != balancer.DefaultPool:
+			return fmt.Errorf("%s listener %s: unknown pool %s", protocol, listener.Listen, listener.Pool)
+		case ok && poolProtocol != protocol:
+			return fmt.Errorf("%s listener %s: pool %s must use protocol %s", protocol, listener.Listen, listener.Pool, protocol)
+		}
+	}
+	return nil
+}
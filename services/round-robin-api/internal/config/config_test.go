@@ -0,0 +1,187 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"round-robin-api/internal/balancer"
+	"round-robin-api/internal/circuit"
+	"round-robin-api/internal/proxyproto"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoad_Valid(t *testing.T) {
+	path := writeConfig(t, `{
+		"pools": [
+			{"name": "users", "backends": [{"url": "http://users-1:8080", "weight": 2}, {"url": "http://users-2:8080"}]}
+		],
+		"routes": [
+			{"prefix": "/users", "pool": "users", "strip_prefix": true},
+			{"prefix": "/", "pool": "default"}
+		]
+	}`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(cfg.Pools) != 1 || len(cfg.Pools[0].Backends) != 2 {
+		t.Fatalf("Unexpected pools: %+v", cfg.Pools)
+	}
+	if cfg.Pools[0].Backends[0].Weight != 2 {
+		t.Errorf("Expected weight 2, got %d", cfg.Pools[0].Backends[0].Weight)
+	}
+	if len(cfg.Routes) != 2 || !cfg.Routes[0].StripPrefix {
+		t.Errorf("Unexpected routes: %+v", cfg.Routes)
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"malformed JSON":     `{"pools": [`,
+		"missing pool name":  `{"pools": [{"backends": [{"url": "http://a"}]}]}`,
+		"duplicate pool":     `{"pools": [{"name": "a"}, {"name": "a"}]}`,
+		"empty backend URL":  `{"pools": [{"name": "a", "backends": [{"url": ""}]}]}`,
+		"unknown route pool": `{"routes": [{"prefix": "/x", "pool": "missing"}]}`,
+		"invalid route":      `{"pools": [{"name": "a"}], "routes": [{"prefix": "x", "pool": "a"}]}`,
+		"unknown strategy":   `{"pools": [{"name": "a", "strategy": "random"}]}`,
+		"bad timeout":        `{"pools": [{"name": "a", "timeouts": {"total": "soon"}}]}`,
+		"numeric timeout":    `{"pools": [{"name": "a", "timeouts": {"total": 5}}]}`,
+		"negative connect":   `{"pools": [{"name": "a", "timeouts": {"connect": "-1s"}}]}`,
+		"negative timeout":   `{"pools": [{"name": "a", "breaker": {"open_timeout": "-1s"}}]}`,
+		"negative retries":   `{"pools": [{"name": "a", "retries": -1}]}`,
+		"retry budget > 1":   `{"pools": [{"name": "a", "retry_budget": 1.5}]}`,
+		"hedge percentile":   `{"pools": [{"name": "a", "hedge": {"percentile": 95}}]}`,
+		"negative flush":     `{"pools": [{"name": "a", "flush_interval": "-1s"}]}`,
+		"unknown protocol":   `{"pools": [{"name": "a", "protocol": "spdy"}]}`,
+		"unknown check type": `{"pools": [{"name": "a", "health_check": {"type": "icmp"}}]}`,
+		"cert without key":   `{"pools": [{"name": "a", "tls": {"cert_file": "client.pem"}}]}`,
+		"http tcp backend":   `{"pools": [{"name": "a", "protocol": "tcp", "backends": [{"url": "http://a:80"}]}]}`,
+		"http pool udp url":  `{"pools": [{"name": "a", "backends": [{"url": "udp://a:53"}]}]}`,
+		"http pool tcp url":  `{"pools": [{"name": "a", "backends": [{"url": "tcp://a:6379"}]}]}`,
+		"route to tcp pool":  `{"pools": [{"name": "a", "protocol": "tcp"}], "routes": [{"prefix": "/", "pool": "a"}]}`,
+		"listener address":   `{"pools": [{"name": "a", "protocol": "tcp"}], "tcp": [{"listen": "6379", "pool": "a"}]}`,
+		"listener pool":      `{"tcp": [{"listen": ":6379", "pool": "missing"}]}`,
+		"listener http pool": `{"pools": [{"name": "a"}], "tcp": [{"listen": ":6379", "pool": "a"}]}`,
+		"duplicate listener": `{"pools": [{"name": "a", "protocol": "tcp"}], "tcp": [{"listen": ":6379", "pool": "a"}, {"listen": ":6379", "pool": "a"}]}`,
+		"udp on a tcp pool":  `{"pools": [{"name": "a", "protocol": "tcp"}], "udp": [{"listen": ":53", "pool": "a"}]}`,
+		"route to udp pool":  `{"pools": [{"name": "a", "protocol": "udp"}], "routes": [{"prefix": "/", "pool": "a"}]}`,
+		"tcp udp backend":    `{"pools": [{"name": "a", "protocol": "tcp", "backends": [{"url": "udp://a:53"}]}]}`,
+		"proxy version":      `{"pools": [{"name": "a", "proxy_protocol": "v3"}]}`,
+		"unix backend host":  `{"pools": [{"name": "a", "backends": [{"url": "unix://run/app.sock"}]}]}`,
+		"udp proxy protocol": `{"pools": [{"name": "a", "protocol": "udp"}], "udp": [{"listen": ":53", "pool": "a", "proxy_protocol": true}]}`,
+	}
+	for name, content := range tests {
+		if _, err := Load(writeConfig(t, content)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
+
+func TestLoad_Layer4(t *testing.T) {
+	path := writeConfig(t, `{
+		"pools": [
+			{"name": "redis", "protocol": "tcp", "backends": [{"url": "tcp://redis-1:6379"}, {"url": "tcp://redis-2:6379"}], "proxy_protocol": "v2"},
+			{"name": "dns", "protocol": "udp", "backends": [{"url": "udp://dns-1:53"}], "timeouts": {"session_idle": "10s"}}
+		],
+		"tcp": [
+			{"listen": ":6379", "pool": "redis"},
+			{"listen": "127.0.0.1:5432", "pool": "default", "proxy_protocol": true}
+		],
+		"udp": [
+			{"listen": ":53", "pool": "dns"}
+		]
+	}`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(cfg.TCP) != 2 || cfg.TCP[0] != (ListenerConfig{Listen: ":6379", Pool: "redis"}) {
+		t.Errorf("Unexpected tcp listeners: %+v", cfg.TCP)
+	}
+	if !cfg.TCP[1].ProxyProtocol {
+		t.Error("Expected the second tcp listener to read PROXY protocol headers")
+	}
+	if len(cfg.UDP) != 1 || cfg.UDP[0] != (ListenerConfig{Listen: ":53", Pool: "dns"}) {
+		t.Errorf("Unexpected udp listeners: %+v", cfg.UDP)
+	}
+	if settings := cfg.Pools[0].Settings(balancer.PoolSettings{}); settings.ProxyProtocol != proxyproto.Version2 {
+		t.Errorf("Expected PROXY protocol v2 to backends, got %q", settings.ProxyProtocol)
+	}
+	settings := cfg.Pools[1].Settings(balancer.PoolSettings{Timeouts: balancer.DefaultTimeouts()})
+	if settings.Timeouts.SessionIdle != 10*time.Second {
+		t.Errorf("Expected a 10s session idle timeout, got %v", settings.Timeouts.SessionIdle)
+	}
+}
+
+func TestPoolConfig_Settings(t *testing.T) {
+	path := writeConfig(t, `{
+		"pools": [
+			{"name": "search", "strategy": "least-outstanding", "protocol": "h2c", "timeouts": {"total": "500ms", "connect": "100ms"}, "breaker": {"failure_threshold": 3}, "retries": 0,
+			 "hedge": {"percentile": 0.95, "min_delay": "5ms"}, "flush_interval": "0s",
+			 "health_check": {"type": "grpc", "service": "search.Search"},
+			 "tls": {"ca_file": "ca.pem", "cert_file": "client.pem", "key_file": "client-key.pem", "server_name": "search.internal"}}
+		]
+	}`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	defaults := balancer.PoolSettings{
+		Strategy:      "round-robin",
+		Options:       balancer.Options{HashKey: "ip"},
+		Timeouts:      balancer.DefaultTimeouts(),
+		Breaker:       circuit.Settings{FailureThreshold: 5, OpenTimeout: 10 * time.Second},
+		Retries:       2,
+		FlushInterval: 100 * time.Millisecond,
+	}
+	settings := cfg.Pools[0].Settings(defaults)
+
+	if settings.Strategy != "least-outstanding" || settings.Timeouts.Total != 500*time.Millisecond || settings.Timeouts.Connect != 100*time.Millisecond {
+		t.Errorf("Pool overrides not applied: %+v", settings)
+	}
+	if settings.Timeouts.Idle != balancer.DefaultTimeouts().Idle {
+		t.Errorf("Expected default idle timeout to be kept, got %v", settings.Timeouts.Idle)
+	}
+	if settings.Breaker.FailureThreshold != 3 || settings.Breaker.OpenTimeout != 10*time.Second {
+		t.Errorf("Expected breaker override merged with defaults, got %+v", settings.Breaker)
+	}
+	if settings.Retries != 0 {
+		t.Errorf("Expected retries to be turned off, got %d", settings.Retries)
+	}
+	if settings.Hedge.Percentile != 0.95 || settings.Hedge.MinDelay != 5*time.Millisecond {
+		t.Errorf("Expected hedge settings to be applied, got %+v", settings.Hedge)
+	}
+	if settings.Protocol != balancer.ProtocolH2C {
+		t.Errorf("Expected protocol override, got %q", settings.Protocol)
+	}
+	if settings.FlushInterval != 0 {
+		t.Errorf("Expected flush after every write, got %v", settings.FlushInterval)
+	}
+	if settings.HealthCheck.Type != balancer.HealthCheckGRPC || settings.HealthCheck.Service != "search.Search" {
+		t.Errorf("Expected gRPC health check, got %+v", settings.HealthCheck)
+	}
+	wantTLS := balancer.TLSSettings{CAFile: "ca.pem", CertFile: "client.pem", KeyFile: "client-key.pem", ServerName: "search.internal"}
+	if settings.TLS != wantTLS {
+		t.Errorf("Expected upstream TLS settings, got %+v", settings.TLS)
+	}
+	if settings.Options.HashKey != "ip" {
+		t.Errorf("Expected default hash key to be kept, got %q", settings.Options.HashKey)
+	}
+}
@@ -0,0 +1,238 @@
+package forward
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ViaPseudonym identifies the load balancer in Via headers
+const ViaPseudonym = "round-robin-api"
+
+// hopByHop are the headers that only concern a single connection and must
+// not be forwarded (RFC 7230, section 6.1)
+var hopByHop = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// HasToken reports whether a comma-separated header such as Connection
+// lists the given token
+func HasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RemoveHopByHop deletes the hop-by-hop headers, including any the
+// Connection header names
+func RemoveHopByHop(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHop {
+		h.Del(name)
+	}
+}
+
+// AddVia appends this hop to the Via header for a message received with the
+// given protocol version
+func AddVia(h http.Header, protoMajor, protoMinor int) {
+	version := strconv.Itoa(protoMajor)
+	if protoMajor < 2 {
+		version = fmt.Sprintf("%d.%d", protoMajor, protoMinor)
+	}
+	appendValue(h, "Via", version+" "+ViaPseudonym)
+}
+
+// TrustedProxies are the addresses of proxies in front of the load balancer
+// whose X-Forwarded-For and Forwarded headers can be believed
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies parses a comma-separated list of CIDRs or single
+// addresses, e.g. "10.0.0.0/8, 192.168.1.1"
+func ParseTrustedProxies(spec string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %v", entry, err)
+			}
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", entry, err)
+		}
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return proxies, nil
+}
+
+// Trusts reports whether ip belongs to a trusted proxy
+func (t TrustedProxies) Trusts(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, prefix := range t {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerIP is the address of whoever opened the connection
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ClientIP works out the client's address. X-Forwarded-For is only believed
+// as far as it was written by trusted proxies: it is read from the right and
+// the first address that isn't a trusted proxy is the client.
+func (t TrustedProxies) ClientIP(r *http.Request) string {
+	client := peerIP(r)
+	if !t.Trusts(client) {
+		return client
+	}
+
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			// A trusted proxy wouldn't write this; stop at the last
+			// address we can vouch for
+			return client
+		}
+		client = hop
+		if !t.Trusts(hop) {
+			break
+		}
+	}
+	return client
+}
+
+type clientIPKey struct{}
+
+// WithClientIP records the client's address, as worked out by ClientIP, on
+// the request
+func WithClientIP(r *http.Request, ip string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip))
+}
+
+// ClientIP returns the address recorded by WithClientIP, or the address of
+// the connection's peer
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return peerIP(r)
+}
+
+// SetHeaders adds this hop to the X-Forwarded-For, X-Forwarded-Proto,
+// X-Forwarded-Host, Forwarded and Via headers of out, a copy of r's headers.
+// Forwarding headers from a peer that isn't a trusted proxy are discarded,
+// so clients can't pass off a made-up history.
+func (t TrustedProxies) SetHeaders(out http.Header, r *http.Request) {
+	peer := peerIP(r)
+	if !t.Trusts(peer) {
+		for _, name := range []string{"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "Forwarded"} {
+			out.Del(name)
+		}
+	}
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	// A client on a Unix socket has no address (RFC 7239, section 6.2)
+	if _, err := netip.ParseAddr(peer); err != nil {
+		peer = "unknown"
+	}
+	appendValue(out, "X-Forwarded-For", peer)
+	if out.Get("X-Forwarded-Proto") == "" {
+		out.Set("X-Forwarded-Proto", proto)
+	}
+	if out.Get("X-Forwarded-Host") == "" && r.Host != "" {
+		out.Set("X-Forwarded-Host", r.Host)
+	}
+
+	forwarded := "for=" + forwardedNode(peer)
+	if r.Host != "" {
+		forwarded += ";host=" + quoteIfNeeded(r.Host)
+	}
+	forwarded += ";proto=" + proto
+	appendValue(out, "Forwarded", forwarded)
+
+	AddVia(out, r.ProtoMajor, r.ProtoMinor)
+}
+
+// appendValue adds value to a comma-separated list header, folding any
+// existing lines into one
+func appendValue(h http.Header, name, value string) {
+	if prior := h.Values(name); len(prior) > 0 {
+		value = strings.Join(prior, ", ") + ", " + value
+	}
+	h.Set(name, value)
+}
+
+// forwardedNode formats an address for the Forwarded header, where IPv6
+// addresses are bracketed and quoted (RFC 7239, section 6)
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return quoteIfNeeded(ip)
+}
+
+// quoteIfNeeded quotes a Forwarded parameter value that isn't a plain token
+func quoteIfNeeded(value string) string {
+	for _, c := range value {
+		if !isTokenChar(c) {
+			return strconv.Quote(value)
+		}
+	}
+	return value
+}
+
+func isTokenChar(c rune) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+}
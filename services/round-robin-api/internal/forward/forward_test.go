@@ -0,0 +1,178 @@
+package forward
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRemoveHopByHop(t *testing.T) {
+	h := http.Header{}
+	h.Set("Connection", "keep-alive, X-Session-Hint")
+	h.Set("Keep-Alive", "timeout=5")
+	h.Set("Te", "trailers")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("X-Session-Hint", "abc")
+	h.Set("Content-Type", "application/json")
+
+	RemoveHopByHop(h)
+
+	for _, name := range []string{"Connection", "Keep-Alive", "Te", "Transfer-Encoding", "X-Session-Hint"} {
+		if h.Get(name) != "" {
+			t.Errorf("Expected %s to be removed", name)
+		}
+	}
+	if h.Get("Content-Type") != "application/json" {
+		t.Error("End-to-end headers should be kept")
+	}
+}
+
+func TestHasToken(t *testing.T) {
+	h := http.Header{}
+	h.Add("Connection", "keep-alive")
+	h.Add("Connection", "Upgrade, close")
+
+	if !HasToken(h, "Connection", "upgrade") {
+		t.Error("Expected upgrade token to be found case-insensitively")
+	}
+	if HasToken(h, "Connection", "trailers") {
+		t.Error("Unexpected token found")
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1,2001:db8::/32")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	tests := map[string]bool{
+		"10.1.2.3":        true,
+		"192.168.1.1":     true,
+		"192.168.1.2":     false,
+		"2001:db8::1":     true,
+		"::ffff:10.0.0.1": true,
+		"not-an-ip":       false,
+		"172.16.0.1":      false,
+	}
+	for ip, want := range tests {
+		if got := proxies.Trusts(ip); got != want {
+			t.Errorf("Trusts(%s) = %v, want %v", ip, got, want)
+		}
+	}
+
+	for _, spec := range []string{"10.0.0.0/33", "example.com"} {
+		if _, err := ParseTrustedProxies(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
+
+func TestTrustedProxies_ClientIP(t *testing.T) {
+	proxies, _ := ParseTrustedProxies("10.0.0.0/8")
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		want       string
+	}{
+		{"no proxy", "203.0.113.7:4000", nil, "203.0.113.7"},
+		{"untrusted peer can't spoof", "203.0.113.7:4000", []string{"1.2.3.4"}, "203.0.113.7"},
+		{"trusted peer", "10.0.0.2:4000", []string{"198.51.100.9"}, "198.51.100.9"},
+		{"spoofed entry left of the client", "10.0.0.2:4000", []string{"1.2.3.4, 198.51.100.9, 10.0.0.5"}, "198.51.100.9"},
+		{"several header lines", "10.0.0.2:4000", []string{"198.51.100.9", "10.0.0.5"}, "198.51.100.9"},
+		{"only proxies", "10.0.0.2:4000", []string{"10.0.0.9"}, "10.0.0.9"},
+		{"garbage", "10.0.0.2:4000", []string{"unknown"}, "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for _, v := range tt.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := proxies.ClientIP(r); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestClientIP_Context(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.2:4000"
+	if got := ClientIP(r); got != "10.0.0.2" {
+		t.Errorf("Expected the peer address without a recorded client, got %s", got)
+	}
+	if got := ClientIP(WithClientIP(r, "198.51.100.9")); got != "198.51.100.9" {
+		t.Errorf("Expected the recorded client address, got %s", got)
+	}
+}
+
+func TestTrustedProxies_SetHeaders(t *testing.T) {
+	proxies, _ := ParseTrustedProxies("10.0.0.0/8")
+
+	// An untrusted client's forwarding headers are replaced
+	r := httptest.NewRequest(http.MethodGet, "http://api.example.com/users", nil)
+	r.RemoteAddr = "203.0.113.7:4000"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4")
+	r.Header.Set("X-Forwarded-Host", "evil.example.com")
+	r.Header.Set("Forwarded", "for=1.2.3.4")
+	out := r.Header.Clone()
+	proxies.SetHeaders(out, r)
+
+	expected := map[string]string{
+		"X-Forwarded-For":   "203.0.113.7",
+		"X-Forwarded-Proto": "http",
+		"X-Forwarded-Host":  "api.example.com",
+		"Forwarded":         "for=203.0.113.7;host=api.example.com;proto=http",
+		"Via":               "1.1 " + ViaPseudonym,
+	}
+	for name, want := range expected {
+		if got := out.Get(name); got != want {
+			t.Errorf("%s: expected %q, got %q", name, want, got)
+		}
+	}
+
+	// A trusted proxy's headers are extended
+	r = httptest.NewRequest(http.MethodGet, "http://lb.internal:8080/users", nil)
+	r.RemoteAddr = "[::1]:4000"
+	proxies, _ = ParseTrustedProxies("10.0.0.0/8, ::1")
+	r.Header.Set("X-Forwarded-For", "198.51.100.9")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "api.example.com")
+	r.Header.Set("Forwarded", "for=198.51.100.9;proto=https")
+	r.Header.Set("Via", "1.1 edge")
+	out = r.Header.Clone()
+	proxies.SetHeaders(out, r)
+
+	expected = map[string]string{
+		"X-Forwarded-For":   "198.51.100.9, ::1",
+		"X-Forwarded-Proto": "https",
+		"X-Forwarded-Host":  "api.example.com",
+		"Forwarded":         `for=198.51.100.9;proto=https, for="[::1]";host="lb.internal:8080";proto=http`,
+		"Via":               "1.1 edge, 1.1 " + ViaPseudonym,
+	}
+	for name, want := range expected {
+		if got := out.Get(name); got != want {
+			t.Errorf("%s: expected %q, got %q", name, want, got)
+		}
+	}
+
+	// Clients on a Unix socket have no address
+	r = httptest.NewRequest(http.MethodGet, "http://lb/users", nil)
+	r.RemoteAddr = "@"
+	out = r.Header.Clone()
+	proxies.SetHeaders(out, r)
+	if got := out.Get("X-Forwarded-For"); got != "unknown" {
+		t.Errorf("Expected an unknown client, got %q", got)
+	}
+	if got := out.Get("Forwarded"); got != "for=unknown;host=lb;proto=http" {
+		t.Errorf("Expected an unknown client, got %q", got)
+	}
+}
+
+func TestAddVia(t *testing.T) {
+	h := http.Header{}
+	AddVia(h, 2, 0)
+	if got := h.Get("Via"); got != "2 "+ViaPseudonym {
+		t.Errorf("Expected HTTP/2 to be written as 2, got %q", got)
+	}
+}
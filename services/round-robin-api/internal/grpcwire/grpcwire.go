@@ -0,0 +1,164 @@
+package grpcwire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Code is a gRPC status code
+type Code int
+
+const (
+	OK Code = iota
+	Canceled
+	Unknown
+	InvalidArgument
+	DeadlineExceeded
+	NotFound
+	AlreadyExists
+	PermissionDenied
+	ResourceExhausted
+	FailedPrecondition
+	Aborted
+	OutOfRange
+	Unimplemented
+	Internal
+	Unavailable
+	DataLoss
+	Unauthenticated
+)
+
+var codeNames = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED",
+	"NOT_FOUND", "ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED",
+	"INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED",
+}
+
+func (c Code) String() string {
+	if c >= 0 && int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return "CODE(" + strconv.Itoa(int(c)) + ")"
+}
+
+// BackendFault reports whether a call ending with this code says something
+// is wrong with the backend, as opposed to with the call itself, and so
+// should count towards its circuit breaker
+func (c Code) BackendFault() bool {
+	switch c {
+	case Unknown, DeadlineExceeded, Internal, Unavailable, DataLoss:
+		return true
+	}
+	return false
+}
+
+// IsGRPC reports whether a request or response carries gRPC, going by its
+// Content-Type
+func IsGRPC(h http.Header) bool {
+	contentType := h.Get("Content-Type")
+	return contentType == "application/grpc" ||
+		strings.HasPrefix(contentType, "application/grpc+") ||
+		strings.HasPrefix(contentType, "application/grpc;")
+}
+
+// Status reads grpc-status from response headers or trailers
+func Status(h http.Header) (Code, bool) {
+	value := h.Get("Grpc-Status")
+	if value == "" {
+		return 0, false
+	}
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return Unknown, true
+	}
+	return Code(code), true
+}
+
+// ParseTimeout parses a grpc-timeout header value such as "100m": up to
+// eight digits followed by H, M, S, m, u or n
+func ParseTimeout(value string) (time.Duration, bool) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// WriteStatus answers a call with a trailers-only response: no messages,
+// just the status in the headers
+func WriteStatus(w http.ResponseWriter, code Code, message string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(int(code)))
+	if message != "" {
+		w.Header().Set("Grpc-Message", encodeMessage(message))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// encodeMessage percent-encodes a grpc-message value as the protocol
+// requires
+func encodeMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// maxFrameSize bounds the messages ReadFrame accepts
+const maxFrameSize = 4 << 20
+
+// Frame wraps an uncompressed message in the gRPC length-prefixed framing
+func Frame(message []byte) []byte {
+	frame := make([]byte, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(message)))
+	copy(frame[5:], message)
+	return frame
+}
+
+// ReadFrame reads one uncompressed length-prefixed message
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, errors.New("compressed gRPC messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxFrameSize {
+		return nil, fmt.Errorf("gRPC message of %d bytes is too large", size)
+	}
+	message := make([]byte, size)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
@@ -0,0 +1,142 @@
+package grpcwire
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStatus(t *testing.T) {
+	h := http.Header{}
+	if _, ok := Status(h); ok {
+		t.Error("Expected no status without grpc-status")
+	}
+	h.Set("Grpc-Status", "14")
+	if code, ok := Status(h); !ok || code != Unavailable {
+		t.Errorf("Expected UNAVAILABLE, got %v %v", code, ok)
+	}
+	h.Set("Grpc-Status", "garbage")
+	if code, _ := Status(h); code != Unknown {
+		t.Errorf("Expected UNKNOWN for an unparsable status, got %v", code)
+	}
+}
+
+func TestCode_BackendFault(t *testing.T) {
+	faults := map[Code]bool{
+		OK:                false,
+		Canceled:          false,
+		InvalidArgument:   false,
+		NotFound:          false,
+		ResourceExhausted: false,
+		Unknown:           true,
+		DeadlineExceeded:  true,
+		Internal:          true,
+		Unavailable:       true,
+		DataLoss:          true,
+	}
+	for code, want := range faults {
+		if got := code.BackendFault(); got != want {
+			t.Errorf("%s: expected BackendFault %v, got %v", code, want, got)
+		}
+	}
+	if Code(99).String() != "CODE(99)" {
+		t.Errorf("Unexpected name for an unknown code: %s", Code(99))
+	}
+}
+
+func TestIsGRPC(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"application/grpc":       true,
+		"application/grpc+proto": true,
+		"application/grpc-web":   false,
+		"application/json":       false,
+	} {
+		h := http.Header{"Content-Type": {contentType}}
+		if got := IsGRPC(h); got != want {
+			t.Errorf("%s: expected %v, got %v", contentType, want, got)
+		}
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	valid := map[string]time.Duration{
+		"1H":   time.Hour,
+		"2M":   2 * time.Minute,
+		"3S":   3 * time.Second,
+		"100m": 100 * time.Millisecond,
+		"5u":   5 * time.Microsecond,
+		"7n":   7,
+	}
+	for value, want := range valid {
+		if got, ok := ParseTimeout(value); !ok || got != want {
+			t.Errorf("%s: expected %v, got %v (%v)", value, want, got, ok)
+		}
+	}
+	for _, value := range []string{"", "m", "10", "10x", "123456789S", "-1S"} {
+		if _, ok := ParseTimeout(value); ok {
+			t.Errorf("%q: expected to be rejected", value)
+		}
+	}
+}
+
+func TestWriteStatus(t *testing.T) {
+	rec := httptest.NewRecorder()
+	WriteStatus(rec, Unavailable, "no backend: 100%")
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", rec.Code)
+	}
+	if rec.Header().Get("Grpc-Status") != "14" || rec.Header().Get("Content-Type") != "application/grpc" {
+		t.Errorf("Unexpected headers: %v", rec.Header())
+	}
+	if got := rec.Header().Get("Grpc-Message"); got != "no backend: 100%25" {
+		t.Errorf("Expected percent-encoded message, got %q", got)
+	}
+}
+
+func TestFrame(t *testing.T) {
+	message := []byte("hello")
+	got, err := ReadFrame(bytes.NewReader(Frame(message)))
+	if err != nil || !bytes.Equal(got, message) {
+		t.Errorf("Expected round trip, got %q, %v", got, err)
+	}
+
+	compressed := Frame(message)
+	compressed[0] = 1
+	if _, err := ReadFrame(bytes.NewReader(compressed)); err == nil {
+		t.Error("Expected error for a compressed message")
+	}
+	if _, err := ReadFrame(bytes.NewReader(Frame(message)[:3])); err == nil {
+		t.Error("Expected error for a truncated frame")
+	}
+}
+
+func TestHealthCheckMessages(t *testing.T) {
+	if EncodeHealthCheckRequest("") != nil {
+		t.Error("Expected an empty request for the whole server")
+	}
+	if got := EncodeHealthCheckRequest("echo.Echo"); !bytes.Equal(got, append([]byte{0x0a, 9}, "echo.Echo"...)) {
+		t.Errorf("Unexpected request encoding: %x", got)
+	}
+
+	for _, status := range []ServingStatus{StatusServing, StatusNotServing} {
+		got, err := DecodeHealthCheckResponse(EncodeHealthCheckResponse(status))
+		if err != nil || got != status {
+			t.Errorf("Expected %d, got %d, %v", status, got, err)
+		}
+	}
+
+	// Unknown fields are skipped, an empty message is the default status
+	withExtra := append([]byte{0x12, 2, 'h', 'i'}, EncodeHealthCheckResponse(StatusServing)...)
+	if got, err := DecodeHealthCheckResponse(withExtra); err != nil || got != StatusServing {
+		t.Errorf("Expected SERVING past an unknown field, got %d, %v", got, err)
+	}
+	if got, err := DecodeHealthCheckResponse(nil); err != nil || got != StatusUnknown {
+		t.Errorf("Expected UNKNOWN for an empty response, got %d, %v", got, err)
+	}
+	if _, err := DecodeHealthCheckResponse([]byte{0x12, 10, 'x'}); err == nil {
+		t.Error("Expected error for a truncated field")
+	}
+}
@@ -0,0 +1,74 @@
+package grpcwire
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// HealthCheckPath is the method of the standard gRPC health checking
+// protocol, grpc.health.v1
+const HealthCheckPath = "/grpc.health.v1.Health/Check"
+
+// ServingStatus is grpc.health.v1.HealthCheckResponse.ServingStatus
+type ServingStatus int
+
+const (
+	StatusUnknown ServingStatus = iota
+	StatusServing
+	StatusNotServing
+	StatusServiceUnknown
+)
+
+// EncodeHealthCheckRequest encodes a HealthCheckRequest for the named
+// service; "" asks about the server as a whole
+func EncodeHealthCheckRequest(service string) []byte {
+	if service == "" {
+		return nil
+	}
+	// Field 1 (service), wire type 2 (length-delimited)
+	message := []byte{0x0a}
+	message = binary.AppendUvarint(message, uint64(len(service)))
+	return append(message, service...)
+}
+
+// DecodeHealthCheckResponse reads the status out of a HealthCheckResponse,
+// skipping any fields it doesn't know
+func DecodeHealthCheckResponse(message []byte) (ServingStatus, error) {
+	status := StatusUnknown
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return 0, errors.New("malformed health check response")
+		}
+		message = message[n:]
+
+		field, wireType := key>>3, key&7
+		switch wireType {
+		case 0: // varint
+			value, n := binary.Uvarint(message)
+			if n <= 0 {
+				return 0, errors.New("malformed health check response")
+			}
+			message = message[n:]
+			if field == 1 {
+				status = ServingStatus(value)
+			}
+		case 2: // length-delimited
+			size, n := binary.Uvarint(message)
+			if n <= 0 || uint64(len(message)-n) < size {
+				return 0, errors.New("malformed health check response")
+			}
+			message = message[n+int(size):]
+		default:
+			return 0, errors.New("unexpected field in health check response")
+		}
+	}
+	return status, nil
+}
+
+// EncodeHealthCheckResponse encodes a HealthCheckResponse; backends and
+// tests can use it to answer health checks
+func EncodeHealthCheckResponse(status ServingStatus) []byte {
+	// Field 1 (status), wire type 0 (varint)
+	return binary.AppendUvarint([]byte{0x08}, uint64(status))
+}
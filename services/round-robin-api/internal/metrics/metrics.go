@@ -1,10 +1,15 @@
 package metrics
 
 import (
+	"maps"
+	"math"
+	"sort"
 	"sync"
 	"time"
 
 	"round-robin-api/internal/circuit"
+	"round-robin-api/internal/grpcwire"
+	"round-robin-api/internal/upstream"
 )
 
 // RequestInfo holds information about individual requests
@@ -16,6 +21,43 @@ type RequestInfo struct {
 	Success   bool
 }
 
+// CertificateInfo describes a certificate the load balancer serves
+type CertificateInfo struct {
+	Names            []string  `json:"names"`
+	NotAfter         time.Time `json:"not_after"`
+	ExpiresInSeconds int64     `json:"expires_in_seconds"`
+}
+
+// TCPStats accounts for the connections relayed to a backend in TCP mode
+type TCPStats struct {
+	Active          int64  `json:"active"`
+	Connections     uint64 `json:"connections"`
+	ConnectFailures uint64 `json:"connect_failures"`
+	// BytesSent went from clients to the backend, BytesReceived back
+	BytesSent     uint64 `json:"bytes_sent"`
+	BytesReceived uint64 `json:"bytes_received"`
+}
+
+// UDPStats accounts for the datagrams relayed to a backend in UDP mode
+type UDPStats struct {
+	// Sessions is the number of clients currently mapped to the backend
+	Sessions int64 `json:"sessions"`
+	// DatagramsSent went from clients to the backend, DatagramsReceived back
+	DatagramsSent     uint64 `json:"datagrams_sent"`
+	DatagramsReceived uint64 `json:"datagrams_received"`
+	// PortUnreachable counts ICMP errors saying nothing listens on the
+	// backend's port
+	PortUnreachable uint64 `json:"port_unreachable"`
+}
+
+// latencyWindow is how many recent request durations are kept per backend
+// for percentiles
+const latencyWindow = 100
+
+// minLatencySamples is how many durations a backend needs before its
+// percentiles are considered meaningful
+const minLatencySamples = 20
+
 // Metrics stores various load balancer metrics
 type Metrics struct {
 	mu            sync.RWMutex
@@ -23,7 +65,26 @@ type Metrics struct {
 	ResponseTimes map[string]time.Duration
 	ErrorRates    map[string]float64
 	CircuitStates map[string]circuit.State
+	RetryCounts   map[string]uint64
+	HedgeCounts   map[string]uint64
+	HedgeWins     map[string]uint64
+	// ErrorKinds counts failed requests by why they failed
+	ErrorKinds    map[upstream.Kind]uint64
+	// ActiveTunnels counts upgraded connections, e.g. WebSockets, currently
+	// open to each backend
+	ActiveTunnels map[string]int64
+	// GRPCStatuses counts finished gRPC calls by status name
+	GRPCStatuses  map[string]uint64
+	// Certificates are the TLS certificates served, by certificate file
+	Certificates  map[string]CertificateInfo
+	// TCP accounts for relayed TCP connections, by backend
+	TCP           map[string]TCPStats
+	// UDP accounts for relayed datagrams, by backend
+	UDP           map[string]UDPStats
 	totalErrors   map[string]uint64
+	latencies     map[string][]time.Duration
+	// retriesDenied counts retries the retry budget refused
+	retriesDenied uint64
 
 	// Track recent requests (keep last 100)
 	recentRequests []RequestInfo
@@ -37,7 +98,17 @@ func NewMetrics() *Metrics {
 		ResponseTimes: make(map[string]time.Duration),
 		ErrorRates:    make(map[string]float64),
 		CircuitStates: make(map[string]circuit.State),
+		RetryCounts:   make(map[string]uint64),
+		HedgeCounts:   make(map[string]uint64),
+		HedgeWins:     make(map[string]uint64),
+		ErrorKinds:    make(map[upstream.Kind]uint64),
+		ActiveTunnels: make(map[string]int64),
+		GRPCStatuses:  make(map[string]uint64),
+		Certificates:  make(map[string]CertificateInfo),
+		TCP:           make(map[string]TCPStats),
+		UDP:           make(map[string]UDPStats),
 		totalErrors:   make(map[string]uint64),
+		latencies:     make(map[string][]time.Duration),
 		maxRecents:    100,
 	}
 }
@@ -78,6 +149,162 @@ func (m *Metrics) RecordCircuitState(backend string, state circuit.State) {
 	m.CircuitStates[backend] = state
 }
 
+// RecordRetry records a retry sent to a backend after another one failed
+func (m *Metrics) RecordRetry(backend string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.RetryCounts[backend]++
+}
+
+// RecordRetryDenied records a retry refused by the retry budget
+func (m *Metrics) RecordRetryDenied() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retriesDenied++
+}
+
+// RecordHedge records a hedged copy of a request sent to a backend
+func (m *Metrics) RecordHedge(backend string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.HedgeCounts[backend]++
+}
+
+// RecordHedgeWin records a hedged copy that answered before the original
+func (m *Metrics) RecordHedgeWin(backend string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.HedgeWins[backend]++
+}
+
+// RecordErrorKind records why a request failed
+func (m *Metrics) RecordErrorKind(kind upstream.Kind) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ErrorKinds[kind]++
+}
+
+// RecordTunnelOpen records an upgraded connection opened to a backend
+func (m *Metrics) RecordTunnelOpen(backend string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ActiveTunnels[backend]++
+}
+
+// RecordTunnelClose records an upgraded connection to a backend closing
+func (m *Metrics) RecordTunnelClose(backend string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ActiveTunnels[backend]--
+}
+
+// RecordGRPCStatus records the status a gRPC call ended with
+func (m *Metrics) RecordGRPCStatus(code grpcwire.Code) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.GRPCStatuses[code.String()]++
+}
+
+// RecordCertificate records a TLS certificate being loaded or reloaded
+func (m *Metrics) RecordCertificate(file string, names []string, notAfter time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Certificates[file] = CertificateInfo{Names: names, NotAfter: notAfter}
+}
+
+// RecordTCPOpen records a TCP connection opened to a backend
+func (m *Metrics) RecordTCPOpen(backend string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.TCP[backend]
+	stats.Active++
+	stats.Connections++
+	m.TCP[backend] = stats
+}
+
+// RecordTCPClose records a TCP connection to a backend closing
+func (m *Metrics) RecordTCPClose(backend string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.TCP[backend]
+	stats.Active--
+	m.TCP[backend] = stats
+}
+
+// RecordTCPBytes adds bytes relayed to (sent) and from (received) a backend
+func (m *Metrics) RecordTCPBytes(backend string, sent, received int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.TCP[backend]
+	stats.BytesSent += uint64(sent)
+	stats.BytesReceived += uint64(received)
+	m.TCP[backend] = stats
+}
+
+// RecordConnectFailure records a TCP connection a backend couldn't accept
+func (m *Metrics) RecordConnectFailure(backend string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.TCP[backend]
+	stats.ConnectFailures++
+	m.TCP[backend] = stats
+}
+
+// RecordUDPSessionOpen records a UDP client mapped to a backend
+func (m *Metrics) RecordUDPSessionOpen(backend string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.UDP[backend]
+	stats.Sessions++
+	m.UDP[backend] = stats
+}
+
+// RecordUDPSessionClose records a UDP session to a backend expiring
+func (m *Metrics) RecordUDPSessionClose(backend string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.UDP[backend]
+	stats.Sessions--
+	m.UDP[backend] = stats
+}
+
+// RecordDatagram records a datagram relayed to (sent) or from a backend
+func (m *Metrics) RecordDatagram(backend string, sent bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.UDP[backend]
+	if sent {
+		stats.DatagramsSent++
+	} else {
+		stats.DatagramsReceived++
+	}
+	m.UDP[backend] = stats
+}
+
+// RecordPortUnreachable records an ICMP port unreachable from a UDP backend
+func (m *Metrics) RecordPortUnreachable(backend string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.UDP[backend]
+	stats.PortUnreachable++
+	m.UDP[backend] = stats
+}
+
+// LatencyPercentile returns the p-th percentile (0 < p <= 1) of a backend's
+// recent request durations, or false if there are too few samples
+func (m *Metrics) LatencyPercentile(backend string, p float64) (time.Duration, bool) {
+	m.mu.RLock()
+	samples := append([]time.Duration(nil), m.latencies[backend]...)
+	m.mu.RUnlock()
+
+	if len(samples) < minLatencySamples || p <= 0 || p > 1 {
+		return 0, false
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	idx := int(math.Ceil(p*float64(len(samples)))) - 1
+	return samples[idx], true
+}
+
 // RecordRequestComplete records a completed request with full details
 func (m *Metrics) RecordRequestComplete(id string, backend string, duration time.Duration, success bool) {
 	m.mu.Lock()
@@ -112,6 +339,13 @@ func (m *Metrics) RecordRequestComplete(id string, backend string, duration time
 	} else {
 		m.ResponseTimes[backend] = duration
 	}
+
+	// Keep a window of raw durations for percentiles
+	samples := append(m.latencies[backend], duration)
+	if len(samples) > latencyWindow {
+		samples = samples[len(samples)-latencyWindow:]
+	}
+	m.latencies[backend] = samples
 }
 
 // GetMetrics returns a copy of current metrics
@@ -123,11 +357,43 @@ func (m *Metrics) GetMetrics() map[string]interface{} {
 	recentsCopy := make([]RequestInfo, len(m.recentRequests))
 	copy(recentsCopy, m.recentRequests)
 
+	// Time left is worked out now, so it counts down between reloads
+	certificates := make(map[string]CertificateInfo, len(m.Certificates))
+	for file, info := range m.Certificates {
+		info.ExpiresInSeconds = int64(time.Until(info.NotAfter).Seconds())
+		certificates[file] = info
+	}
+
+	tcp := make(map[string]TCPStats, len(m.TCP))
+	for backend, stats := range m.TCP {
+		tcp[backend] = stats
+	}
+	// The session table size is the sum of every backend's sessions
+	udp := make(map[string]UDPStats, len(m.UDP))
+	var udpSessions int64
+	for backend, stats := range m.UDP {
+		udp[backend] = stats
+		udpSessions += stats.Sessions
+	}
+
+	// Maps the proxy keeps writing to are copied, so they can be encoded
+	// after the lock is released
 	return map[string]interface{}{
-		"request_counts":  m.RequestCounts,
-		"response_times": m.ResponseTimes,
-		"error_rates":    m.ErrorRates,
-		"circuit_states": m.CircuitStates,
+		"request_counts":  maps.Clone(m.RequestCounts),
+		"response_times": maps.Clone(m.ResponseTimes),
+		"error_rates":    maps.Clone(m.ErrorRates),
+		"circuit_states": maps.Clone(m.CircuitStates),
 		"recent_requests": recentsCopy,
+		"retry_counts":   maps.Clone(m.RetryCounts),
+		"retries_denied": m.retriesDenied,
+		"hedge_counts":   maps.Clone(m.HedgeCounts),
+		"hedge_wins":     maps.Clone(m.HedgeWins),
+		"error_kinds":    maps.Clone(m.ErrorKinds),
+		"active_tunnels": maps.Clone(m.ActiveTunnels),
+		"grpc_statuses":  maps.Clone(m.GRPCStatuses),
+		"certificates":   certificates,
+		"tcp":            tcp,
+		"udp":            udp,
+		"udp_sessions":   udpSessions,
 	}
 }
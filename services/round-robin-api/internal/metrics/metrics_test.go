@@ -3,6 +3,8 @@ package metrics
 import (
 	"fmt"
 	"round-robin-api/internal/circuit"
+	"round-robin-api/internal/grpcwire"
+	"round-robin-api/internal/upstream"
 	"testing"
 	"time"
 )
@@ -212,3 +214,200 @@ func TestMetrics_RecentRequestsLimit(t *testing.T) {
 		}
 	}
 }
+
+func TestMetrics_RecordRetry(t *testing.T) {
+	m := NewMetrics()
+	m.RecordRetry("backend-b")
+	m.RecordRetry("backend-b")
+	m.RecordRetryDenied()
+
+	if count := m.RetryCounts["backend-b"]; count != 2 {
+		t.Errorf("Expected 2 retries, got %d", count)
+	}
+
+	metrics := m.GetMetrics()
+	if denied, ok := metrics["retries_denied"].(uint64); !ok || denied != 1 {
+		t.Errorf("Expected 1 denied retry in metrics, got %v", metrics["retries_denied"])
+	}
+	if _, ok := metrics["retry_counts"]; !ok {
+		t.Error("Metrics should include retry_counts")
+	}
+}
+
+func TestMetrics_LatencyPercentile(t *testing.T) {
+	m := NewMetrics()
+	backend := "test-backend"
+
+	for i := 1; i < minLatencySamples; i++ {
+		m.RecordRequestComplete(fmt.Sprintf("req-%d", i), backend, time.Duration(i)*time.Millisecond, true)
+	}
+	if _, ok := m.LatencyPercentile(backend, 0.95); ok {
+		t.Fatal("Expected no percentile before enough samples")
+	}
+
+	for i := minLatencySamples; i <= latencyWindow+50; i++ {
+		m.RecordRequestComplete(fmt.Sprintf("req-%d", i), backend, time.Duration(i)*time.Millisecond, true)
+	}
+
+	// Only the last 100 durations (51ms..150ms) are kept
+	p95, ok := m.LatencyPercentile(backend, 0.95)
+	if !ok || p95 != 145*time.Millisecond {
+		t.Errorf("Expected p95 of 145ms, got %v (ok=%v)", p95, ok)
+	}
+	if p50, _ := m.LatencyPercentile(backend, 0.5); p50 != 100*time.Millisecond {
+		t.Errorf("Expected p50 of 100ms, got %v", p50)
+	}
+}
+
+func TestMetrics_RecordHedge(t *testing.T) {
+	m := NewMetrics()
+	m.RecordHedge("backend-b")
+	m.RecordHedge("backend-b")
+	m.RecordHedgeWin("backend-b")
+
+	if m.HedgeCounts["backend-b"] != 2 || m.HedgeWins["backend-b"] != 1 {
+		t.Errorf("Expected 2 hedges and 1 win, got %d and %d", m.HedgeCounts["backend-b"], m.HedgeWins["backend-b"])
+	}
+	metrics := m.GetMetrics()
+	if _, ok := metrics["hedge_wins"]; !ok {
+		t.Error("Metrics should include hedge_wins")
+	}
+}
+
+func TestMetrics_GetMetricsCopies(t *testing.T) {
+	m := NewMetrics()
+	m.RecordRetry("backend-a")
+	m.RecordErrorKind(upstream.Reset)
+	metrics := m.GetMetrics()
+
+	// The snapshot is encoded after the lock is released, while requests
+	// keep being recorded
+	m.RecordRetry("backend-a")
+	m.RecordHedge("backend-a")
+	m.RecordErrorKind(upstream.Reset)
+	m.RecordTunnelOpen("backend-a")
+	m.RecordGRPCStatus(grpcwire.OK)
+
+	if got := metrics["retry_counts"].(map[string]uint64)["backend-a"]; got != 1 {
+		t.Errorf("Expected the snapshot to keep 1 retry, got %d", got)
+	}
+	if got := metrics["error_kinds"].(map[upstream.Kind]uint64)[upstream.Reset]; got != 1 {
+		t.Errorf("Expected the snapshot to keep 1 reset, got %d", got)
+	}
+	for _, key := range []string{"hedge_counts", "active_tunnels", "grpc_statuses"} {
+		if fmt.Sprint(metrics[key]) != "map[]" {
+			t.Errorf("Expected %s to be unchanged in the snapshot, got %v", key, metrics[key])
+		}
+	}
+}
+
+func TestMetrics_RecordErrorKind(t *testing.T) {
+	m := NewMetrics()
+	m.RecordErrorKind(upstream.Timeout)
+	m.RecordErrorKind(upstream.Timeout)
+	m.RecordErrorKind(upstream.ClientCancelled)
+
+	if m.ErrorKinds[upstream.Timeout] != 2 || m.ErrorKinds[upstream.ClientCancelled] != 1 {
+		t.Errorf("Unexpected error kinds: %v", m.ErrorKinds)
+	}
+	if _, ok := m.GetMetrics()["error_kinds"]; !ok {
+		t.Error("Metrics should include error_kinds")
+	}
+}
+
+func TestMetrics_RecordTunnel(t *testing.T) {
+	m := NewMetrics()
+	m.RecordTunnelOpen("backend-a")
+	m.RecordTunnelOpen("backend-a")
+	m.RecordTunnelClose("backend-a")
+
+	if m.ActiveTunnels["backend-a"] != 1 {
+		t.Errorf("Expected 1 active tunnel, got %d", m.ActiveTunnels["backend-a"])
+	}
+	metrics := m.GetMetrics()
+	if _, ok := metrics["active_tunnels"]; !ok {
+		t.Error("Metrics should include active_tunnels")
+	}
+}
+
+func TestMetrics_RecordGRPCStatus(t *testing.T) {
+	m := NewMetrics()
+	m.RecordGRPCStatus(grpcwire.OK)
+	m.RecordGRPCStatus(grpcwire.Unavailable)
+	m.RecordGRPCStatus(grpcwire.OK)
+
+	if m.GRPCStatuses["OK"] != 2 || m.GRPCStatuses["UNAVAILABLE"] != 1 {
+		t.Errorf("Unexpected gRPC statuses: %v", m.GRPCStatuses)
+	}
+	if _, ok := m.GetMetrics()["grpc_statuses"]; !ok {
+		t.Error("Metrics should include grpc_statuses")
+	}
+}
+
+func TestMetrics_RecordCertificate(t *testing.T) {
+	m := NewMetrics()
+	notAfter := time.Now().Add(48 * time.Hour)
+	m.RecordCertificate("/etc/tls/site.pem", []string{"site.example.com"}, notAfter)
+
+	certificates, ok := m.GetMetrics()["certificates"].(map[string]CertificateInfo)
+	if !ok {
+		t.Fatal("Metrics should include certificates")
+	}
+	info := certificates["/etc/tls/site.pem"]
+	if !info.NotAfter.Equal(notAfter) || info.Names[0] != "site.example.com" {
+		t.Errorf("Unexpected certificate info: %+v", info)
+	}
+	if info.ExpiresInSeconds <= 47*3600 || info.ExpiresInSeconds > 48*3600 {
+		t.Errorf("Expected about 48h to expiry, got %ds", info.ExpiresInSeconds)
+	}
+}
+
+func TestMetrics_RecordTCP(t *testing.T) {
+	m := NewMetrics()
+	m.RecordTCPOpen("tcp://redis-1:6379")
+	m.RecordTCPOpen("tcp://redis-1:6379")
+	m.RecordTCPBytes("tcp://redis-1:6379", 10, 200)
+	m.RecordTCPBytes("tcp://redis-1:6379", 5, 0)
+	m.RecordTCPClose("tcp://redis-1:6379")
+	m.RecordConnectFailure("tcp://redis-2:6379")
+
+	tcp, ok := m.GetMetrics()["tcp"].(map[string]TCPStats)
+	if !ok {
+		t.Fatal("Metrics should include tcp")
+	}
+	want := TCPStats{Active: 1, Connections: 2, BytesSent: 15, BytesReceived: 200}
+	if got := tcp["tcp://redis-1:6379"]; got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+	if got := tcp["tcp://redis-2:6379"].ConnectFailures; got != 1 {
+		t.Errorf("Expected 1 connect failure, got %d", got)
+	}
+}
+
+func TestMetrics_RecordUDP(t *testing.T) {
+	m := NewMetrics()
+	m.RecordUDPSessionOpen("udp://dns-1:53")
+	m.RecordUDPSessionOpen("udp://dns-1:53")
+	m.RecordUDPSessionOpen("udp://dns-2:53")
+	m.RecordUDPSessionClose("udp://dns-1:53")
+	m.RecordDatagram("udp://dns-1:53", true)
+	m.RecordDatagram("udp://dns-1:53", true)
+	m.RecordDatagram("udp://dns-1:53", false)
+	m.RecordPortUnreachable("udp://dns-2:53")
+
+	metrics := m.GetMetrics()
+	udp, ok := metrics["udp"].(map[string]UDPStats)
+	if !ok {
+		t.Fatal("Metrics should include udp")
+	}
+	want := UDPStats{Sessions: 1, DatagramsSent: 2, DatagramsReceived: 1}
+	if got := udp["udp://dns-1:53"]; got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+	if got := udp["udp://dns-2:53"].PortUnreachable; got != 1 {
+		t.Errorf("Expected 1 port unreachable, got %d", got)
+	}
+	if got := metrics["udp_sessions"]; got != int64(2) {
+		t.Errorf("Expected 2 sessions in the table, got %v", got)
+	}
+}
@@ -0,0 +1,112 @@
+package proxyproto
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+
+	"round-robin-api/internal/forward"
+	"round-robin-api/internal/logger"
+)
+
+// HeaderTimeout bounds how long a trusted peer has to send its header
+const HeaderTimeout = 5 * time.Second
+
+// Listener accepts connections that start with a PROXY protocol header,
+// sent by another load balancer in front of this one. The header's addresses
+// become the connection's RemoteAddr and LocalAddr, so the real client shows
+// in logs and X-Forwarded-For. Only trusted peers may speak for a
+// client: connections from elsewhere are served as they are, without
+// looking for a header. With no trusted ranges, every peer must send one.
+type Listener struct {
+	net.Listener
+	trusted forward.TrustedProxies
+	logger  *logger.Logger
+}
+
+// NewListener wraps a listener to read PROXY protocol headers from trusted
+// peers
+func NewListener(inner net.Listener, trusted forward.TrustedProxies, appLogger *logger.Logger) *Listener {
+	return &Listener{Listener: inner, trusted: trusted, logger: appLogger}
+}
+
+// Accept returns the next connection. Its header is read when it is first
+// used, so a slow peer doesn't hold up the others.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if len(l.trusted) > 0 {
+		host, _, _ := net.SplitHostPort(c.RemoteAddr().String())
+		if !l.trusted.Trusts(host) {
+			return c, nil
+		}
+	}
+	return &Conn{Conn: c, reader: bufio.NewReader(c), logger: l.logger}, nil
+}
+
+// Conn is a connection whose PROXY protocol header is read before anything
+// else is
+type Conn struct {
+	net.Conn
+	reader *bufio.Reader
+	logger *logger.Logger
+
+	once   sync.Once
+	header *Header
+	err    error
+}
+
+// readHeader reads the header once. A connection without a valid one
+// fails every read, so it is dropped by whoever serves it.
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(HeaderTimeout))
+		c.header, c.err = ReadHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			c.logger.Warn("Dropping connection from %s: invalid PROXY protocol header: %v", c.Conn.RemoteAddr(), c.err)
+		}
+	})
+}
+
+// Err reads the header, if that hasn't happened yet, and reports whether it
+// was invalid
+func (c *Conn) Err() error {
+	c.readHeader()
+	return c.err
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	if err := c.Err(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(p)
+}
+
+// RemoteAddr is the client the header names, or the peer's own address
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.Err() == nil && c.header.Source != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr is the address the client connected to according to the
+// header, or the connection's own
+func (c *Conn) LocalAddr() net.Addr {
+	if c.Err() == nil && c.header.Destination != nil {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// CloseWrite passes on the end of the stream, for relayed TCP connections
+func (c *Conn) CloseWrite() error {
+	if conn, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return conn.CloseWrite()
+	}
+	return c.Conn.Close()
+}
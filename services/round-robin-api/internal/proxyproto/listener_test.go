@@ -0,0 +1,79 @@
+package proxyproto
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"round-robin-api/internal/forward"
+	"round-robin-api/internal/logger"
+)
+
+// accept opens a listener, connects to it and sends data, and returns the
+// connection as accepted
+func accept(t *testing.T, trusted string, data string) net.Conn {
+	t.Helper()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { inner.Close() })
+	proxies, err := forward.ParseTrustedProxies(trusted)
+	if err != nil {
+		t.Fatalf("Invalid trusted proxies: %v", err)
+	}
+	listener := NewListener(inner, proxies, logger.New(logger.ERROR))
+
+	client, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	client.Write([]byte(data))
+	client.(*net.TCPConn).CloseWrite()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestListener_Trusted(t *testing.T) {
+	conn := accept(t, "127.0.0.0/8", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nhello")
+	if got := conn.RemoteAddr().String(); got != "192.0.2.1:56324" {
+		t.Errorf("Expected the client from the header, got %s", got)
+	}
+	if got := conn.LocalAddr().String(); got != "198.51.100.1:443" {
+		t.Errorf("Expected the destination from the header, got %s", got)
+	}
+	if data, _ := io.ReadAll(conn); string(data) != "hello" {
+		t.Errorf("Expected the data after the header, got %q", data)
+	}
+}
+
+func TestListener_Untrusted(t *testing.T) {
+	// Only trusted peers may name a client; others are served as they are
+	conn := accept(t, "10.0.0.0/8", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n")
+	if host, _, _ := net.SplitHostPort(conn.RemoteAddr().String()); host != "127.0.0.1" {
+		t.Errorf("Expected the peer's own address, got %s", conn.RemoteAddr())
+	}
+	if data, _ := io.ReadAll(conn); string(data) != "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n" {
+		t.Errorf("Expected the data as sent, got %q", data)
+	}
+}
+
+func TestListener_MissingHeader(t *testing.T) {
+	// With no trusted ranges every peer must send a header
+	conn := accept(t, "", "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	if _, err := conn.Read(make([]byte, 16)); err == nil {
+		t.Error("Expected reads to fail without a header")
+	}
+	if err := conn.(*Conn).Err(); err != ErrNoHeader {
+		t.Errorf("Expected ErrNoHeader, got %v", err)
+	}
+	if host, _, _ := net.SplitHostPort(conn.RemoteAddr().String()); host != "127.0.0.1" {
+		t.Errorf("Expected the peer's own address, got %s", conn.RemoteAddr())
+	}
+}
@@ -0,0 +1,298 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// PROXY protocol versions that can be sent to backends
+const (
+	// Version1 is the human-readable text header
+	Version1 = "v1"
+	// Version2 is the binary header
+	Version2 = "v2"
+)
+
+// ValidateVersion checks a PROXY protocol version name; empty means none
+func ValidateVersion(version string) error {
+	switch version {
+	case "", Version1, Version2:
+		return nil
+	}
+	return fmt.Errorf("unknown PROXY protocol version %q: must be %s or %s", version, Version1, Version2)
+}
+
+// ErrNoHeader is returned for a connection that doesn't start with a PROXY
+// protocol header
+var ErrNoHeader = errors.New("no PROXY protocol header")
+
+var (
+	v1Prefix = []byte("PROXY ")
+	// v2Signature opens every version 2 header
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// v1MaxLength is the longest a version 1 header can be, CRLF included
+const v1MaxLength = 107
+
+// Version 2 commands, address families and transport protocols
+const (
+	v2Local = 0x20
+	v2Proxy = 0x21
+
+	v2Unspec = 0x0
+	v2Inet   = 0x1
+	v2Inet6  = 0x2
+	v2Unix   = 0x3
+
+	v2Stream = 0x1
+	v2Dgram  = 0x2
+)
+
+// Header is the connection a proxy in front reports having accepted.
+// Source and Destination are nil when the proxy doesn't relay a client's
+// connection, e.g. for its own health checks, or doesn't know the addresses;
+// the connection's own addresses stand then.
+type Header struct {
+	Source      net.Addr
+	Destination net.Addr
+}
+
+// ReadHeader reads a version 1 or 2 header from the start of a connection
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	// Version 2's signature is the longer prefix; no version 1 header is
+	// shorter than it
+	start, err := r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(start, v2Signature):
+		return readV2(r)
+	case bytes.HasPrefix(start, v1Prefix):
+		return readV1(r)
+	}
+	return nil, ErrNoHeader
+}
+
+// readV1 parses e.g. "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == v1MaxLength {
+			return nil, errors.New("PROXY protocol v1 header too long")
+		}
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, c)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return &Header{}, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 header %q", line)
+	}
+	source, err := parseV1Address(fields[2], fields[4], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	destination, err := parseV1Address(fields[3], fields[5], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	return &Header{Source: source, Destination: destination}, nil
+}
+
+func parseV1Address(ip, port string, v4 bool) (*net.TCPAddr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Is4() != v4 || addr.Zone() != "" {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 address %q", ip)
+	}
+	// Ports are plain decimal, without sign or leading zeros
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || strconv.FormatUint(n, 10) != port {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 port %q", port)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(n))), nil
+}
+
+// readV2 parses the binary header: signature, command, address family and
+// protocol, length and then the addresses, which may be followed by TLVs
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	switch fixed[12] {
+	case v2Local:
+		return &Header{}, nil
+	case v2Proxy:
+	default:
+		return nil, fmt.Errorf("invalid PROXY protocol v2 version and command 0x%02x", fixed[12])
+	}
+
+	family, protocol := fixed[13]>>4, fixed[13]&0xf
+	var size int
+	switch family {
+	case v2Unspec:
+		return &Header{}, nil
+	case v2Inet:
+		size = 4
+	case v2Inet6:
+		size = 16
+	case v2Unix:
+		return readV2Unix(payload, protocol)
+	default:
+		return nil, fmt.Errorf("invalid PROXY protocol v2 address family %d", family)
+	}
+	if len(payload) < 2*size+4 {
+		return nil, errors.New("PROXY protocol v2 addresses truncated")
+	}
+	sourceIP, _ := netip.AddrFromSlice(payload[:size])
+	destinationIP, _ := netip.AddrFromSlice(payload[size : 2*size])
+	source := netip.AddrPortFrom(sourceIP, binary.BigEndian.Uint16(payload[2*size:]))
+	destination := netip.AddrPortFrom(destinationIP, binary.BigEndian.Uint16(payload[2*size+2:]))
+
+	if protocol == v2Dgram {
+		return &Header{Source: net.UDPAddrFromAddrPort(source), Destination: net.UDPAddrFromAddrPort(destination)}, nil
+	}
+	return &Header{Source: net.TCPAddrFromAddrPort(source), Destination: net.TCPAddrFromAddrPort(destination)}, nil
+}
+
+// readV2Unix parses a pair of socket paths, each NUL-padded to 108 bytes
+func readV2Unix(payload []byte, protocol byte) (*Header, error) {
+	const size = 108
+	if len(payload) < 2*size {
+		return nil, errors.New("PROXY protocol v2 addresses truncated")
+	}
+	network := "unix"
+	if protocol == v2Dgram {
+		network = "unixgram"
+	}
+	path := func(b []byte) string {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		return string(b)
+	}
+	return &Header{
+		Source:      &net.UnixAddr{Name: path(payload[:size]), Net: network},
+		Destination: &net.UnixAddr{Name: path(payload[size : 2*size]), Net: network},
+	}, nil
+}
+
+// addrPort returns the IP address and port of a TCP or UDP address
+func addrPort(addr net.Addr) (netip.AddrPort, bool) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.AddrPort(), true
+	case *net.UDPAddr:
+		return a.AddrPort(), true
+	}
+	return netip.AddrPort{}, false
+}
+
+// ipAddresses returns the header's addresses as IPv4 addresses if both are,
+// and as IPv6 otherwise
+func (h Header) ipAddresses() (source, destination netip.AddrPort, v4, ok bool) {
+	if h.Source == nil || h.Destination == nil {
+		return
+	}
+	source, ok1 := addrPort(h.Source)
+	destination, ok2 := addrPort(h.Destination)
+	if !ok1 || !ok2 {
+		return
+	}
+	sourceIP, destinationIP := source.Addr().Unmap(), destination.Addr().Unmap()
+	if v4 = sourceIP.Is4() && destinationIP.Is4(); !v4 {
+		sourceIP = netip.AddrFrom16(sourceIP.As16())
+		destinationIP = netip.AddrFrom16(destinationIP.As16())
+	}
+	return netip.AddrPortFrom(sourceIP, source.Port()), netip.AddrPortFrom(destinationIP, destination.Port()), v4, true
+}
+
+// Format encodes the header in the given version. A header without IP
+// addresses says the addresses are unknown, or in version 2 that the
+// connection is the proxy's own when it has no addresses at all.
+func (h Header) Format(version string) []byte {
+	source, destination, v4, ok := h.ipAddresses()
+	if version == Version1 {
+		if !ok {
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+		family := "TCP6"
+		if v4 {
+			family = "TCP4"
+		}
+		return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", family, source.Addr(), destination.Addr(), source.Port(), destination.Port())
+	}
+
+	header := append([]byte{}, v2Signature...)
+	switch {
+	case h.Source == nil && h.Destination == nil:
+		return append(header, v2Local, v2Unspec<<4, 0, 0)
+	case !ok:
+		return append(header, v2Proxy, v2Unspec<<4, 0, 0)
+	}
+	family, size := byte(v2Inet6), 16
+	if v4 {
+		family, size = v2Inet, 4
+	}
+	header = append(header, v2Proxy, family<<4|v2Stream)
+	header = binary.BigEndian.AppendUint16(header, uint16(2*size+4))
+	header = append(header, source.Addr().AsSlice()...)
+	header = append(header, destination.Addr().AsSlice()...)
+	header = binary.BigEndian.AppendUint16(header, source.Port())
+	return binary.BigEndian.AppendUint16(header, destination.Port())
+}
+
+type headerKey struct{}
+
+// WithHeader records the client connection a request or connection relays,
+// to be announced to backends by a Dialer
+func WithHeader(ctx context.Context, header Header) context.Context {
+	return context.WithValue(ctx, headerKey{}, header)
+}
+
+// DialFunc opens a connection, like net.Dialer's DialContext
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// Dialer sends a header in the given version at the start of every
+// connection dial opens, announcing the client recorded on the context by
+// WithHeader. Connections dialed without one, such as health checks, are
+// announced as the proxy's own. An empty version returns dial unchanged.
+func Dialer(dial DialFunc, version string) DialFunc {
+	if version == "" {
+		return dial
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		header, _ := ctx.Value(headerKey{}).(Header)
+		if _, err := conn.Write(header.Format(version)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
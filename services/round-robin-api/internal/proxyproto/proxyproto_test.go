@@ -0,0 +1,175 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func read(t *testing.T, data string) (*Header, string, error) {
+	t.Helper()
+	r := bufio.NewReader(strings.NewReader(data))
+	header, err := ReadHeader(r)
+	rest, _ := io.ReadAll(r)
+	return header, string(rest), err
+}
+
+func TestReadHeader_V1(t *testing.T) {
+	header, rest, err := read(t, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET / HTTP/1.1\r\n")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if header.Source.String() != "192.0.2.1:56324" || header.Destination.String() != "198.51.100.1:443" {
+		t.Errorf("Unexpected addresses: %v -> %v", header.Source, header.Destination)
+	}
+	if rest != "GET / HTTP/1.1\r\n" {
+		t.Errorf("Expected the data after the header to be left, got %q", rest)
+	}
+
+	header, _, err = read(t, "PROXY TCP6 2001:db8::1 2001:db8::2 1234 80\r\n")
+	if err != nil || header.Source.String() != "[2001:db8::1]:1234" {
+		t.Errorf("Unexpected TCP6 header: %+v, %v", header, err)
+	}
+	header, _, err = read(t, "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n")
+	if err != nil || header.Source != nil || header.Destination != nil {
+		t.Errorf("Expected UNKNOWN to leave the addresses unset, got %+v, %v", header, err)
+	}
+}
+
+func TestReadHeader_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"no header":        "GET / HTTP/1.1\r\nHost: a\r\n\r\n",
+		"family mismatch":  "PROXY TCP4 2001:db8::1 192.0.2.1 1 2\r\n",
+		"bad port":         "PROXY TCP4 192.0.2.1 192.0.2.2 65536 2\r\n",
+		"leading zero":     "PROXY TCP4 192.0.2.1 192.0.2.2 080 2\r\n",
+		"missing field":    "PROXY TCP4 192.0.2.1 192.0.2.2 80\r\n",
+		"unknown protocol": "PROXY UDP4 192.0.2.1 192.0.2.2 1 2\r\n",
+		"too long":         "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n",
+		"v2 bad command":   string(v2Signature) + "\x22\x11\x00\x0c" + strings.Repeat("\x00", 12),
+		"v2 truncated":     string(v2Signature) + "\x21\x11\x00\x04\x00\x00\x00\x00",
+		"v2 short read":    string(v2Signature) + "\x21\x11\x00\x0c\x00",
+	}
+	for name, data := range tests {
+		if _, _, err := read(t, data); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestReadHeader_V2(t *testing.T) {
+	// PROXY over TCP/IPv4 followed by a TLV the parser must skip
+	data := string(v2Signature) + "\x21\x11\x00\x0f" +
+		"\xc0\x00\x02\x01" + "\xc6\x33\x64\x01" + "\xdc\x04" + "\x01\xbb" +
+		"\x04\x00\x00" + "payload"
+	header, rest, err := read(t, data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if header.Source.String() != "192.0.2.1:56324" || header.Destination.String() != "198.51.100.1:443" {
+		t.Errorf("Unexpected addresses: %v -> %v", header.Source, header.Destination)
+	}
+	if rest != "payload" {
+		t.Errorf("Expected the data after the header to be left, got %q", rest)
+	}
+
+	header, rest, err = read(t, string(v2Signature)+"\x20\x00\x00\x00"+"payload")
+	if err != nil || header.Source != nil || rest != "payload" {
+		t.Errorf("Expected a LOCAL header without addresses, got %+v, %q, %v", header, rest, err)
+	}
+
+	unix := string(v2Signature) + "\x21\x31\x00\xd8" + "/run/a.sock" + strings.Repeat("\x00", 97) + "/run/b.sock" + strings.Repeat("\x00", 97)
+	header, _, err = read(t, unix)
+	if err != nil || header.Source.String() != "/run/a.sock" || header.Destination.Network() != "unix" {
+		t.Errorf("Unexpected unix header: %+v, %v", header, err)
+	}
+}
+
+func TestHeader_Format(t *testing.T) {
+	client := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 56324}
+	local := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443}
+	client6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}
+
+	tests := []struct {
+		name    string
+		header  Header
+		version string
+		want    string
+	}{
+		{"v1 TCP4", Header{Source: client, Destination: local}, Version1, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"},
+		{"v1 mixed", Header{Source: client6, Destination: local}, Version1, "PROXY TCP6 2001:db8::1 ::ffff:198.51.100.1 1234 443\r\n"},
+		{"v1 unknown", Header{}, Version1, "PROXY UNKNOWN\r\n"},
+		{"v1 unix", Header{Source: &net.UnixAddr{Name: "@"}, Destination: local}, Version1, "PROXY UNKNOWN\r\n"},
+	}
+	for _, tt := range tests {
+		if got := string(tt.header.Format(tt.version)); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// Version 2 headers are checked by reading them back
+	for _, header := range []Header{{Source: client, Destination: local}, {Source: client6, Destination: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 80}}} {
+		got, _, err := read(t, string(header.Format(Version2)))
+		if err != nil {
+			t.Fatalf("Failed to read back v2 header: %v", err)
+		}
+		if got.Source.String() != header.Source.String() || got.Destination.String() != header.Destination.String() {
+			t.Errorf("v2 round trip: got %v -> %v, want %v -> %v", got.Source, got.Destination, header.Source, header.Destination)
+		}
+	}
+	if local := (Header{}).Format(Version2); local[12] != v2Local {
+		t.Errorf("Expected a LOCAL command without addresses, got 0x%02x", local[12])
+	}
+}
+
+func TestValidateVersion(t *testing.T) {
+	for _, version := range []string{"", Version1, Version2} {
+		if err := ValidateVersion(version); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", version, err)
+		}
+	}
+	if err := ValidateVersion("v3"); err == nil {
+		t.Error("Expected error for v3")
+	}
+}
+
+// recordingConn captures what is written to it
+type recordingConn struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) { return c.written.Write(p) }
+func (c *recordingConn) Close() error                { return nil }
+
+func TestDialer(t *testing.T) {
+	var conn *recordingConn
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn = &recordingConn{}
+		return conn, nil
+	}
+
+	client := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 56324}
+	local := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443}
+	ctx := WithHeader(context.Background(), Header{Source: client, Destination: local})
+	if _, err := Dialer(dial, Version1)(ctx, "tcp", "backend:80"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := conn.written.String(); got != "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n" {
+		t.Errorf("Unexpected header: %q", got)
+	}
+
+	// Health checks carry no client
+	Dialer(dial, Version1)(context.Background(), "tcp", "backend:80")
+	if got := conn.written.String(); got != "PROXY UNKNOWN\r\n" {
+		t.Errorf("Expected an UNKNOWN header, got %q", got)
+	}
+
+	Dialer(dial, "")(ctx, "tcp", "backend:80")
+	if conn.written.Len() != 0 {
+		t.Errorf("Expected no header without a version, got %q", conn.written.String())
+	}
+}
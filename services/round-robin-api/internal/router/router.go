@@ -0,0 +1,257 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Route maps matching requests onto a backend pool, optionally rewriting
+// the path prefix before the request is forwarded. Every matcher that is set
+// must match; a route with no matchers matches every request.
+type Route struct {
+	// Host matches the request host, ignoring the port; "*.example.com"
+	// matches any subdomain
+	Host   string `json:"host,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+	// PathRegex is matched against the request path
+	PathRegex string   `json:"path_regex,omitempty"`
+	Methods   []string `json:"methods,omitempty"`
+	// Headers must all be present with the given value; an empty value
+	// only requires the header to be present
+	Headers map[string]string `json:"headers,omitempty"`
+	Pool    string            `json:"pool"`
+	// StripPrefix removes Prefix from the forwarded path
+	StripPrefix bool `json:"strip_prefix,omitempty"`
+	// ReplacePrefix substitutes Prefix in the forwarded path
+	ReplacePrefix string `json:"replace_prefix,omitempty"`
+
+	pathRegex *regexp.Regexp
+}
+
+// String describes the route's matchers, for logs and error messages
+func (rt Route) String() string {
+	var parts []string
+	if rt.Host != "" {
+		parts = append(parts, "host="+rt.Host)
+	}
+	if rt.Prefix != "" {
+		parts = append(parts, "prefix="+rt.Prefix)
+	}
+	if rt.PathRegex != "" {
+		parts = append(parts, "path_regex="+rt.PathRegex)
+	}
+	if len(rt.Methods) > 0 {
+		parts = append(parts, "methods="+strings.Join(rt.Methods, ","))
+	}
+	for name := range rt.Headers {
+		parts = append(parts, "header="+name)
+	}
+	if len(parts) == 0 {
+		return "*"
+	}
+	return strings.Join(parts, " ")
+}
+
+// Validate checks that the route is usable
+func (rt Route) Validate() error {
+	if rt.Prefix != "" && !strings.HasPrefix(rt.Prefix, "/") {
+		return fmt.Errorf("route prefix %q must start with /", rt.Prefix)
+	}
+	if rt.Pool == "" {
+		return fmt.Errorf("route %s: pool is required", rt)
+	}
+	if rt.PathRegex != "" {
+		if _, err := regexp.Compile(rt.PathRegex); err != nil {
+			return fmt.Errorf("route %s: invalid path_regex: %v", rt, err)
+		}
+	}
+	if (rt.StripPrefix || rt.ReplacePrefix != "") && rt.Prefix == "" {
+		return fmt.Errorf("route %s: strip_prefix and replace_prefix require a prefix", rt)
+	}
+	if rt.StripPrefix && rt.ReplacePrefix != "" {
+		return fmt.Errorf("route %s: strip_prefix and replace_prefix are mutually exclusive", rt)
+	}
+	if rt.ReplacePrefix != "" && !strings.HasPrefix(rt.ReplacePrefix, "/") {
+		return fmt.Errorf("route %s: replace_prefix %q must start with /", rt, rt.ReplacePrefix)
+	}
+	return nil
+}
+
+// compile validates the route and caches its path regex
+func (rt *Route) compile() error {
+	if err := rt.Validate(); err != nil {
+		return err
+	}
+	if rt.PathRegex != "" {
+		rt.pathRegex = regexp.MustCompile(rt.PathRegex)
+	}
+	return nil
+}
+
+// Matches reports whether the route applies to the request. The prefix only
+// matches on segment boundaries, so /users matches /users and /users/42 but
+// not /usersettings. It is matched against the escaped path that Rewrite
+// works on, so /users%2F42 is a single segment and doesn't match /users.
+func (rt Route) Matches(r *http.Request) bool {
+	return rt.matchesHost(r.Host) &&
+		rt.matchesPrefix(r.URL.EscapedPath()) &&
+		rt.matchesRegex(r.URL.Path) &&
+		rt.matchesMethod(r.Method) &&
+		rt.matchesHeaders(r.Header)
+}
+
+func (rt Route) matchesHost(host string) bool {
+	if rt.Host == "" {
+		return true
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	pattern := strings.ToLower(rt.Host)
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
+
+func (rt Route) matchesPrefix(path string) bool {
+	if rt.Prefix == "" {
+		return true
+	}
+	prefix := escapePath(rt.Prefix)
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) ||
+		strings.HasSuffix(prefix, "/") ||
+		path[len(prefix)] == '/'
+}
+
+func (rt Route) matchesRegex(path string) bool {
+	if rt.PathRegex == "" {
+		return true
+	}
+	if rt.pathRegex != nil {
+		return rt.pathRegex.MatchString(path)
+	}
+	// Route was built without a table; compile on the fly
+	matched, err := regexp.MatchString(rt.PathRegex, path)
+	return err == nil && matched
+}
+
+func (rt Route) matchesMethod(method string) bool {
+	if len(rt.Methods) == 0 {
+		return true
+	}
+	for _, m := range rt.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rt Route) matchesHeaders(header http.Header) bool {
+	for name, value := range rt.Headers {
+		values, ok := header[http.CanonicalHeaderKey(name)]
+		if !ok {
+			return false
+		}
+		if value == "" {
+			continue
+		}
+		found := false
+		for _, v := range values {
+			if v == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Rewrite returns the escaped path to forward to the backend, given the
+// request's escaped path
+func (rt Route) Rewrite(path string) string {
+	switch {
+	case rt.StripPrefix:
+		path = strings.TrimPrefix(path, escapePath(rt.Prefix))
+	case rt.ReplacePrefix != "":
+		rest := strings.TrimPrefix(strings.TrimPrefix(path, escapePath(rt.Prefix)), "/")
+		replace := escapePath(rt.ReplacePrefix)
+		if rest == "" {
+			path = replace
+		} else {
+			path = strings.TrimSuffix(replace, "/") + "/" + rest
+		}
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// escapePath escapes a configured path the way it appears in a request
+func escapePath(path string) string {
+	return (&url.URL{Path: path}).EscapedPath()
+}
+
+// Table is an ordered list of routes; the first matching route wins
+type Table struct {
+	sync.RWMutex
+	routes []Route
+}
+
+// NewTable validates the routes and builds a table from them
+func NewTable(routes []Route) (*Table, error) {
+	t := &Table{}
+	if err := t.SetRoutes(routes); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// SetRoutes validates the routes and replaces the whole table with them
+func (t *Table) SetRoutes(routes []Route) error {
+	compiled := append([]Route(nil), routes...)
+	for i := range compiled {
+		if err := compiled[i].compile(); err != nil {
+			return err
+		}
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	t.routes = compiled
+	return nil
+}
+
+// Match returns the first route matching the request
+func (t *Table) Match(r *http.Request) (Route, bool) {
+	t.RLock()
+	defer t.RUnlock()
+
+	for _, rt := range t.routes {
+		if rt.Matches(r) {
+			return rt, true
+		}
+	}
+	return Route{}, false
+}
+
+// Routes returns a copy of the routes in match order
+func (t *Table) Routes() []Route {
+	t.RLock()
+	defer t.RUnlock()
+	return append([]Route(nil), t.routes...)
+}
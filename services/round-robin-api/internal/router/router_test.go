@@ -0,0 +1,167 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRoute_Matches(t *testing.T) {
+	rt := Route{Prefix: "/users", Pool: "users"}
+
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{"/users", true},
+		{"/users/", true},
+		{"/users/42", true},
+		{"/usersettings", false},
+		{"/users%2F42", false},
+		{"/us%65rs/42", false},
+		{"/orders", false},
+		{"/", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if got := rt.Matches(r); got != tt.expected {
+			t.Errorf("Matches(%s) = %v, expected %v", tt.path, got, tt.expected)
+		}
+	}
+
+	spaced := Route{Prefix: "/my docs", Pool: "docs"}
+	if !spaced.Matches(httptest.NewRequest(http.MethodGet, "/my%20docs/a", nil)) {
+		t.Error("Prefix should match its escaped form")
+	}
+
+	root := Route{Prefix: "/", Pool: "default"}
+	if !root.Matches(httptest.NewRequest(http.MethodGet, "/anything", nil)) {
+		t.Error("Root prefix should match every path")
+	}
+}
+
+func TestRoute_Matchers(t *testing.T) {
+	tests := []struct {
+		name     string
+		route    Route
+		method   string
+		target   string
+		header   map[string]string
+		expected bool
+	}{
+		{"host", Route{Host: "api.example.com"}, "GET", "http://api.example.com:8080/x", nil, true},
+		{"host mismatch", Route{Host: "api.example.com"}, "GET", "http://www.example.com/x", nil, false},
+		{"wildcard host", Route{Host: "*.example.com"}, "GET", "http://Eu.Example.com/x", nil, true},
+		{"wildcard host apex", Route{Host: "*.example.com"}, "GET", "http://example.com/x", nil, false},
+		{"regex", Route{PathRegex: `^/users/[0-9]+$`}, "GET", "/users/42", nil, true},
+		{"regex mismatch", Route{PathRegex: `^/users/[0-9]+$`}, "GET", "/users/me", nil, false},
+		{"method", Route{Methods: []string{"get", "HEAD"}}, "GET", "/", nil, true},
+		{"method mismatch", Route{Methods: []string{"GET"}}, "POST", "/", nil, false},
+		{"header value", Route{Headers: map[string]string{"x-version": "2"}}, "GET", "/", map[string]string{"X-Version": "2"}, true},
+		{"header wrong value", Route{Headers: map[string]string{"X-Version": "2"}}, "GET", "/", map[string]string{"X-Version": "1"}, false},
+		{"header present", Route{Headers: map[string]string{"X-Canary": ""}}, "GET", "/", map[string]string{"X-Canary": "yes"}, true},
+		{"header missing", Route{Headers: map[string]string{"X-Canary": ""}}, "GET", "/", nil, false},
+		{"combined", Route{Host: "api.example.com", Prefix: "/users", Methods: []string{"DELETE"}}, "DELETE", "http://api.example.com/users/1", nil, true},
+		{"combined partial", Route{Host: "api.example.com", Prefix: "/users", Methods: []string{"DELETE"}}, "GET", "http://api.example.com/users/1", nil, false},
+		{"catch-all", Route{}, "PATCH", "/anything", nil, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		for k, v := range tt.header {
+			r.Header.Set(k, v)
+		}
+		if got := tt.route.Matches(r); got != tt.expected {
+			t.Errorf("%s: Matches = %v, expected %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestRoute_Rewrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		route    Route
+		path     string
+		expected string
+	}{
+		{"passthrough", Route{Prefix: "/users"}, "/users/42", "/users/42"},
+		{"strip", Route{Prefix: "/users", StripPrefix: true}, "/users/42", "/42"},
+		{"strip exact", Route{Prefix: "/users", StripPrefix: true}, "/users", "/"},
+		{"replace", Route{Prefix: "/users", ReplacePrefix: "/v2/accounts"}, "/users/42", "/v2/accounts/42"},
+		{"replace trailing slash", Route{Prefix: "/users/", ReplacePrefix: "/v2/"}, "/users/42", "/v2/42"},
+		{"replace with root", Route{Prefix: "/api", ReplacePrefix: "/"}, "/api", "/"},
+		{"replace exact", Route{Prefix: "/svc", ReplacePrefix: "/v2"}, "/svc", "/v2"},
+		{"strip keeps escapes", Route{Prefix: "/files", StripPrefix: true}, "/files/a%2Fb", "/a%2Fb"},
+		{"strip escaped prefix", Route{Prefix: "/my docs", StripPrefix: true}, "/my%20docs/a", "/a"},
+		{"replace escaped", Route{Prefix: "/a", ReplacePrefix: "/b c"}, "/a/x%3F", "/b%20c/x%3F"},
+	}
+	for _, tt := range tests {
+		if got := tt.route.Rewrite(tt.path); got != tt.expected {
+			t.Errorf("%s: Rewrite(%s) = %s, expected %s", tt.name, tt.path, got, tt.expected)
+		}
+	}
+}
+
+func TestNewTable_Validation(t *testing.T) {
+	invalid := []Route{
+		{Prefix: "users", Pool: "users"},
+		{Prefix: "/users"},
+		{Prefix: "/users", Pool: "users", StripPrefix: true, ReplacePrefix: "/v2"},
+		{Prefix: "/users", Pool: "users", ReplacePrefix: "v2"},
+		{PathRegex: "(", Pool: "users"},
+		{Host: "api.example.com", Pool: "users", StripPrefix: true},
+	}
+	for _, rt := range invalid {
+		if _, err := NewTable([]Route{rt}); err == nil {
+			t.Errorf("Expected route %+v to be rejected", rt)
+		}
+	}
+}
+
+func TestTable_FirstMatchWins(t *testing.T) {
+	table, err := NewTable([]Route{
+		{Prefix: "/users/admin", Pool: "admin"},
+		{Prefix: "/users", Pool: "users"},
+		{Prefix: "/", Pool: "default"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := map[string]string{
+		"/users/admin/1": "admin",
+		"/users/1":       "users",
+		"/orders":        "default",
+	}
+	for path, pool := range tests {
+		rt, ok := table.Match(httptest.NewRequest(http.MethodGet, path, nil))
+		if !ok || rt.Pool != pool {
+			t.Errorf("Expected %s to route to %s, got %s (ok=%v)", path, pool, rt.Pool, ok)
+		}
+	}
+
+	empty, _ := NewTable(nil)
+	if _, ok := empty.Match(httptest.NewRequest(http.MethodGet, "/", nil)); ok {
+		t.Error("Empty table should not match")
+	}
+}
+
+func TestTable_SetRoutes(t *testing.T) {
+	table, _ := NewTable([]Route{{Prefix: "/", Pool: "default"}})
+
+	if err := table.SetRoutes([]Route{{PathRegex: "(", Pool: "x"}}); err == nil {
+		t.Fatal("Expected invalid routes to be rejected")
+	}
+	if rt, _ := table.Match(httptest.NewRequest(http.MethodGet, "/", nil)); rt.Pool != "default" {
+		t.Fatal("Rejected update should leave the table unchanged")
+	}
+
+	if err := table.SetRoutes([]Route{{PathRegex: "^/v[0-9]/", Pool: "versioned"}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rt, ok := table.Match(httptest.NewRequest(http.MethodGet, "/v2/items", nil)); !ok || rt.Pool != "versioned" {
+		t.Errorf("Expected regex route to match, got %+v (ok=%v)", rt, ok)
+	}
+	if _, ok := table.Match(httptest.NewRequest(http.MethodGet, "/", nil)); ok {
+		t.Error("Old routes should be gone after SetRoutes")
+	}
+}
@@ -0,0 +1,175 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// Kind classifies why a request to a backend failed
+type Kind string
+
+const (
+	// DialRefused means the backend refused the connection
+	DialRefused Kind = "dial_refused"
+	// DNS means the backend's host name couldn't be resolved
+	DNS Kind = "dns"
+	// TLS means the TLS handshake or certificate verification failed
+	TLS Kind = "tls"
+	// Timeout means the backend didn't answer within the configured timeouts
+	Timeout Kind = "timeout"
+	// Reset means the backend closed or reset the connection mid-request
+	Reset Kind = "reset"
+	// ClientCancelled means the client went away or the request was
+	// abandoned, e.g. because a hedged copy answered first
+	ClientCancelled Kind = "client_cancelled"
+	// ClientDeadline means a deadline the client asked for ran out
+	ClientDeadline Kind = "client_deadline"
+	// BodyTooLarge means the request body exceeded the size limit
+	BodyTooLarge Kind = "body_too_large"
+	// Other is any failure that doesn't fit the kinds above
+	Other Kind = "other"
+)
+
+// ErrClientDeadline is the context cause for a client-supplied deadline, so
+// it can be told apart from the pool's own timeout
+var ErrClientDeadline = errors.New("client deadline exceeded")
+
+// StatusCode returns the status to answer the client with
+func (k Kind) StatusCode() int {
+	switch k {
+	case Timeout, ClientDeadline:
+		return http.StatusGatewayTimeout
+	case ClientCancelled:
+		// nginx's "client closed request"; nobody is left to read it
+		return 499
+	case BodyTooLarge:
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// BackendFault reports whether the failure says something about the
+// backend's health, and so should count towards its circuit breaker
+func (k Kind) BackendFault() bool {
+	switch k {
+	case ClientCancelled, ClientDeadline, BodyTooLarge:
+		return false
+	}
+	return true
+}
+
+// NotSent reports whether the request failed before it reached the
+// backend, so the backend can't have acted on it
+func (k Kind) NotSent() bool {
+	switch k {
+	case DialRefused, DNS, TLS:
+		return true
+	}
+	return false
+}
+
+// Error is a failed request to a backend
+type Error struct {
+	Kind    Kind
+	Backend string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Backend == "" {
+		return fmt.Sprintf("%s: %v", e.Kind, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %v", e.Backend, e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewError classifies err and wraps it with the backend it came from. ctx is
+// the request's context, which tells client cancellations apart from
+// backend timeouts; it may be nil.
+func NewError(ctx context.Context, backend string, err error) *Error {
+	return &Error{Kind: Classify(ctx, err), Backend: backend, Err: err}
+}
+
+// KindOf returns the kind of an error returned by NewError, or Other
+func KindOf(err error) Kind {
+	var upstreamErr *Error
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.Kind
+	}
+	return Other
+}
+
+// Classify works out the kind of a failed request. The request's context
+// takes precedence, since a cancelled request fails with whatever error the
+// transport happened to be in the middle of.
+func Classify(ctx context.Context, err error) Kind {
+	if ctx != nil && ctx.Err() != nil {
+		cause := context.Cause(ctx)
+		switch {
+		case cause == ErrClientDeadline:
+			return ClientDeadline
+		case errors.Is(cause, context.DeadlineExceeded):
+			// Deadlines enforced by cancelling with a timeout cause
+			return Timeout
+		case errors.Is(ctx.Err(), context.Canceled):
+			return ClientCancelled
+		default:
+			return Timeout
+		}
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &maxBytesErr):
+		return BodyTooLarge
+	case errors.Is(err, context.Canceled):
+		return ClientCancelled
+	case errors.Is(err, context.DeadlineExceeded):
+		return Timeout
+	case errors.As(err, &dnsErr):
+		if dnsErr.IsTimeout {
+			return Timeout
+		}
+		return DNS
+	case isTLSError(err):
+		return TLS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return Timeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return DialRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return Reset
+	}
+	return Other
+}
+
+func isTLSError(err error) bool {
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var verifyErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	return errors.As(err, &recordErr) ||
+		errors.As(err, &alertErr) ||
+		errors.As(err, &verifyErr) ||
+		errors.As(err, &unknownAuthority) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidErr)
+}
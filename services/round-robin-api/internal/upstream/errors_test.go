@@ -0,0 +1,166 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func get(t *testing.T, ctx context.Context, client *http.Client, target string) error {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("Expected request to fail")
+	}
+	return err
+}
+
+func TestClassify_TransportErrors(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+
+	reset := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer reset.Close()
+
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsServer.Close()
+
+	// A port nothing listens on
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	refusedURL := "http://" + listener.Addr().String()
+	listener.Close()
+
+	client := &http.Client{}
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  func() error
+		kind Kind
+	}{
+		{"refused", ctx, func() error { return get(t, ctx, client, refusedURL) }, DialRefused},
+		{"reset", ctx, func() error { return get(t, ctx, client, reset.URL) }, Reset},
+		{"tls", ctx, func() error { return get(t, ctx, client, tlsServer.URL) }, TLS},
+		{"client timeout", ctx, func() error {
+			return get(t, ctx, &http.Client{Timeout: 20 * time.Millisecond}, slow.URL)
+		}, Timeout},
+	}
+	for _, tt := range tests {
+		if kind := Classify(tt.ctx, tt.err()); kind != tt.kind {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.kind, kind)
+		}
+	}
+
+	// Context outcomes take precedence over the transport error
+	deadline, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if kind := Classify(deadline, get(t, deadline, client, slow.URL)); kind != Timeout {
+		t.Errorf("deadline: expected %s, got %s", Timeout, kind)
+	}
+
+	clientDeadline, cancel := context.WithTimeoutCause(ctx, 20*time.Millisecond, ErrClientDeadline)
+	defer cancel()
+	if kind := Classify(clientDeadline, get(t, clientDeadline, client, slow.URL)); kind != ClientDeadline {
+		t.Errorf("client deadline: expected %s, got %s", ClientDeadline, kind)
+	}
+
+	idle, cancelIdle := context.WithCancelCause(ctx)
+	time.AfterFunc(20*time.Millisecond, func() { cancelIdle(fmt.Errorf("stream idle: %w", context.DeadlineExceeded)) })
+	if kind := Classify(idle, get(t, idle, client, slow.URL)); kind != Timeout {
+		t.Errorf("timeout cause: expected %s, got %s", Timeout, kind)
+	}
+
+	cancelled, cancelNow := context.WithCancel(ctx)
+	time.AfterFunc(20*time.Millisecond, cancelNow)
+	if kind := Classify(cancelled, get(t, cancelled, client, slow.URL)); kind != ClientCancelled {
+		t.Errorf("cancelled: expected %s, got %s", ClientCancelled, kind)
+	}
+}
+
+func TestClassify_WrappedErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		kind Kind
+	}{
+		{"dns", &url.Error{Op: "Get", URL: "http://x", Err: &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "x", IsNotFound: true}}}, DNS},
+		{"body too large", &http.MaxBytesError{Limit: 10}, BodyTooLarge},
+		{"other", errors.New("something else"), Other},
+		{"nil", nil, ""},
+	}
+	for _, tt := range tests {
+		if kind := Classify(nil, tt.err); kind != tt.kind {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.kind, kind)
+		}
+	}
+}
+
+func TestKind_StatusCodeAndFault(t *testing.T) {
+	tests := []struct {
+		kind   Kind
+		status int
+		fault  bool
+	}{
+		{DialRefused, http.StatusBadGateway, true},
+		{DNS, http.StatusBadGateway, true},
+		{TLS, http.StatusBadGateway, true},
+		{Reset, http.StatusBadGateway, true},
+		{Timeout, http.StatusGatewayTimeout, true},
+		{ClientDeadline, http.StatusGatewayTimeout, false},
+		{ClientCancelled, 499, false},
+		{BodyTooLarge, http.StatusRequestEntityTooLarge, false},
+		{Other, http.StatusBadGateway, true},
+	}
+	for _, tt := range tests {
+		if got := tt.kind.StatusCode(); got != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.kind, tt.status, got)
+		}
+		if got := tt.kind.BackendFault(); got != tt.fault {
+			t.Errorf("%s: expected BackendFault %v, got %v", tt.kind, tt.fault, got)
+		}
+	}
+}
+
+func TestKind_NotSent(t *testing.T) {
+	for _, kind := range []Kind{DialRefused, DNS, TLS} {
+		if !kind.NotSent() {
+			t.Errorf("%s: expected the request not to have been sent", kind)
+		}
+	}
+	for _, kind := range []Kind{Timeout, Reset, ClientCancelled, ClientDeadline, BodyTooLarge, Other} {
+		if kind.NotSent() {
+			t.Errorf("%s: expected the request to possibly have been sent", kind)
+		}
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	err := NewError(nil, "http://backend:80", &http.MaxBytesError{Limit: 10})
+	wrapped := errors.Join(errors.New("forwarding failed"), err)
+	if kind := KindOf(wrapped); kind != BodyTooLarge {
+		t.Errorf("Expected %s, got %s", BodyTooLarge, kind)
+	}
+	if kind := KindOf(errors.New("plain")); kind != Other {
+		t.Errorf("Expected %s for a plain error, got %s", Other, kind)
+	}
+}
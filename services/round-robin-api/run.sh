@@ -29,4 +29,4 @@ echo "🔗 Load balancer will be available at: http://localhost:8080"
 echo "---"
 
 # Run the Go application
-go run cmd/main.go
+go run ./cmd